@@ -7,8 +7,7 @@ rm -rf /var/run/pulse /var/lib/pulse /root/.config/pulse
 # Start pulseaudio as system wide daemon; for debugging it helps to start in non-daemon mode
 pulseaudio -D --verbose --exit-idle-time=-1 --system --disallow-exit --disable-shm=true --log-time=true
 
-# Load audio sink
-pactl load-module module-null-sink sink_name="grab" sink_properties=device.description="monitorOUT"
+# Audio sinks are created by the recorder itself, one per job.
 
 # Forward signals to service
 RECORDER_PID=0
@@ -56,15 +55,30 @@ term_handler() {
 # On callback, kill the last background process, which is `tail -f /dev/null` and execute the specified handler
 trap 'kill ${!}; term_handler' SIGTERM
 
-# Add custom CA certificate to the OS trust store if provided.
-# This allows the Go HTTP client (via SystemCertPool) to trust self-signed certs.
-# For Chromium, pass --ignore-certificate-errors via EXTRA_CHROMIUM_ARGS.
+RECORDER_USER=calls
+
+# Custom TLS material is loaded by the recorder itself, which also provisions
+# Chromium's NSS database with it. We copy the files over so that they are
+# readable by the unprivileged user regardless of how they were mounted.
+TLS_DIR=/home/$RECORDER_USER/.tls
+mkdir -p $TLS_DIR
 if [ -n "${TLS_CA_CERT_FILE:-}" ]; then
-  cp "$TLS_CA_CERT_FILE" /usr/local/share/ca-certificates/calls-recorder-ca.crt
-  update-ca-certificates
+  cp "$TLS_CA_CERT_FILE" $TLS_DIR/ca.crt
+  TLS_CA_CERT_FILE=$TLS_DIR/ca.crt
 fi
-
-RECORDER_USER=calls
+if [ -n "${TLS_CLIENT_CERT_FILE:-}" ] && [ -n "${TLS_CLIENT_KEY_FILE:-}" ]; then
+  cp "$TLS_CLIENT_CERT_FILE" $TLS_DIR/client.crt
+  cp "$TLS_CLIENT_KEY_FILE" $TLS_DIR/client.key
+  TLS_CLIENT_CERT_FILE=$TLS_DIR/client.crt
+  TLS_CLIENT_KEY_FILE=$TLS_DIR/client.key
+
+  # Let Chromium present the client certificate without prompting, only to
+  # the Mattermost site (scheme://host[:port] of SITE_URL).
+  SITE_ORIGIN=$(echo "$SITE_URL" | cut -d/ -f1-3)
+  mkdir -p /etc/chromium/policies/managed
+  printf '{"AutoSelectCertificateForUrls": ["{\\"pattern\\":\\"%s\\",\\"filter\\":{}}"]}\n' "$SITE_ORIGIN" > /etc/chromium/policies/managed/calls-recorder.json
+fi
+chmod -R go-rwx $TLS_DIR
 
 # Create scoped (by jobID) data path.
 mkdir -p /data/$RECORDING_ID
@@ -91,8 +105,52 @@ runuser -l $RECORDER_USER -c \
   FRAME_RATE=${FRAME_RATE:-0} \
   VIDEO_PRESET=${VIDEO_PRESET:-} \
   OUTPUT_FORMAT=${OUTPUT_FORMAT:-} \
+  CAPTURE_MODE=${CAPTURE_MODE:-} \
+  LOCALE=${LOCALE:-} \
+  TIMEZONE=${TIMEZONE:-} \
+  DEVICE_SCALE=${DEVICE_SCALE:-} \
+  ATTENDANCE_REPORT=${ATTENDANCE_REPORT:-false} \
+  IDLE_TIMEOUT=${IDLE_TIMEOUT:-0} \
+  MAX_DURATION=${MAX_DURATION:-0} \
+  MAX_FILE_SIZE=${MAX_FILE_SIZE:-0} \
+  STORAGE_TARGETS=${STORAGE_TARGETS:-} \
+  STREAMING_UPLOAD=${STREAMING_UPLOAD:-false} \
   DEV_MODE=${DEV_MODE:-false} \
-  EXTRA_CHROMIUM_ARGS="${EXTRA_CHROMIUM_ARGS:-}" \
+  EXTRA_CHROMIUM_ARGS=$(printf %q "${EXTRA_CHROMIUM_ARGS:-}") \
+  EXTRA_CHROMIUM_ARGS_ALLOW=$(printf %q "${EXTRA_CHROMIUM_ARGS_ALLOW:-}") \
+  EXTRA_CHROMIUM_ARGS_DENY=$(printf %q "${EXTRA_CHROMIUM_ARGS_DENY:-}") \
+  NETWORK_CAPTURE=${NETWORK_CAPTURE:-false} \
+  SCREENSHOTS_INTERVAL=${SCREENSHOTS_INTERVAL:-} \
+  SCREENSHOTS_MAX=${SCREENSHOTS_MAX:-} \
+  WEBRTC_STATS_INTERVAL=${WEBRTC_STATS_INTERVAL:-} \
+  AUDIO_SILENCE_TIMEOUT=${AUDIO_SILENCE_TIMEOUT:-} \
+  LIMITS_WARNING_LEAD_TIME=${LIMITS_WARNING_LEAD_TIME:-} \
+  CHROMIUM_MONITOR_INTERVAL=${CHROMIUM_MONITOR_INTERVAL:-} \
+  CHROMIUM_MEMORY_LIMIT_MB=${CHROMIUM_MEMORY_LIMIT_MB:-} \
+  CUSTOM_CSS_FILE=${CUSTOM_CSS_FILE:-} \
+  CUSTOM_JS_FILE=${CUSTOM_JS_FILE:-} \
+  LOG_REDACT_PATTERNS=$(printf %q "${LOG_REDACT_PATTERNS:-}") \
+  HTTP_PROXY=$(printf %q "${HTTP_PROXY:-${http_proxy:-}}") \
+  HTTPS_PROXY=$(printf %q "${HTTPS_PROXY:-${https_proxy:-}}") \
+  SOCKS_PROXY=$(printf %q "${SOCKS_PROXY:-${socks_proxy:-}}") \
+  NO_PROXY=$(printf %q "${NO_PROXY:-${no_proxy:-}}") \
+  PROXY_PAC_URL=$(printf %q "${PROXY_PAC_URL:-}") \
+  TLS_CA_CERT_FILE=${TLS_CA_CERT_FILE:-} \
+  TLS_CLIENT_CERT_FILE=${TLS_CLIENT_CERT_FILE:-} \
+  TLS_CLIENT_KEY_FILE=${TLS_CLIENT_KEY_FILE:-} \
+  HOST_MAPPINGS=$(printf %q "${HOST_MAPPINGS:-}") \
+  INSECURE_ORIGINS=$(printf %q "${INSECURE_ORIGINS:-}") \
+  DAEMON_LISTEN_ADDR=${DAEMON_LISTEN_ADDR:-} \
+  DAEMON_MAX_JOBS=${DAEMON_MAX_JOBS:-} \
+  DAEMON_API_TOKEN=$(printf %q "${DAEMON_API_TOKEN:-}") \
+  STORAGE_S3_ENDPOINT=${STORAGE_S3_ENDPOINT:-} \
+  STORAGE_S3_REGION=${STORAGE_S3_REGION:-} \
+  STORAGE_S3_BUCKET=${STORAGE_S3_BUCKET:-} \
+  STORAGE_S3_PREFIX=$(printf %q "${STORAGE_S3_PREFIX:-}") \
+  STORAGE_S3_PATH_STYLE=${STORAGE_S3_PATH_STYLE:-} \
+  STORAGE_S3_ACCESS_KEY_ID=$(printf %q "${STORAGE_S3_ACCESS_KEY_ID:-}") \
+  STORAGE_S3_SECRET_ACCESS_KEY=$(printf %q "${STORAGE_S3_SECRET_ACCESS_KEY:-}") \
+  STORAGE_LOCAL_PATH=$(printf %q "${STORAGE_LOCAL_PATH:-}") \
   XDG_RUNTIME_DIR=/home/$RECORDER_USER/.cache/xdgr \
   /bin/bash -c '/opt/calls-recorder/bin/calls-recorder; echo \$? > ${RECORDER_EXIT_CODE_FILE}'" &
 
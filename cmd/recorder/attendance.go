@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	attendanceReportJSONSuffix = "-attendance.json"
+	attendanceReportCSVSuffix  = "-attendance.csv"
+)
+
+type attendanceSession struct {
+	SessionID string `json:"session_id"`
+	JoinAt    int64  `json:"join_at"`
+	LeaveAt   int64  `json:"leave_at"`
+}
+
+type attendanceParticipant struct {
+	UserID string `json:"user_id"`
+	// Sum of the duration of all the user's sessions, in milliseconds.
+	TotalTime int64               `json:"total_time"`
+	Sessions  []attendanceSession `json:"sessions"`
+}
+
+type attendanceReport struct {
+	CallID       string                  `json:"call_id"`
+	RecordingID  string                  `json:"recording_id"`
+	StartAt      int64                   `json:"start_at"`
+	EndAt        int64                   `json:"end_at"`
+	Participants []attendanceParticipant `json:"participants"`
+}
+
+// attendanceTracker keeps track of who was in the call and when, based on
+// the events forwarded by the browser.
+type attendanceTracker struct {
+	mut sync.Mutex
+
+	// user ID of the recording bot, excluded from the report.
+	botUserID string
+	startAt   int64
+	// sessionID -> userID, for sessions that are currently in the call.
+	active map[string]string
+	// sessionID -> session
+	sessions map[string]*attendanceSession
+	// sessionID -> userID
+	users map[string]string
+}
+
+func newAttendanceTracker() *attendanceTracker {
+	return &attendanceTracker{
+		active:   map[string]string{},
+		sessions: map[string]*attendanceSession{},
+		users:    map[string]string{},
+	}
+}
+
+func (t *attendanceTracker) join(userID, sessionID string, at int64) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+
+	if userID == "" || sessionID == "" {
+		return
+	}
+
+	if t.startAt == 0 || at < t.startAt {
+		t.startAt = at
+	}
+
+	if _, ok := t.active[sessionID]; ok {
+		return
+	}
+
+	t.active[sessionID] = userID
+	t.users[sessionID] = userID
+	t.sessions[sessionID] = &attendanceSession{
+		SessionID: sessionID,
+		JoinAt:    at,
+	}
+}
+
+func (t *attendanceTracker) leave(sessionID string, at int64) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+
+	if _, ok := t.active[sessionID]; !ok {
+		return
+	}
+	delete(t.active, sessionID)
+	t.sessions[sessionID].LeaveAt = at
+}
+
+func (t *attendanceTracker) setBotUserID(userID string) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	t.botUserID = userID
+}
+
+// activeParticipants returns the number of sessions currently in the call,
+// excluding the bot's.
+func (t *attendanceTracker) activeParticipants() int {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+
+	var n int
+	for _, userID := range t.active {
+		if userID != t.botUserID {
+			n++
+		}
+	}
+	return n
+}
+
+func (t *attendanceTracker) handleEvent(ev BrowserEvent) {
+	switch ev.Type {
+	case BrowserEventTypeUserJoined:
+		t.join(ev.UserID, ev.SessionID, ev.Timestamp)
+	case BrowserEventTypeUserLeft:
+		t.leave(ev.SessionID, ev.Timestamp)
+	}
+}
+
+// report generates the attendance report. Sessions that are still active are
+// considered to have ended at endAt.
+func (t *attendanceTracker) report(callID, recordingID string, endAt int64) attendanceReport {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+
+	participants := map[string]*attendanceParticipant{}
+	for sessionID, session := range t.sessions {
+		userID := t.users[sessionID]
+		if userID == t.botUserID {
+			continue
+		}
+
+		s := *session
+		if s.LeaveAt == 0 || s.LeaveAt > endAt {
+			s.LeaveAt = endAt
+		}
+
+		p := participants[userID]
+		if p == nil {
+			p = &attendanceParticipant{UserID: userID}
+			participants[userID] = p
+		}
+		p.Sessions = append(p.Sessions, s)
+		if d := s.LeaveAt - s.JoinAt; d > 0 {
+			p.TotalTime += d
+		}
+	}
+
+	report := attendanceReport{
+		CallID:       callID,
+		RecordingID:  recordingID,
+		StartAt:      t.startAt,
+		EndAt:        endAt,
+		Participants: make([]attendanceParticipant, 0, len(participants)),
+	}
+	for _, p := range participants {
+		sort.Slice(p.Sessions, func(i, j int) bool {
+			return p.Sessions[i].JoinAt < p.Sessions[j].JoinAt
+		})
+		report.Participants = append(report.Participants, *p)
+	}
+	sort.Slice(report.Participants, func(i, j int) bool {
+		if report.Participants[i].Sessions[0].JoinAt == report.Participants[j].Sessions[0].JoinAt {
+			return report.Participants[i].UserID < report.Participants[j].UserID
+		}
+		return report.Participants[i].Sessions[0].JoinAt < report.Participants[j].Sessions[0].JoinAt
+	})
+
+	return report
+}
+
+func formatAttendanceTime(ms int64) string {
+	return time.UnixMilli(ms).UTC().Format(time.RFC3339)
+}
+
+func (r attendanceReport) writeJSON(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal report: %w", err)
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// writeCSV writes a per-user summary of the report.
+func (r attendanceReport) writeCSV(path string) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	w := csv.NewWriter(file)
+	records := [][]string{
+		{"user_id", "first_join_at", "last_leave_at", "sessions", "total_seconds"},
+	}
+	for _, p := range r.Participants {
+		var lastLeaveAt int64
+		for _, s := range p.Sessions {
+			if s.LeaveAt > lastLeaveAt {
+				lastLeaveAt = s.LeaveAt
+			}
+		}
+		records = append(records, []string{
+			p.UserID,
+			formatAttendanceTime(p.Sessions[0].JoinAt),
+			formatAttendanceTime(lastLeaveAt),
+			strconv.Itoa(len(p.Sessions)),
+			strconv.FormatInt(p.TotalTime/1000, 10),
+		})
+	}
+	if err := w.WriteAll(records); err != nil {
+		return fmt.Errorf("failed to write records: %w", err)
+	}
+
+	return file.Close()
+}
+
+// seedAttendance initializes the tracker with the sessions that were
+// already in the call by the time the recorder joined, since those won't
+// generate any join event. On failure, the tracker only knows about
+// participants joining from now on.
+func (rec *Recorder) seedAttendance() error {
+	ctx, cancelFn := context.WithTimeout(context.Background(), httpRequestTimeout)
+	defer cancelFn()
+
+	if me, _, err := rec.client.GetMe(ctx, ""); err != nil {
+		// Not fatal, the bot just gets counted as a participant.
+		slog.Warn("failed to get bot user", slog.String("err", err.Error()))
+	} else {
+		rec.attendance.setBotUserID(me.Id)
+	}
+
+	url := fmt.Sprintf("%s/plugins/%s/calls/%s", rec.cfg.SiteURL, pluginID, rec.cfg.CallID)
+	resp, err := rec.client.DoAPIRequest(ctx, http.MethodGet, url, "", "")
+	if err != nil {
+		return fmt.Errorf("failed to get call state: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var state struct {
+		Call *struct {
+			Sessions []struct {
+				SessionID string `json:"session_id"`
+				UserID    string `json:"user_id"`
+			} `json:"sessions"`
+		} `json:"call"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&state); err != nil {
+		return fmt.Errorf("failed to decode call state: %w", err)
+	}
+
+	if state.Call == nil {
+		return fmt.Errorf("call state is missing")
+	}
+
+	now := time.Now().UnixMilli()
+	for _, s := range state.Call.Sessions {
+		rec.attendance.join(s.UserID, s.SessionID, now)
+	}
+
+	return nil
+}
+
+// writeAttendanceReport generates the attendance report files next to the
+// recording and returns their paths.
+func (rec *Recorder) writeAttendanceReport() ([]string, error) {
+	report := rec.attendance.report(rec.cfg.CallID, rec.cfg.RecordingID, time.Now().UnixMilli())
+
+	basePath := strings.TrimSuffix(rec.outPath, filepath.Ext(rec.outPath))
+	jsonPath := basePath + attendanceReportJSONSuffix
+	csvPath := basePath + attendanceReportCSVSuffix
+
+	if err := report.writeJSON(jsonPath); err != nil {
+		return nil, fmt.Errorf("failed to write JSON report: %w", err)
+	}
+
+	if err := report.writeCSV(csvPath); err != nil {
+		return nil, fmt.Errorf("failed to write CSV report: %w", err)
+	}
+
+	return []string{jsonPath, csvPath}, nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mattermost/calls-recorder/cmd/recorder/config"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAttendanceTracker(t *testing.T) {
+	tracker := newAttendanceTracker()
+	tracker.setBotUserID("botID")
+
+	tracker.handleEvent(BrowserEvent{Type: BrowserEventTypeUserJoined, UserID: "botID", SessionID: "botSession", Timestamp: 500})
+	tracker.handleEvent(BrowserEvent{Type: BrowserEventTypeUserJoined, UserID: "userA", SessionID: "sessionA1", Timestamp: 1000})
+	tracker.handleEvent(BrowserEvent{Type: BrowserEventTypeUserJoined, UserID: "userB", SessionID: "sessionB", Timestamp: 2000})
+	// duplicate join should be ignored
+	tracker.handleEvent(BrowserEvent{Type: BrowserEventTypeUserJoined, UserID: "userA", SessionID: "sessionA1", Timestamp: 3000})
+	tracker.handleEvent(BrowserEvent{Type: BrowserEventTypeUserLeft, UserID: "userA", SessionID: "sessionA1", Timestamp: 5000})
+	// leave for unknown session should be ignored
+	tracker.handleEvent(BrowserEvent{Type: BrowserEventTypeUserLeft, UserID: "userC", SessionID: "sessionC", Timestamp: 5000})
+	tracker.handleEvent(BrowserEvent{Type: BrowserEventTypeUserJoined, UserID: "userA", SessionID: "sessionA2", Timestamp: 6000})
+	// incomplete events should be ignored
+	tracker.handleEvent(BrowserEvent{Type: BrowserEventTypeUserJoined, SessionID: "sessionD", Timestamp: 6000})
+	tracker.handleEvent(BrowserEvent{Type: BrowserEventTypeVoiceOn, UserID: "userA", SessionID: "sessionA2", Timestamp: 6500})
+
+	// userA (second session) and userB, the bot doesn't count.
+	require.Equal(t, 2, tracker.activeParticipants())
+
+	report := tracker.report("callID", "recordingID", 10000)
+	require.Equal(t, attendanceReport{
+		CallID:      "callID",
+		RecordingID: "recordingID",
+		StartAt:     500,
+		EndAt:       10000,
+		Participants: []attendanceParticipant{
+			{
+				UserID:    "userA",
+				TotalTime: 8000,
+				Sessions: []attendanceSession{
+					{SessionID: "sessionA1", JoinAt: 1000, LeaveAt: 5000},
+					{SessionID: "sessionA2", JoinAt: 6000, LeaveAt: 10000},
+				},
+			},
+			{
+				UserID:    "userB",
+				TotalTime: 8000,
+				Sessions: []attendanceSession{
+					{SessionID: "sessionB", JoinAt: 2000, LeaveAt: 10000},
+				},
+			},
+		},
+	}, report)
+
+	t.Run("write", func(t *testing.T) {
+		dir := t.TempDir()
+
+		jsonPath := filepath.Join(dir, "report.json")
+		require.NoError(t, report.writeJSON(jsonPath))
+		data, err := os.ReadFile(jsonPath)
+		require.NoError(t, err)
+		var r attendanceReport
+		require.NoError(t, json.Unmarshal(data, &r))
+		require.Equal(t, report, r)
+
+		csvPath := filepath.Join(dir, "report.csv")
+		require.NoError(t, report.writeCSV(csvPath))
+		data, err = os.ReadFile(csvPath)
+		require.NoError(t, err)
+		require.Equal(t, `user_id,first_join_at,last_leave_at,sessions,total_seconds
+userA,1970-01-01T00:00:01Z,1970-01-01T00:00:10Z,2,8
+userB,1970-01-01T00:00:02Z,1970-01-01T00:00:10Z,1,8
+`, string(data))
+	})
+}
+
+func TestWriteAttendanceReport(t *testing.T) {
+	dir := t.TempDir()
+	rec := &Recorder{
+		outPath:    filepath.Join(dir, "recording.mp4"),
+		attendance: newAttendanceTracker(),
+	}
+	rec.attendance.join("userA", "sessionA", 1000)
+
+	paths, err := rec.writeAttendanceReport()
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		filepath.Join(dir, "recording-attendance.json"),
+		filepath.Join(dir, "recording-attendance.csv"),
+	}, paths)
+	for _, path := range paths {
+		require.FileExists(t, path)
+	}
+}
+
+func TestSeedAttendance(t *testing.T) {
+	var callState string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v4/users/me":
+			fmt.Fprintln(w, `{"id": "botID"}`)
+		case "/plugins/com.mattermost.calls/calls/8w8jorhr7j83uqr6y1st894hqe":
+			if callState == "" {
+				w.WriteHeader(500)
+				fmt.Fprintln(w, `{"message": "server error"}`)
+				return
+			}
+			fmt.Fprintln(w, callState)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	cfg := config.RecorderConfig{
+		SiteURL:     ts.URL,
+		CallID:      "8w8jorhr7j83uqr6y1st894hqe",
+		PostID:      "udzdsg7dwidbzcidx5khrf8nee",
+		RecordingID: "67t5u6cmtfbb7jug739d43xa9e",
+		AuthToken:   "qj75unbsef83ik9p7ueypb6iyw",
+	}
+	cfg.SetDefaults()
+	rec, err := NewRecorder(cfg, getDataDir(""))
+	require.NoError(t, err)
+
+	t.Run("success", func(t *testing.T) {
+		rec.attendance = newAttendanceTracker()
+		callState = `{"call": {"sessions": [{"session_id": "botSession", "user_id": "botID"}, {"session_id": "sessionA", "user_id": "userA"}]}}`
+		require.NoError(t, rec.seedAttendance())
+		require.Equal(t, 1, rec.attendance.activeParticipants())
+	})
+
+	t.Run("request failure", func(t *testing.T) {
+		rec.attendance = newAttendanceTracker()
+		callState = ""
+		require.EqualError(t, rec.seedAttendance(), "failed to get call state: server error")
+		require.Zero(t, rec.attendance.activeParticipants())
+	})
+
+	t.Run("missing call", func(t *testing.T) {
+		rec.attendance = newAttendanceTracker()
+		callState = `{}`
+		require.EqualError(t, rec.seedAttendance(), "call state is missing")
+	})
+}
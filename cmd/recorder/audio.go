@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	audioMeterSampleRate = 8000
+	// Levels are computed over windows of this many samples (one second).
+	audioMeterWindowSize = audioMeterSampleRate
+	// Levels are expressed in dBFS and clamped to this floor, which is what
+	// digital silence maps to.
+	audioLevelFloorDB = -100.0
+	// Anything below this level is considered silence.
+	audioSilenceThresholdDB     = -90.0
+	audioSilenceTimeoutDefault  = 2 * time.Minute
+	audioSilenceTimeoutMin      = 10 * time.Second
+	audioLevelsFileSuffix       = "-audio-levels.jsonl"
+	audioMeterRestartMax        = 3
+	audioMeterReadBufferSize    = 4096
+	audioMeterBytesPerSample    = 4
+	audioMeterLogFreq           = time.Minute
+	audioSilenceWarningTemplate = "no audio has been captured for %s while participants are unmuted"
+)
+
+// getAudioSilenceTimeout returns how long the captured audio can stay silent
+// while participants are unmuted before a warning is issued. A zero timeout
+// means warnings are disabled.
+func getAudioSilenceTimeout() (time.Duration, error) {
+	val := os.Getenv("AUDIO_SILENCE_TIMEOUT")
+	if val == "" {
+		return audioSilenceTimeoutDefault, nil
+	}
+
+	timeout, err := time.ParseDuration(val)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse AUDIO_SILENCE_TIMEOUT: %w", err)
+	} else if timeout < 0 || (timeout > 0 && timeout < audioSilenceTimeoutMin) {
+		return 0, fmt.Errorf("AUDIO_SILENCE_TIMEOUT should be at least %s", audioSilenceTimeoutMin)
+	}
+
+	return timeout, nil
+}
+
+// audioLevelSample holds the audio levels, in dBFS, computed over a window.
+type audioLevelSample struct {
+	Timestamp int64   `json:"ts"`
+	RMS       float64 `json:"rms_db"`
+	Peak      float64 `json:"peak_db"`
+}
+
+func (s audioLevelSample) isSilent() bool {
+	return s.Peak < audioSilenceThresholdDB
+}
+
+func toDBFS(val float64) float64 {
+	if val <= 0 {
+		return audioLevelFloorDB
+	}
+	return max(20*math.Log10(val), audioLevelFloorDB)
+}
+
+// computeAudioLevels returns the RMS and peak levels, in dBFS, of the given
+// samples.
+func computeAudioLevels(samples []float32) (float64, float64) {
+	if len(samples) == 0 {
+		return audioLevelFloorDB, audioLevelFloorDB
+	}
+
+	var sum, peak float64
+	for _, s := range samples {
+		v := math.Abs(float64(s))
+		sum += v * v
+		peak = max(peak, v)
+	}
+
+	return toDBFS(math.Sqrt(sum / float64(len(samples)))), toDBFS(peak)
+}
+
+// audioMeter measures the level of the captured audio over time, storing it
+// as a time series (one JSON object per line). It also keeps track of who is
+// unmuted so that it can tell when audio is missing.
+type audioMeter struct {
+	path           string
+	silenceTimeout time.Duration
+	// called (at most once per silent period) when audio has been silent for
+	// longer than silenceTimeout while participants were unmuted.
+	onSilence func(d time.Duration)
+	lastLogAt time.Time
+
+	mut    sync.Mutex
+	file   *os.File
+	closed bool
+	// sessions currently unmuted
+	unmuted map[string]bool
+	// when the audio started being silent with participants unmuted
+	silentSince time.Time
+	warned      bool
+	// when the last non silent sample was captured
+	lastSoundAt time.Time
+	summary     audioMeterSummary
+}
+
+type audioMeterSummary struct {
+	Samples       int
+	SilentSamples int
+	RMSSum        float64
+	MaxPeak       float64
+	Warnings      int
+}
+
+func newAudioMeter(path string, silenceTimeout time.Duration, onSilence func(d time.Duration)) *audioMeter {
+	return &audioMeter{
+		path:           path,
+		silenceTimeout: silenceTimeout,
+		onSilence:      onSilence,
+		unmuted:        map[string]bool{},
+		summary: audioMeterSummary{
+			MaxPeak: audioLevelFloorDB,
+		},
+	}
+}
+
+// monitorArgs returns the arguments to the command capturing the given
+// source in the format expected by consume.
+func (m *audioMeter) monitorArgs(source string) string {
+	return fmt.Sprintf("--raw --format=float32le --channels=1 --rate=%d --latency-msec=100 --device=%s",
+		audioMeterSampleRate, source)
+}
+
+func (m *audioMeter) handleEvent(ev BrowserEvent) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	switch ev.Type {
+	case BrowserEventTypeVoiceOn:
+		m.unmuted[ev.SessionID] = true
+	case BrowserEventTypeVoiceOff, BrowserEventTypeUserLeft:
+		delete(m.unmuted, ev.SessionID)
+	}
+}
+
+// addSample records the given levels and checks whether audio has been
+// missing for too long.
+func (m *audioMeter) addSample(sample audioLevelSample) error {
+	m.mut.Lock()
+
+	m.summary.Samples++
+	m.summary.RMSSum += sample.RMS
+	m.summary.MaxPeak = max(m.summary.MaxPeak, sample.Peak)
+
+	var silentFor time.Duration
+	at := time.UnixMilli(sample.Timestamp)
+	if sample.isSilent() {
+		m.summary.SilentSamples++
+	} else {
+		m.lastSoundAt = at
+	}
+	if sample.isSilent() && len(m.unmuted) > 0 {
+		if m.silentSince.IsZero() {
+			m.silentSince = at
+		}
+		if d := at.Sub(m.silentSince); m.silenceTimeout > 0 && d >= m.silenceTimeout && !m.warned {
+			m.warned = true
+			m.summary.Warnings++
+			silentFor = d
+		}
+	} else {
+		m.silentSince = time.Time{}
+		// Audio coming back re-arms the warning.
+		if !sample.isSilent() {
+			m.warned = false
+		}
+	}
+
+	err := m.writeSample(sample)
+	m.mut.Unlock()
+
+	if silentFor > 0 && m.onSilence != nil {
+		m.onSilence(silentFor)
+	}
+
+	return err
+}
+
+func (m *audioMeter) writeSample(sample audioLevelSample) error {
+	if m.closed {
+		return nil
+	}
+
+	if m.file == nil {
+		file, err := os.OpenFile(m.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			return fmt.Errorf("failed to open levels file: %w", err)
+		}
+		m.file = file
+	}
+
+	data, err := json.Marshal(sample)
+	if err != nil {
+		return fmt.Errorf("failed to marshal sample: %w", err)
+	}
+	if _, err := m.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write sample: %w", err)
+	}
+
+	return nil
+}
+
+// consume reads raw 32-bit float samples from r until EOF, computing levels
+// over fixed size windows.
+func (m *audioMeter) consume(r io.Reader) {
+	br := bufio.NewReaderSize(r, audioMeterReadBufferSize)
+	window := make([]float32, 0, audioMeterWindowSize)
+	buf := make([]byte, audioMeterBytesPerSample)
+
+	for {
+		if _, err := io.ReadFull(br, buf); err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, os.ErrClosed) {
+				slog.Error("failed to read audio samples", slog.String("err", err.Error()))
+			}
+			return
+		}
+
+		window = append(window, math.Float32frombits(binary.LittleEndian.Uint32(buf)))
+		if len(window) < audioMeterWindowSize {
+			continue
+		}
+
+		rms, peak := computeAudioLevels(window)
+		window = window[:0]
+
+		now := time.Now()
+		sample := audioLevelSample{
+			Timestamp: now.UnixMilli(),
+			RMS:       rms,
+			Peak:      peak,
+		}
+		if err := m.addSample(sample); err != nil {
+			slog.Error("failed to add audio level sample", slog.String("err", err.Error()))
+		}
+
+		if now.Sub(m.lastLogAt) >= audioMeterLogFreq {
+			m.lastLogAt = now
+			slog.Debug("audio levels",
+				slog.String("rms", fmt.Sprintf("%.1fdB", sample.RMS)),
+				slog.String("peak", fmt.Sprintf("%.1fdB", sample.Peak)),
+			)
+		}
+	}
+}
+
+// lastSound returns the time the last non silent sample was captured at.
+func (m *audioMeter) lastSound() time.Time {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	return m.lastSoundAt
+}
+
+func (m *audioMeter) logAttrs() []any {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	s := m.summary
+	if s.Samples == 0 {
+		return []any{slog.Int("samples", 0)}
+	}
+
+	return []any{
+		slog.Int("samples", s.Samples),
+		slog.Int("silentSamples", s.SilentSamples),
+		slog.String("avgRMS", fmt.Sprintf("%.1fdB", s.RMSSum/float64(s.Samples))),
+		slog.String("maxPeak", fmt.Sprintf("%.1fdB", s.MaxPeak)),
+		slog.Int("warnings", s.Warnings),
+	}
+}
+
+func (m *audioMeter) close() error {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	m.closed = true
+	if m.file == nil {
+		return nil
+	}
+
+	err := m.file.Close()
+	m.file = nil
+	return err
+}
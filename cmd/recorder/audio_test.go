@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetAudioSilenceTimeout(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		t.Setenv("AUDIO_SILENCE_TIMEOUT", "")
+		timeout, err := getAudioSilenceTimeout()
+		require.NoError(t, err)
+		require.Equal(t, audioSilenceTimeoutDefault, timeout)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		t.Setenv("AUDIO_SILENCE_TIMEOUT", "0")
+		timeout, err := getAudioSilenceTimeout()
+		require.NoError(t, err)
+		require.Zero(t, timeout)
+	})
+
+	t.Run("valid", func(t *testing.T) {
+		t.Setenv("AUDIO_SILENCE_TIMEOUT", "30s")
+		timeout, err := getAudioSilenceTimeout()
+		require.NoError(t, err)
+		require.Equal(t, 30*time.Second, timeout)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		t.Setenv("AUDIO_SILENCE_TIMEOUT", "1s")
+		_, err := getAudioSilenceTimeout()
+		require.EqualError(t, err, "AUDIO_SILENCE_TIMEOUT should be at least 10s")
+
+		t.Setenv("AUDIO_SILENCE_TIMEOUT", "forever")
+		_, err = getAudioSilenceTimeout()
+		require.Error(t, err)
+	})
+}
+
+func TestComputeAudioLevels(t *testing.T) {
+	rms, peak := computeAudioLevels(nil)
+	require.Equal(t, audioLevelFloorDB, rms)
+	require.Equal(t, audioLevelFloorDB, peak)
+
+	rms, peak = computeAudioLevels(make([]float32, 100))
+	require.Equal(t, audioLevelFloorDB, rms)
+	require.Equal(t, audioLevelFloorDB, peak)
+
+	// Full scale square wave.
+	rms, peak = computeAudioLevels([]float32{1, -1, 1, -1})
+	require.Zero(t, rms)
+	require.Zero(t, peak)
+
+	rms, peak = computeAudioLevels([]float32{0.5, -0.5, 0, 0})
+	require.InDelta(t, -9.03, rms, 0.01)
+	require.InDelta(t, -6.02, peak, 0.01)
+}
+
+func TestAudioMeterSilence(t *testing.T) {
+	silent := func(ts int64) audioLevelSample {
+		return audioLevelSample{Timestamp: ts, RMS: audioLevelFloorDB, Peak: audioLevelFloorDB}
+	}
+	loud := func(ts int64) audioLevelSample {
+		return audioLevelSample{Timestamp: ts, RMS: -20, Peak: -10}
+	}
+
+	newMeter := func(t *testing.T) (*audioMeter, *[]time.Duration) {
+		var warnings []time.Duration
+		m := newAudioMeter(filepath.Join(t.TempDir(), "levels.jsonl"), 10*time.Second, func(d time.Duration) {
+			warnings = append(warnings, d)
+		})
+		t.Cleanup(func() {
+			require.NoError(t, m.close())
+		})
+		return m, &warnings
+	}
+
+	t.Run("nobody unmuted", func(t *testing.T) {
+		m, warnings := newMeter(t)
+		for i := int64(0); i <= 20; i++ {
+			require.NoError(t, m.addSample(silent(i*1000)))
+		}
+		require.Empty(t, *warnings)
+	})
+
+	t.Run("unmuted", func(t *testing.T) {
+		m, warnings := newMeter(t)
+		m.handleEvent(BrowserEvent{Type: BrowserEventTypeVoiceOn, SessionID: "sessionA"})
+		for i := int64(0); i <= 20; i++ {
+			require.NoError(t, m.addSample(silent(i*1000)))
+		}
+		// Only warned once per silent period.
+		require.Equal(t, []time.Duration{10 * time.Second}, *warnings)
+
+		// Audio coming back re-arms the warning.
+		require.NoError(t, m.addSample(loud(21000)))
+		for i := int64(22); i <= 32; i++ {
+			require.NoError(t, m.addSample(silent(i*1000)))
+		}
+		require.Equal(t, []time.Duration{10 * time.Second, 10 * time.Second}, *warnings)
+		require.Equal(t, time.UnixMilli(21000), m.lastSound())
+	})
+
+	t.Run("muted before timeout", func(t *testing.T) {
+		m, warnings := newMeter(t)
+		m.handleEvent(BrowserEvent{Type: BrowserEventTypeVoiceOn, SessionID: "sessionA"})
+		for i := int64(0); i < 5; i++ {
+			require.NoError(t, m.addSample(silent(i*1000)))
+		}
+		m.handleEvent(BrowserEvent{Type: BrowserEventTypeUserLeft, SessionID: "sessionA"})
+		for i := int64(5); i <= 20; i++ {
+			require.NoError(t, m.addSample(silent(i*1000)))
+		}
+		require.Empty(t, *warnings)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		var warned bool
+		m := newAudioMeter(filepath.Join(t.TempDir(), "levels.jsonl"), 0, func(_ time.Duration) {
+			warned = true
+		})
+		defer m.close()
+		m.handleEvent(BrowserEvent{Type: BrowserEventTypeVoiceOn, SessionID: "sessionA"})
+		for i := int64(0); i <= 600; i++ {
+			require.NoError(t, m.addSample(silent(i*1000)))
+		}
+		require.False(t, warned)
+	})
+}
+
+func TestAudioMeterConsume(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "levels.jsonl")
+	m := newAudioMeter(path, 0, nil)
+
+	var buf bytes.Buffer
+	// Two full windows, the first silent and the second at half scale, plus a
+	// partial one which should be ignored.
+	for i := 0; i < audioMeterWindowSize*2+100; i++ {
+		var v float32
+		if i >= audioMeterWindowSize {
+			v = 0.5
+		}
+		require.NoError(t, binary.Write(&buf, binary.LittleEndian, math.Float32bits(v)))
+	}
+
+	m.consume(&buf)
+	require.NoError(t, m.close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 2)
+	require.Contains(t, lines[0], `"rms_db":-100,"peak_db":-100`)
+	require.Contains(t, lines[1], `"rms_db":-6.0205999132796`)
+
+	m.mut.Lock()
+	require.Equal(t, 2, m.summary.Samples)
+	require.Equal(t, 1, m.summary.SilentSamples)
+	m.mut.Unlock()
+
+	// Samples are dropped once closed.
+	require.NoError(t, m.addSample(audioLevelSample{Timestamp: 1000}))
+	data2, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, data, data2)
+}
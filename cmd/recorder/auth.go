@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/blang/semver/v4"
+	"github.com/chromedp/cdproto/fetch"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/chromedp"
+
+	"github.com/mattermost/calls-recorder/cmd/recorder/config"
+)
+
+const (
+	// authTokenGlobal is the name of the read-only global through which the
+	// recording page can access the auth token.
+	authTokenGlobal = "callsRecorderAuthToken"
+	// authCookieName is the cookie the Mattermost server reads the session
+	// token from. We need it to authenticate the WebSocket handshake, which
+	// can't be intercepted through the Fetch domain.
+	authCookieName = "MMAUTHTOKEN"
+	// authTokenMinPluginVersion is the first plugin version whose recording
+	// page reads the auth token from the global or the cookie. Older ones
+	// expect it in the URL fragment, which we don't support.
+	authTokenMinPluginVersion = "1.0.1"
+)
+
+// getPluginVersion returns the version of the Calls plugin running on the
+// Mattermost site.
+func (rec *Recorder) getPluginVersion() (semver.Version, error) {
+	ctx, cancelCtx := context.WithTimeout(context.Background(), httpRequestTimeout)
+	defer cancelCtx()
+
+	apiURL := fmt.Sprintf("%s/plugins/%s/version", rec.client.URL, pluginID)
+	resp, err := rec.client.DoAPIRequest(ctx, http.MethodGet, apiURL, "", "")
+	if err != nil {
+		return semver.Version{}, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var info struct {
+		Version string `json:"version"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return semver.Version{}, fmt.Errorf("failed to decode response body: %w", err)
+	}
+
+	v, err := semver.ParseTolerant(info.Version)
+	if err != nil {
+		return semver.Version{}, fmt.Errorf("failed to parse version: %w", err)
+	}
+
+	return v, nil
+}
+
+// checkPluginVersion returns an error if the plugin is too old to take the
+// auth token through CDP. When the version can't be determined we carry on
+// since the token is never put in the page URL either way.
+func (rec *Recorder) checkPluginVersion() error {
+	v, err := rec.getPluginVersion()
+	if err != nil {
+		slog.Warn("failed to get plugin version", slog.String("err", err.Error()))
+		return nil
+	}
+
+	if v.LT(semver.MustParse(authTokenMinPluginVersion)) {
+		return fmt.Errorf("plugin version %s is not supported: version %s or later is required to authenticate the recording page",
+			v.String(), authTokenMinPluginVersion)
+	}
+
+	return nil
+}
+
+// genRecordingURL returns the URL of the recording page. It never contains
+// the auth token, which is delivered through CDP instead (see authTasks).
+func genRecordingURL(cfg config.RecorderConfig) string {
+	return fmt.Sprintf("%s/plugins/%s/standalone/recording.html?call_id=%s&job_id=%s",
+		cfg.SiteURL, pluginID, cfg.CallID, cfg.RecordingID)
+}
+
+// genAuthTokenScript returns a script defining the auth token global. The
+// property is neither enumerable nor writable so that it can't be
+// accidentally serialized or overwritten by the page.
+func genAuthTokenScript(token string) (string, error) {
+	data, err := json.Marshal(token)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal token: %w", err)
+	}
+
+	return fmt.Sprintf("Object.defineProperty(window, %q, {value: %s, enumerable: false, writable: false, configurable: false});",
+		authTokenGlobal, data), nil
+}
+
+// isSameOrigin returns whether the given URL has the same scheme and host as
+// the given origin.
+func isSameOrigin(origin *url.URL, rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Scheme, origin.Scheme) && strings.EqualFold(u.Host, origin.Host)
+}
+
+// genAuthRequestHeaders returns the headers of an intercepted request with the
+// auth token added. The token is only ever sent to the Mattermost site.
+// A nil value means the request should continue unmodified.
+func genAuthRequestHeaders(origin *url.URL, token string, req *network.Request) []*fetch.HeaderEntry {
+	if !isSameOrigin(origin, req.URL) {
+		return nil
+	}
+
+	var headers []*fetch.HeaderEntry
+	for name, val := range req.Headers {
+		if strings.EqualFold(name, "Authorization") {
+			continue
+		}
+		headers = append(headers, &fetch.HeaderEntry{Name: name, Value: fmt.Sprintf("%v", val)})
+	}
+
+	return append(headers, &fetch.HeaderEntry{Name: "Authorization", Value: "Bearer " + token})
+}
+
+// authTasks returns the actions needed to authenticate the recording page.
+// They must run before navigating so that the token is available from the
+// very first request. This way the token never needs to be part of the page
+// URL.
+func (rec *Recorder) authTasks() (chromedp.Tasks, error) {
+	siteURL, err := url.Parse(rec.cfg.SiteURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse site URL: %w", err)
+	}
+
+	script, err := genAuthTokenScript(rec.cfg.AuthToken)
+	if err != nil {
+		return nil, err
+	}
+
+	return chromedp.Tasks{
+		network.SetCookie(authCookieName, rec.cfg.AuthToken).
+			WithURL(rec.cfg.SiteURL).
+			WithHTTPOnly(true).
+			WithSecure(siteURL.Scheme == "https").
+			WithSameSite(network.CookieSameSiteStrict),
+		rec.fetchEnableParams(siteURL),
+		chromedp.ActionFunc(func(ctx context.Context) error {
+			_, err := page.AddScriptToEvaluateOnNewDocument(script).Do(ctx)
+			return err
+		}),
+	}, nil
+}
+
+func (rec *Recorder) fetchEnableParams(siteURL *url.URL) *fetch.EnableParams {
+	// Proxy authentication challenges are only reported for intercepted
+	// requests so in that case we need to intercept all of them.
+	if rec.proxyAuth != nil {
+		return fetch.Enable().WithPatterns([]*fetch.RequestPattern{
+			{
+				URLPattern:   "*",
+				RequestStage: fetch.RequestStageRequest,
+			},
+		}).WithHandleAuthRequests(true)
+	}
+
+	return fetch.Enable().WithPatterns([]*fetch.RequestPattern{
+		{
+			URLPattern:   siteURL.Scheme + "://" + siteURL.Host + "/*",
+			RequestStage: fetch.RequestStageRequest,
+		},
+	})
+}
+
+// handleRequestPaused adds the auth token to a request intercepted through the
+// Fetch domain and lets it continue.
+func (rec *Recorder) handleRequestPaused(ctx context.Context, ev *fetch.EventRequestPaused) {
+	continueReq := fetch.ContinueRequest(ev.RequestID)
+	if siteURL, err := url.Parse(rec.cfg.SiteURL); err != nil {
+		slog.Error("failed to parse site URL", slog.String("err", err.Error()))
+	} else {
+		continueReq = continueReq.WithHeaders(genAuthRequestHeaders(siteURL, rec.cfg.AuthToken, ev.Request))
+	}
+
+	// Paused requests need to be continued for the page to load. This can't
+	// happen synchronously as we are being called from the event listener.
+	go func() {
+		if err := chromedp.Run(ctx, continueReq); err != nil {
+			slog.Error("failed to continue request", slog.String("err", err.Error()))
+		}
+	}()
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/mattermost/calls-recorder/cmd/recorder/config"
+
+	"github.com/chromedp/cdproto/fetch"
+	"github.com/chromedp/cdproto/network"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenAuthTokenScript(t *testing.T) {
+	script, err := genAuthTokenScript(`token"</script>`)
+	require.NoError(t, err)
+	require.Equal(t, `Object.defineProperty(window, "callsRecorderAuthToken", {value: "token\"\u003c/script\u003e", enumerable: false, writable: false, configurable: false});`, script)
+}
+
+func TestGenAuthRequestHeaders(t *testing.T) {
+	origin, err := url.Parse("https://mm.example.com")
+	require.NoError(t, err)
+
+	t.Run("same origin", func(t *testing.T) {
+		headers := genAuthRequestHeaders(origin, "authToken", &network.Request{
+			URL: "https://mm.example.com/api/v4/users/me",
+			Headers: network.Headers{
+				"X-Calls-Recorder": "true",
+			},
+		})
+		require.ElementsMatch(t, []*fetch.HeaderEntry{
+			{Name: "X-Calls-Recorder", Value: "true"},
+			{Name: "Authorization", Value: "Bearer authToken"},
+		}, headers)
+	})
+
+	t.Run("overrides existing authorization", func(t *testing.T) {
+		headers := genAuthRequestHeaders(origin, "authToken", &network.Request{
+			URL: "https://MM.example.com/api/v4/users/me",
+			Headers: network.Headers{
+				"authorization": "Bearer other",
+			},
+		})
+		require.Equal(t, []*fetch.HeaderEntry{
+			{Name: "Authorization", Value: "Bearer authToken"},
+		}, headers)
+	})
+
+	t.Run("different origin", func(t *testing.T) {
+		for _, u := range []string{
+			"http://mm.example.com/api/v4/users/me",
+			"https://mm.example.com:8443/api/v4/users/me",
+			"https://cdn.example.com/static/main.js",
+			"https://mm.example.com.evil.com/",
+		} {
+			headers := genAuthRequestHeaders(origin, "authToken", &network.Request{
+				URL: u,
+				Headers: network.Headers{
+					"Authorization": "Bearer other",
+					"Accept":        "*/*",
+				},
+			})
+			require.Nil(t, headers, u)
+		}
+	})
+}
+
+func TestGenRecordingURL(t *testing.T) {
+	cfg := config.RecorderConfig{
+		SiteURL:     "https://mm.example.com",
+		CallID:      "8w8jorhr7j83uqr6y1st894hqe",
+		RecordingID: "67t5u6cmtfbb7jug739d43xa9e",
+		AuthToken:   "qj75unbsef83ik9p7ueypb6iyw",
+	}
+
+	recURL := genRecordingURL(cfg)
+	require.Equal(t, "https://mm.example.com/plugins/com.mattermost.calls/standalone/recording.html?call_id=8w8jorhr7j83uqr6y1st894hqe&job_id=67t5u6cmtfbb7jug739d43xa9e", recURL)
+	require.NotContains(t, recURL, cfg.AuthToken)
+}
+
+func TestCheckPluginVersion(t *testing.T) {
+	var version string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/plugins/com.mattermost.calls/version" || version == "" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, `{"version": %q, "build": "abcdef"}`, version)
+	}))
+	defer ts.Close()
+
+	cfg := config.RecorderConfig{
+		SiteURL:     ts.URL,
+		CallID:      "8w8jorhr7j83uqr6y1st894hqe",
+		PostID:      "udzdsg7dwidbzcidx5khrf8nee",
+		RecordingID: "67t5u6cmtfbb7jug739d43xa9e",
+		AuthToken:   "qj75unbsef83ik9p7ueypb6iyw",
+	}
+	cfg.SetDefaults()
+	rec, err := NewRecorder(cfg, getDataDir(""))
+	require.NoError(t, err)
+
+	t.Run("unknown version", func(t *testing.T) {
+		version = ""
+		require.NoError(t, rec.checkPluginVersion())
+	})
+
+	t.Run("invalid version", func(t *testing.T) {
+		version = "invalid"
+		require.NoError(t, rec.checkPluginVersion())
+	})
+
+	t.Run("old version", func(t *testing.T) {
+		version = "v0.21.1"
+		require.EqualError(t, rec.checkPluginVersion(), "plugin version 0.21.1 is not supported: version 1.0.1 or later is required to authenticate the recording page")
+
+		version = "1.0.0"
+		require.Error(t, rec.checkPluginVersion())
+	})
+
+	t.Run("supported version", func(t *testing.T) {
+		version = authTokenMinPluginVersion
+		require.NoError(t, rec.checkPluginVersion())
+
+		version = "1.2.0"
+		require.NoError(t, rec.checkPluginVersion())
+	})
+}
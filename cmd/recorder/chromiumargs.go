@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// chromiumDeniedArgs are the flags which could expose the browser, weaken its
+// security, redirect its traffic or override settings the recorder manages
+// itself. They are refused unless explicitly allowed.
+var chromiumDeniedArgs = []string{
+	"allow-running-insecure-content",
+	"disable-site-isolation-trials",
+	"disable-web-security",
+	"host-resolver-rules",
+	"lang",
+	"load-extension",
+	"no-sandbox",
+	"proxy-pac-url",
+	"proxy-server",
+	"remote-allow-origins",
+	"remote-debugging-address",
+	"remote-debugging-pipe",
+	"remote-debugging-port",
+	"unsafely-treat-insecure-origin-as-secure",
+	"user-data-dir",
+}
+
+type chromiumArg struct {
+	Name  string
+	Value string
+	// HasValue is false for boolean flags.
+	HasValue bool
+}
+
+// splitShellArgs splits the given string into arguments following the basic
+// POSIX shell rules: whitespace separates arguments, single quotes preserve
+// everything literally, double quotes allow escaping through backslash.
+func splitShellArgs(str string) ([]string, error) {
+	var args []string
+	var curr strings.Builder
+	inArg := false
+
+	for i := 0; i < len(str); i++ {
+		c := str[i]
+		switch {
+		case c == '\'':
+			end := strings.IndexByte(str[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated single quote at position %d", i)
+			}
+			curr.WriteString(str[i+1 : i+1+end])
+			i += end + 1
+			inArg = true
+		case c == '"':
+			inArg = true
+			closed := false
+			for i++; i < len(str); i++ {
+				if str[i] == '"' {
+					closed = true
+					break
+				}
+				if str[i] == '\\' && i+1 < len(str) && strings.IndexByte("\"\\$`", str[i+1]) >= 0 {
+					i++
+				}
+				curr.WriteByte(str[i])
+			}
+			if !closed {
+				return nil, fmt.Errorf("unterminated double quote")
+			}
+		case c == '\\':
+			if i+1 >= len(str) {
+				return nil, fmt.Errorf("unterminated escape at end of input")
+			}
+			i++
+			curr.WriteByte(str[i])
+			inArg = true
+		case unicode.IsSpace(rune(c)):
+			if inArg {
+				args = append(args, curr.String())
+				curr.Reset()
+				inArg = false
+			}
+		default:
+			curr.WriteByte(c)
+			inArg = true
+		}
+	}
+
+	if inArg {
+		args = append(args, curr.String())
+	}
+
+	return args, nil
+}
+
+// chromiumArgsPolicy decides which extra flags can be passed to the browser.
+type chromiumArgsPolicy struct {
+	allowed map[string]bool
+	denied  map[string]bool
+}
+
+func parseFlagsList(val string) []string {
+	var flags []string
+	for _, flag := range strings.Split(val, ",") {
+		flag = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(flag), "--"))
+		if flag != "" {
+			flags = append(flags, flag)
+		}
+	}
+	return flags
+}
+
+// getChromiumArgsPolicy returns the policy built from the defaults and the
+// environment. Flags listed in EXTRA_CHROMIUM_ARGS_ALLOW always take
+// precedence. Adding "*" to EXTRA_CHROMIUM_ARGS_DENY refuses any flag that
+// isn't explicitly allowed.
+func getChromiumArgsPolicy() chromiumArgsPolicy {
+	p := chromiumArgsPolicy{
+		allowed: map[string]bool{},
+		denied:  map[string]bool{},
+	}
+
+	for _, flag := range chromiumDeniedArgs {
+		p.denied[flag] = true
+	}
+	for _, flag := range parseFlagsList(os.Getenv("EXTRA_CHROMIUM_ARGS_DENY")) {
+		p.denied[flag] = true
+	}
+	for _, flag := range parseFlagsList(os.Getenv("EXTRA_CHROMIUM_ARGS_ALLOW")) {
+		p.allowed[flag] = true
+	}
+
+	return p
+}
+
+func (p chromiumArgsPolicy) isAllowed(name string) bool {
+	name = strings.ToLower(name)
+	if p.allowed[name] {
+		return true
+	}
+	return !p.denied[name] && !p.denied["*"]
+}
+
+// parseChromiumArgs parses the given arguments string validating each flag
+// against the policy.
+func parseChromiumArgs(str string, policy chromiumArgsPolicy) ([]chromiumArg, error) {
+	tokens, err := splitShellArgs(str)
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]chromiumArg, 0, len(tokens))
+	for _, token := range tokens {
+		if !strings.HasPrefix(token, "--") || len(token) == 2 {
+			return nil, fmt.Errorf("invalid argument %q: should be in --flag[=value] format", token)
+		}
+
+		name, value, hasValue := strings.Cut(token[2:], "=")
+		if name == "" {
+			return nil, fmt.Errorf("invalid argument %q: missing flag name", token)
+		}
+
+		if !policy.isAllowed(name) {
+			return nil, fmt.Errorf("argument %q is not allowed", "--"+name)
+		}
+
+		args = append(args, chromiumArg{Name: name, Value: value, HasValue: hasValue})
+	}
+
+	return args, nil
+}
+
+// getExtraChromiumArgs returns the validated extra browser arguments from the
+// environment.
+func getExtraChromiumArgs() ([]chromiumArg, error) {
+	val := os.Getenv("EXTRA_CHROMIUM_ARGS")
+	if val == "" {
+		return nil, nil
+	}
+
+	args, err := parseChromiumArgs(val, getChromiumArgsPolicy())
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse EXTRA_CHROMIUM_ARGS: %w", err)
+	}
+
+	return args, nil
+}
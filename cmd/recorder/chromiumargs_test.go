@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitShellArgs(t *testing.T) {
+	tcs := []struct {
+		name     string
+		input    string
+		expected []string
+		err      string
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name:     "whitespace",
+			input:    "  --a \t --b=c\n",
+			expected: []string{"--a", "--b=c"},
+		},
+		{
+			name:     "single quotes",
+			input:    `--lang='en US' --x='a"b\c'`,
+			expected: []string{"--lang=en US", `--x=a"b\c`},
+		},
+		{
+			name:     "double quotes",
+			input:    `--user-agent="Mozilla/5.0 (X11; \"Linux\")" --y="a\b"`,
+			expected: []string{`--user-agent=Mozilla/5.0 (X11; "Linux")`, `--y=a\b`},
+		},
+		{
+			name:     "escapes",
+			input:    `--a=b\ c --empty=""`,
+			expected: []string{"--a=b c", "--empty="},
+		},
+		{
+			name:  "unterminated single quote",
+			input: "--lang='en US",
+			err:   "unterminated single quote at position 7",
+		},
+		{
+			name:  "unterminated double quote",
+			input: `--lang="en US`,
+			err:   "unterminated double quote",
+		},
+		{
+			name:  "trailing escape",
+			input: `--lang=en\`,
+			err:   "unterminated escape at end of input",
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			args, err := splitShellArgs(tc.input)
+			if tc.err != "" {
+				require.EqualError(t, err, tc.err)
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, tc.expected, args)
+			}
+		})
+	}
+}
+
+func TestParseChromiumArgs(t *testing.T) {
+	t.Run("default policy", func(t *testing.T) {
+		t.Setenv("EXTRA_CHROMIUM_ARGS_ALLOW", "")
+		t.Setenv("EXTRA_CHROMIUM_ARGS_DENY", "")
+		policy := getChromiumArgsPolicy()
+
+		args, err := parseChromiumArgs(`--ignore-certificate-errors --user-agent="Mozilla/5.0 (X11)"`, policy)
+		require.NoError(t, err)
+		require.Equal(t, []chromiumArg{
+			{Name: "ignore-certificate-errors"},
+			{Name: "user-agent", Value: "Mozilla/5.0 (X11)", HasValue: true},
+		}, args)
+
+		_, err = parseChromiumArgs("--remote-debugging-address=0.0.0.0", policy)
+		require.EqualError(t, err, `argument "--remote-debugging-address" is not allowed`)
+
+		_, err = parseChromiumArgs("--Disable-Web-Security", policy)
+		require.EqualError(t, err, `argument "--Disable-Web-Security" is not allowed`)
+
+		for _, arg := range []string{
+			"--proxy-server=http://proxy:8080",
+			"--proxy-pac-url=http://proxy/proxy.pac",
+			"--host-resolver-rules=MAP * 127.0.0.1",
+			"--unsafely-treat-insecure-origin-as-secure=http://mm.example.com",
+			"--lang=fr",
+		} {
+			_, err = parseChromiumArgs("'"+arg+"'", policy)
+			name, _, _ := strings.Cut(arg, "=")
+			require.EqualError(t, err, fmt.Sprintf("argument %q is not allowed", name))
+		}
+
+		_, err = parseChromiumArgs("-v", policy)
+		require.EqualError(t, err, `invalid argument "-v": should be in --flag[=value] format`)
+
+		_, err = parseChromiumArgs("--", policy)
+		require.EqualError(t, err, `invalid argument "--": should be in --flag[=value] format`)
+
+		_, err = parseChromiumArgs("--=value", policy)
+		require.EqualError(t, err, `invalid argument "--=value": missing flag name`)
+	})
+
+	t.Run("explicitly allowed", func(t *testing.T) {
+		t.Setenv("EXTRA_CHROMIUM_ARGS_ALLOW", "--disable-web-security")
+		t.Setenv("EXTRA_CHROMIUM_ARGS_DENY", "")
+
+		args, err := parseChromiumArgs("--disable-web-security", getChromiumArgsPolicy())
+		require.NoError(t, err)
+		require.Equal(t, []chromiumArg{{Name: "disable-web-security"}}, args)
+	})
+
+	t.Run("extra denied", func(t *testing.T) {
+		t.Setenv("EXTRA_CHROMIUM_ARGS_ALLOW", "")
+		t.Setenv("EXTRA_CHROMIUM_ARGS_DENY", "ignore-certificate-errors, proxy-server")
+
+		_, err := parseChromiumArgs("--proxy-server=http://proxy:8080", getChromiumArgsPolicy())
+		require.EqualError(t, err, `argument "--proxy-server" is not allowed`)
+	})
+
+	t.Run("allowlist only", func(t *testing.T) {
+		t.Setenv("EXTRA_CHROMIUM_ARGS_ALLOW", "lang")
+		t.Setenv("EXTRA_CHROMIUM_ARGS_DENY", "*")
+		policy := getChromiumArgsPolicy()
+
+		_, err := parseChromiumArgs("--lang=en-US", policy)
+		require.NoError(t, err)
+
+		_, err = parseChromiumArgs("--lang=en-US --ignore-certificate-errors", policy)
+		require.EqualError(t, err, `argument "--ignore-certificate-errors" is not allowed`)
+	})
+}
@@ -11,21 +11,26 @@ const (
 	logBufferSize = 1024 * 64 // 64KB
 )
 
-func runCmd(cmd string, args string) (*exec.Cmd, error) {
-	slog.Debug("running cmd", slog.String("cmd", cmd), slog.String("args", args))
-	c := exec.Command(cmd, strings.Split(args, " ")...)
+// startCmd starts the given command, forwarding its output to the logger.
+func startCmd(c *exec.Cmd) error {
+	cmd := c.Args[0]
 
-	stdout, err := c.StdoutPipe()
-	if err != nil {
-		return nil, err
+	// The output is only logged if not already consumed by the caller.
+	var stdout io.ReadCloser
+	if c.Stdout == nil {
+		var err error
+		stdout, err = c.StdoutPipe()
+		if err != nil {
+			return err
+		}
 	}
 	stderr, err := c.StderrPipe()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	if err := c.Start(); err != nil {
-		return nil, err
+		return err
 	}
 
 	logOutput := func(out io.ReadCloser, name string) {
@@ -51,8 +56,10 @@ func runCmd(cmd string, args string) (*exec.Cmd, error) {
 		}
 	}
 
-	go logOutput(stdout, "stdout")
+	if stdout != nil {
+		go logOutput(stdout, "stdout")
+	}
 	go logOutput(stderr, "stderr")
 
-	return c, nil
+	return nil
 }
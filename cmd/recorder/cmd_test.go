@@ -1,22 +1,21 @@
 package main
 
 import (
+	"os/exec"
 	"testing"
 
 	"github.com/stretchr/testify/require"
 )
 
-func TestRunCmd(t *testing.T) {
+func TestStartCmd(t *testing.T) {
 	t.Run("non-existant command", func(t *testing.T) {
-		cmd, err := runCmd("calls", "")
+		err := startCmd(exec.Command("calls"))
 		require.Error(t, err)
-		require.Nil(t, cmd)
 	})
 
 	t.Run("valid command", func(t *testing.T) {
-		cmd, err := runCmd("ls", ".")
-		require.NoError(t, err)
-		require.NotNil(t, cmd)
+		cmd := exec.Command("ls", ".")
+		require.NoError(t, startCmd(cmd))
 		require.NoError(t, cmd.Wait())
 	})
 }
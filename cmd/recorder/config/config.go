@@ -5,11 +5,16 @@ import (
 	"net/url"
 	"os"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 )
 
-var idRE = regexp.MustCompile(`^[a-z0-9]{26}$`)
+var (
+	idRE       = regexp.MustCompile(`^[a-z0-9]{26}$`)
+	localeRE   = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+	timezoneRE = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_+\-]*(/[A-Za-z0-9_+\-]+)*$`)
+)
 
 type AVFormat string
 
@@ -19,6 +24,30 @@ const (
 
 type H264Preset string
 
+type CaptureMode string
+
+const (
+	// CaptureModeX11Grab captures the browser window rendered on a virtual
+	// display server (Xvfb).
+	CaptureModeX11Grab CaptureMode = "x11grab"
+	// CaptureModeScreencast captures frames from a headless browser through
+	// the Chrome DevTools Protocol, without requiring a display server.
+	CaptureModeScreencast CaptureMode = "screencast"
+)
+
+type StorageTarget string
+
+const (
+	// StorageTargetMattermost uploads the recording to the call's channel
+	// through the plugin.
+	StorageTargetMattermost StorageTarget = "mattermost"
+	// StorageTargetS3 uploads the recording to an S3-compatible bucket.
+	StorageTargetS3 StorageTarget = "s3"
+	// StorageTargetLocal copies the recording to a local (usually mounted)
+	// path.
+	StorageTargetLocal StorageTarget = "local"
+)
+
 const (
 	H264PresetMedium    = "medium"
 	H264PresetFast      = "fast"
@@ -37,6 +66,10 @@ const (
 	FrameRateDefault    = 30
 	VideoPresetDefault  = H264PresetFast
 	OutputFormatDefault = AVFormatMP4
+	CaptureModeDefault  = CaptureModeX11Grab
+	DeviceScaleDefault  = 1.0
+	// StorageTargetsDefault is a comma separated list of storage targets.
+	StorageTargetsDefault = string(StorageTargetMattermost)
 
 	// limits
 	VideoWidthMin  = 1280
@@ -49,6 +82,12 @@ const (
 	AudioRateMax   = 320
 	FrameRateMin   = 10
 	FrameRateMax   = 60
+	DeviceScaleMin = 1.0
+	DeviceScaleMax = 4.0
+	IdleTimeoutMin = 60
+	IdleTimeoutMax = 86400
+	MaxDurationMin = 60
+	MaxFileSizeMin = 10
 )
 
 type RecorderConfig struct {
@@ -67,6 +106,51 @@ type RecorderConfig struct {
 	FrameRate    int
 	VideoPreset  H264Preset
 	OutputFormat AVFormat
+	CaptureMode  CaptureMode
+
+	// browser config
+
+	// Locale is the language the browser UI renders in (e.g. "de-DE"). The
+	// container default is used if empty.
+	Locale string
+	// Timezone is the IANA name of the timezone (e.g. "Europe/Berlin") used
+	// by the page. The container default is used if empty.
+	Timezone string
+	// DeviceScale is the device scale factor. Values above one render the
+	// page with a smaller (CSS) viewport at higher pixel density.
+	DeviceScale float64
+
+	// AttendanceReport controls whether a report of the call participants
+	// should be generated and uploaded along with the recording.
+	AttendanceReport bool
+
+	// IdleTimeout is the time, in seconds, after which the recording ends on
+	// its own if nobody has spoken and the screen hasn't changed. The
+	// recording also ends when the bot is the only participant left. Zero
+	// disables it.
+	IdleTimeout int
+
+	// limits config
+
+	// MaxDuration is the maximum duration, in seconds, of the recording.
+	// Zero means no limit.
+	MaxDuration int
+	// MaxFileSize is the maximum size, in megabytes, of the recording file.
+	// Zero means no limit.
+	MaxFileSize int
+
+	// storage config
+
+	// StorageTargets is a comma separated list of the targets the recording
+	// gets stored to (e.g. "mattermost,s3"). The recording post is created
+	// in Mattermost regardless.
+	StorageTargets string
+	// StreamingUpload controls whether the recording gets written as
+	// fragmented MP4 and uploaded while the call is still going, so that only
+	// the tail is left to upload once it ends. Only the s3 target supports
+	// it: Mattermost upload sessions need the final file size upfront, so the
+	// mattermost target still uploads the whole file once the call ends.
+	StreamingUpload bool
 }
 
 func (p H264Preset) IsValid() bool {
@@ -78,6 +162,51 @@ func (p H264Preset) IsValid() bool {
 	}
 }
 
+func (m CaptureMode) IsValid() bool {
+	switch m {
+	case CaptureModeX11Grab, CaptureModeScreencast:
+		return true
+	default:
+		return false
+	}
+}
+
+func (t StorageTarget) IsValid() bool {
+	switch t {
+	case StorageTargetMattermost, StorageTargetS3, StorageTargetLocal:
+		return true
+	default:
+		return false
+	}
+}
+
+// ParseStorageTargets parses a comma separated list of storage targets.
+func ParseStorageTargets(val string) ([]StorageTarget, error) {
+	var targets []StorageTarget
+	seen := map[StorageTarget]bool{}
+	for _, s := range strings.Split(val, ",") {
+		t := StorageTarget(strings.TrimSpace(s))
+		if !t.IsValid() {
+			return nil, fmt.Errorf("invalid storage target %q", t)
+		}
+		if seen[t] {
+			return nil, fmt.Errorf("duplicate storage target %q", t)
+		}
+		seen[t] = true
+		targets = append(targets, t)
+	}
+	return targets, nil
+}
+
+// GetStorageTargets returns the parsed storage targets. Empty targets mean
+// the default ones.
+func (cfg RecorderConfig) GetStorageTargets() ([]StorageTarget, error) {
+	if cfg.StorageTargets == "" {
+		return ParseStorageTargets(StorageTargetsDefault)
+	}
+	return ParseStorageTargets(cfg.StorageTargets)
+}
+
 func (cfg RecorderConfig) IsValid() error {
 	if cfg == (RecorderConfig{}) {
 		return fmt.Errorf("config cannot be empty")
@@ -140,6 +269,36 @@ func (cfg RecorderConfig) IsValid() error {
 	if !cfg.VideoPreset.IsValid() {
 		return fmt.Errorf("VideoPreset value is not valid")
 	}
+	// An empty mode means the default one.
+	if cfg.CaptureMode != "" && !cfg.CaptureMode.IsValid() {
+		return fmt.Errorf("CaptureMode value is not valid")
+	}
+	if cfg.Locale != "" && !localeRE.MatchString(cfg.Locale) {
+		return fmt.Errorf("Locale value is not valid")
+	}
+	if cfg.Timezone != "" && !timezoneRE.MatchString(cfg.Timezone) {
+		return fmt.Errorf("Timezone value is not valid")
+	}
+	// A zero scale means the default one.
+	if cfg.DeviceScale != 0 && (cfg.DeviceScale < DeviceScaleMin || cfg.DeviceScale > DeviceScaleMax) {
+		return fmt.Errorf("DeviceScale value is not valid")
+	}
+	if cfg.IdleTimeout != 0 && (cfg.IdleTimeout < IdleTimeoutMin || cfg.IdleTimeout > IdleTimeoutMax) {
+		return fmt.Errorf("IdleTimeout value is not valid")
+	}
+	if cfg.MaxDuration != 0 && cfg.MaxDuration < MaxDurationMin {
+		return fmt.Errorf("MaxDuration value is not valid")
+	}
+	if cfg.MaxFileSize != 0 && cfg.MaxFileSize < MaxFileSizeMin {
+		return fmt.Errorf("MaxFileSize value is not valid")
+	}
+	targets, err := cfg.GetStorageTargets()
+	if err != nil {
+		return fmt.Errorf("StorageTargets value is not valid")
+	}
+	if cfg.StreamingUpload && !slices.Contains(targets, StorageTargetS3) {
+		return fmt.Errorf("StreamingUpload requires the s3 storage target")
+	}
 
 	return nil
 }
@@ -172,6 +331,18 @@ func (cfg *RecorderConfig) SetDefaults() {
 	if cfg.VideoPreset == "" {
 		cfg.VideoPreset = VideoPresetDefault
 	}
+
+	if cfg.CaptureMode == "" {
+		cfg.CaptureMode = CaptureModeDefault
+	}
+
+	if cfg.DeviceScale == 0 {
+		cfg.DeviceScale = DeviceScaleDefault
+	}
+
+	if cfg.StorageTargets == "" {
+		cfg.StorageTargets = StorageTargetsDefault
+	}
 }
 
 func (cfg RecorderConfig) ToEnv() []string {
@@ -192,6 +363,16 @@ func (cfg RecorderConfig) ToEnv() []string {
 		fmt.Sprintf("FRAME_RATE=%d", cfg.FrameRate),
 		fmt.Sprintf("VIDEO_PRESET=%s", cfg.VideoPreset),
 		fmt.Sprintf("OUTPUT_FORMAT=%s", cfg.OutputFormat),
+		fmt.Sprintf("CAPTURE_MODE=%s", cfg.CaptureMode),
+		fmt.Sprintf("LOCALE=%s", cfg.Locale),
+		fmt.Sprintf("TIMEZONE=%s", cfg.Timezone),
+		fmt.Sprintf("DEVICE_SCALE=%g", cfg.DeviceScale),
+		fmt.Sprintf("ATTENDANCE_REPORT=%t", cfg.AttendanceReport),
+		fmt.Sprintf("IDLE_TIMEOUT=%d", cfg.IdleTimeout),
+		fmt.Sprintf("MAX_DURATION=%d", cfg.MaxDuration),
+		fmt.Sprintf("MAX_FILE_SIZE=%d", cfg.MaxFileSize),
+		fmt.Sprintf("STORAGE_TARGETS=%s", cfg.StorageTargets),
+		fmt.Sprintf("STREAMING_UPLOAD=%t", cfg.StreamingUpload),
 	}
 }
 
@@ -201,18 +382,28 @@ func (cfg RecorderConfig) ToMap() map[string]any {
 	}
 
 	return map[string]any{
-		"site_url":      cfg.SiteURL,
-		"call_id":       cfg.CallID,
-		"post_id":       cfg.PostID,
-		"recording_id":  cfg.RecordingID,
-		"auth_token":    cfg.AuthToken,
-		"width":         cfg.Width,
-		"height":        cfg.Height,
-		"video_rate":    cfg.VideoRate,
-		"audio_rate":    cfg.AudioRate,
-		"frame_rate":    cfg.FrameRate,
-		"video_preset":  cfg.VideoPreset,
-		"output_format": cfg.OutputFormat,
+		"site_url":          cfg.SiteURL,
+		"call_id":           cfg.CallID,
+		"post_id":           cfg.PostID,
+		"recording_id":      cfg.RecordingID,
+		"auth_token":        cfg.AuthToken,
+		"width":             cfg.Width,
+		"height":            cfg.Height,
+		"video_rate":        cfg.VideoRate,
+		"audio_rate":        cfg.AudioRate,
+		"frame_rate":        cfg.FrameRate,
+		"video_preset":      cfg.VideoPreset,
+		"output_format":     cfg.OutputFormat,
+		"capture_mode":      cfg.CaptureMode,
+		"locale":            cfg.Locale,
+		"timezone":          cfg.Timezone,
+		"device_scale":      cfg.DeviceScale,
+		"attendance_report": cfg.AttendanceReport,
+		"idle_timeout":      cfg.IdleTimeout,
+		"max_duration":      cfg.MaxDuration,
+		"max_file_size":     cfg.MaxFileSize,
+		"storage_targets":   cfg.StorageTargets,
+		"streaming_upload":  cfg.StreamingUpload,
 	}
 }
 
@@ -257,6 +448,48 @@ func (cfg *RecorderConfig) FromMap(m map[string]any) *RecorderConfig {
 	} else {
 		cfg.OutputFormat, _ = m["output_format"].(AVFormat)
 	}
+	if captureMode, ok := m["capture_mode"].(string); ok {
+		cfg.CaptureMode = CaptureMode(captureMode)
+	} else {
+		cfg.CaptureMode, _ = m["capture_mode"].(CaptureMode)
+	}
+	cfg.Locale, _ = m["locale"].(string)
+	cfg.Timezone, _ = m["timezone"].(string)
+	if deviceScale, ok := m["device_scale"].(float64); ok {
+		cfg.DeviceScale = deviceScale
+	} else if deviceScale, ok := m["device_scale"].(int); ok {
+		cfg.DeviceScale = float64(deviceScale)
+	}
+	cfg.AttendanceReport, _ = m["attendance_report"].(bool)
+	if idleTimeout, ok := m["idle_timeout"].(float64); ok {
+		cfg.IdleTimeout = int(idleTimeout)
+	} else {
+		cfg.IdleTimeout, _ = m["idle_timeout"].(int)
+	}
+	if maxDuration, ok := m["max_duration"].(float64); ok {
+		cfg.MaxDuration = int(maxDuration)
+	} else {
+		cfg.MaxDuration, _ = m["max_duration"].(int)
+	}
+	if maxFileSize, ok := m["max_file_size"].(float64); ok {
+		cfg.MaxFileSize = int(maxFileSize)
+	} else {
+		cfg.MaxFileSize, _ = m["max_file_size"].(int)
+	}
+	switch targets := m["storage_targets"].(type) {
+	case string:
+		cfg.StorageTargets = targets
+	case []any:
+		// Also accepting a JSON array for convenience.
+		vals := make([]string, 0, len(targets))
+		for _, t := range targets {
+			if s, ok := t.(string); ok {
+				vals = append(vals, s)
+			}
+		}
+		cfg.StorageTargets = strings.Join(vals, ",")
+	}
+	cfg.StreamingUpload, _ = m["streaming_upload"].(bool)
 	return cfg
 }
 
@@ -316,5 +549,62 @@ func LoadFromEnv() (RecorderConfig, error) {
 		cfg.OutputFormat = AVFormat(val)
 	}
 
+	if val := os.Getenv("CAPTURE_MODE"); val != "" {
+		cfg.CaptureMode = CaptureMode(val)
+	}
+
+	cfg.Locale = os.Getenv("LOCALE")
+	cfg.Timezone = os.Getenv("TIMEZONE")
+
+	if val := os.Getenv("DEVICE_SCALE"); val != "" {
+		scale, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			return cfg, fmt.Errorf("failed to parse DeviceScale: %w", err)
+		}
+		cfg.DeviceScale = scale
+	}
+
+	if val := os.Getenv("ATTENDANCE_REPORT"); val != "" {
+		enabled, err := strconv.ParseBool(val)
+		if err != nil {
+			return cfg, fmt.Errorf("failed to parse AttendanceReport: %w", err)
+		}
+		cfg.AttendanceReport = enabled
+	}
+
+	if val := os.Getenv("IDLE_TIMEOUT"); val != "" {
+		timeout, err := strconv.ParseInt(val, 10, 32)
+		if err != nil {
+			return cfg, fmt.Errorf("failed to parse IdleTimeout: %w", err)
+		}
+		cfg.IdleTimeout = int(timeout)
+	}
+
+	if val := os.Getenv("MAX_DURATION"); val != "" {
+		maxDuration, err := strconv.ParseInt(val, 10, 32)
+		if err != nil {
+			return cfg, fmt.Errorf("failed to parse MaxDuration: %w", err)
+		}
+		cfg.MaxDuration = int(maxDuration)
+	}
+
+	if val := os.Getenv("MAX_FILE_SIZE"); val != "" {
+		maxFileSize, err := strconv.ParseInt(val, 10, 32)
+		if err != nil {
+			return cfg, fmt.Errorf("failed to parse MaxFileSize: %w", err)
+		}
+		cfg.MaxFileSize = int(maxFileSize)
+	}
+
+	cfg.StorageTargets = os.Getenv("STORAGE_TARGETS")
+
+	if val := os.Getenv("STREAMING_UPLOAD"); val != "" {
+		enabled, err := strconv.ParseBool(val)
+		if err != nil {
+			return cfg, fmt.Errorf("failed to parse StreamingUpload: %w", err)
+		}
+		cfg.StreamingUpload = enabled
+	}
+
 	return cfg, nil
 }
@@ -159,6 +159,248 @@ func TestConfigIsValid(t *testing.T) {
 			},
 			expectedError: "OutputFormat value is not valid",
 		},
+		{
+			name: "invalid capture mode",
+			cfg: RecorderConfig{
+				SiteURL:      "http://localhost:8065",
+				CallID:       "8w8jorhr7j83uqr6y1st894hqe",
+				PostID:       "udzdsg7dwidbzcidx5khrf8nee",
+				RecordingID:  "67t5u6cmtfbb7jug739d43xa9e",
+				AuthToken:    "qj75unbsef83ik9p7ueypb6iyw",
+				Width:        1280,
+				Height:       720,
+				VideoRate:    1000,
+				AudioRate:    64,
+				FrameRate:    30,
+				VideoPreset:  "medium",
+				OutputFormat: AVFormatMP4,
+				CaptureMode:  "vnc",
+			},
+			expectedError: "CaptureMode value is not valid",
+		},
+		{
+			name: "default capture mode",
+			cfg: RecorderConfig{
+				SiteURL:        "http://localhost:8065",
+				CallID:         "8w8jorhr7j83uqr6y1st894hqe",
+				PostID:         "udzdsg7dwidbzcidx5khrf8nee",
+				RecordingID:    "67t5u6cmtfbb7jug739d43xa9e",
+				AuthToken:      "qj75unbsef83ik9p7ueypb6iyw",
+				Width:          1280,
+				Height:         720,
+				VideoRate:      1000,
+				AudioRate:      64,
+				FrameRate:      30,
+				VideoPreset:    "medium",
+				OutputFormat:   AVFormatMP4,
+				DeviceScale:    1,
+				StorageTargets: StorageTargetsDefault,
+			},
+		},
+		{
+			name: "invalid locale",
+			cfg: RecorderConfig{
+				SiteURL:      "http://localhost:8065",
+				CallID:       "8w8jorhr7j83uqr6y1st894hqe",
+				PostID:       "udzdsg7dwidbzcidx5khrf8nee",
+				RecordingID:  "67t5u6cmtfbb7jug739d43xa9e",
+				AuthToken:    "qj75unbsef83ik9p7ueypb6iyw",
+				Width:        1280,
+				Height:       720,
+				VideoRate:    1000,
+				AudioRate:    64,
+				FrameRate:    30,
+				VideoPreset:  "medium",
+				OutputFormat: AVFormatMP4,
+				CaptureMode:  CaptureModeScreencast,
+				DeviceScale:  1,
+				Locale:       "en_US.UTF-8",
+			},
+			expectedError: "Locale value is not valid",
+		},
+		{
+			name: "invalid timezone",
+			cfg: RecorderConfig{
+				SiteURL:      "http://localhost:8065",
+				CallID:       "8w8jorhr7j83uqr6y1st894hqe",
+				PostID:       "udzdsg7dwidbzcidx5khrf8nee",
+				RecordingID:  "67t5u6cmtfbb7jug739d43xa9e",
+				AuthToken:    "qj75unbsef83ik9p7ueypb6iyw",
+				Width:        1280,
+				Height:       720,
+				VideoRate:    1000,
+				AudioRate:    64,
+				FrameRate:    30,
+				VideoPreset:  "medium",
+				OutputFormat: AVFormatMP4,
+				CaptureMode:  CaptureModeScreencast,
+				DeviceScale:  1,
+				Timezone:     "Europe/../Berlin",
+			},
+			expectedError: "Timezone value is not valid",
+		},
+		{
+			name: "invalid device scale",
+			cfg: RecorderConfig{
+				SiteURL:      "http://localhost:8065",
+				CallID:       "8w8jorhr7j83uqr6y1st894hqe",
+				PostID:       "udzdsg7dwidbzcidx5khrf8nee",
+				RecordingID:  "67t5u6cmtfbb7jug739d43xa9e",
+				AuthToken:    "qj75unbsef83ik9p7ueypb6iyw",
+				Width:        1280,
+				Height:       720,
+				VideoRate:    1000,
+				AudioRate:    64,
+				FrameRate:    30,
+				VideoPreset:  "medium",
+				OutputFormat: AVFormatMP4,
+				CaptureMode:  CaptureModeScreencast,
+				DeviceScale:  0.5,
+			},
+			expectedError: "DeviceScale value is not valid",
+		},
+		{
+			name: "default device scale",
+			cfg: RecorderConfig{
+				SiteURL:        "http://localhost:8065",
+				CallID:         "8w8jorhr7j83uqr6y1st894hqe",
+				PostID:         "udzdsg7dwidbzcidx5khrf8nee",
+				RecordingID:    "67t5u6cmtfbb7jug739d43xa9e",
+				AuthToken:      "qj75unbsef83ik9p7ueypb6iyw",
+				Width:          1280,
+				Height:         720,
+				VideoRate:      1000,
+				AudioRate:      64,
+				FrameRate:      30,
+				VideoPreset:    "medium",
+				OutputFormat:   AVFormatMP4,
+				CaptureMode:    CaptureModeScreencast,
+				StorageTargets: StorageTargetsDefault,
+			},
+		},
+		{
+			name: "invalid idle timeout",
+			cfg: RecorderConfig{
+				SiteURL:      "http://localhost:8065",
+				CallID:       "8w8jorhr7j83uqr6y1st894hqe",
+				PostID:       "udzdsg7dwidbzcidx5khrf8nee",
+				RecordingID:  "67t5u6cmtfbb7jug739d43xa9e",
+				AuthToken:    "qj75unbsef83ik9p7ueypb6iyw",
+				Width:        1280,
+				Height:       720,
+				VideoRate:    1000,
+				AudioRate:    64,
+				FrameRate:    30,
+				VideoPreset:  "medium",
+				OutputFormat: AVFormatMP4,
+				CaptureMode:  CaptureModeScreencast,
+				DeviceScale:  1,
+				IdleTimeout:  30,
+			},
+			expectedError: "IdleTimeout value is not valid",
+		},
+		{
+			name: "invalid max duration",
+			cfg: RecorderConfig{
+				SiteURL:      "http://localhost:8065",
+				CallID:       "8w8jorhr7j83uqr6y1st894hqe",
+				PostID:       "udzdsg7dwidbzcidx5khrf8nee",
+				RecordingID:  "67t5u6cmtfbb7jug739d43xa9e",
+				AuthToken:    "qj75unbsef83ik9p7ueypb6iyw",
+				Width:        1280,
+				Height:       720,
+				VideoRate:    1000,
+				AudioRate:    64,
+				FrameRate:    30,
+				VideoPreset:  "medium",
+				OutputFormat: AVFormatMP4,
+				CaptureMode:  CaptureModeScreencast,
+				DeviceScale:  1,
+				MaxDuration:  -1,
+			},
+			expectedError: "MaxDuration value is not valid",
+		},
+		{
+			name: "invalid max file size",
+			cfg: RecorderConfig{
+				SiteURL:      "http://localhost:8065",
+				CallID:       "8w8jorhr7j83uqr6y1st894hqe",
+				PostID:       "udzdsg7dwidbzcidx5khrf8nee",
+				RecordingID:  "67t5u6cmtfbb7jug739d43xa9e",
+				AuthToken:    "qj75unbsef83ik9p7ueypb6iyw",
+				Width:        1280,
+				Height:       720,
+				VideoRate:    1000,
+				AudioRate:    64,
+				FrameRate:    30,
+				VideoPreset:  "medium",
+				OutputFormat: AVFormatMP4,
+				CaptureMode:  CaptureModeScreencast,
+				DeviceScale:  1,
+				MaxFileSize:  5,
+			},
+			expectedError: "MaxFileSize value is not valid",
+		},
+		{
+			name: "invalid storage targets",
+			cfg: RecorderConfig{
+				SiteURL:        "http://localhost:8065",
+				CallID:         "8w8jorhr7j83uqr6y1st894hqe",
+				PostID:         "udzdsg7dwidbzcidx5khrf8nee",
+				RecordingID:    "67t5u6cmtfbb7jug739d43xa9e",
+				AuthToken:      "qj75unbsef83ik9p7ueypb6iyw",
+				Width:          1280,
+				Height:         720,
+				VideoRate:      1000,
+				AudioRate:      64,
+				FrameRate:      30,
+				VideoPreset:    "medium",
+				OutputFormat:   AVFormatMP4,
+				CaptureMode:    CaptureModeScreencast,
+				DeviceScale:    1,
+				StorageTargets: "mattermost,ftp",
+			},
+			expectedError: "StorageTargets value is not valid",
+		},
+		{
+			name: "streaming upload without s3",
+			cfg: RecorderConfig{
+				SiteURL:         "http://localhost:8065",
+				CallID:          "8w8jorhr7j83uqr6y1st894hqe",
+				PostID:          "udzdsg7dwidbzcidx5khrf8nee",
+				RecordingID:     "67t5u6cmtfbb7jug739d43xa9e",
+				AuthToken:       "qj75unbsef83ik9p7ueypb6iyw",
+				Width:           1280,
+				Height:          720,
+				VideoRate:       1000,
+				AudioRate:       64,
+				FrameRate:       30,
+				VideoPreset:     "medium",
+				OutputFormat:    AVFormatMP4,
+				CaptureMode:     CaptureModeScreencast,
+				DeviceScale:     1,
+				StorageTargets:  "mattermost,local",
+				StreamingUpload: true,
+			},
+			expectedError: "StreamingUpload requires the s3 storage target",
+		},
+		{
+			name: "default storage targets",
+			cfg: RecorderConfig{
+				SiteURL:      "http://localhost:8065",
+				CallID:       "8w8jorhr7j83uqr6y1st894hqe",
+				PostID:       "udzdsg7dwidbzcidx5khrf8nee",
+				RecordingID:  "67t5u6cmtfbb7jug739d43xa9e",
+				AuthToken:    "qj75unbsef83ik9p7ueypb6iyw",
+				Width:        1280,
+				Height:       720,
+				VideoRate:    1000,
+				AudioRate:    64,
+				FrameRate:    30,
+				VideoPreset:  "medium",
+				OutputFormat: AVFormatMP4,
+			},
+		},
 		{
 			name: "valid config",
 			cfg: RecorderConfig{
@@ -174,6 +416,16 @@ func TestConfigIsValid(t *testing.T) {
 				FrameRate:    30,
 				VideoPreset:  "medium",
 				OutputFormat: AVFormatMP4,
+				CaptureMode:  CaptureModeScreencast,
+				DeviceScale:  1.5,
+				Locale:       "de-DE",
+				Timezone:     "America/Argentina/Buenos_Aires",
+				IdleTimeout:  900,
+				MaxDuration:  3600,
+				MaxFileSize:  1024,
+
+				StorageTargets:  "mattermost,s3",
+				StreamingUpload: true,
 			},
 		},
 	}
@@ -202,6 +454,10 @@ func TestConfigSetDefaults(t *testing.T) {
 			FrameRate:    FrameRateDefault,
 			VideoPreset:  VideoPresetDefault,
 			OutputFormat: OutputFormatDefault,
+			CaptureMode:  CaptureModeDefault,
+			DeviceScale:  DeviceScaleDefault,
+
+			StorageTargets: StorageTargetsDefault,
 		}, cfg)
 	})
 
@@ -219,10 +475,45 @@ func TestConfigSetDefaults(t *testing.T) {
 			FrameRate:    FrameRateDefault,
 			VideoPreset:  VideoPresetDefault,
 			OutputFormat: OutputFormatDefault,
+			CaptureMode:  CaptureModeDefault,
+			DeviceScale:  DeviceScaleDefault,
+
+			StorageTargets: StorageTargetsDefault,
 		}, cfg)
 	})
 }
 
+func TestParseStorageTargets(t *testing.T) {
+	targets, err := ParseStorageTargets("mattermost")
+	require.NoError(t, err)
+	require.Equal(t, []StorageTarget{StorageTargetMattermost}, targets)
+
+	targets, err = ParseStorageTargets("s3, local,mattermost")
+	require.NoError(t, err)
+	require.Equal(t, []StorageTarget{StorageTargetS3, StorageTargetLocal, StorageTargetMattermost}, targets)
+
+	_, err = ParseStorageTargets("")
+	require.EqualError(t, err, `invalid storage target ""`)
+
+	_, err = ParseStorageTargets("s3,gcs")
+	require.EqualError(t, err, `invalid storage target "gcs"`)
+
+	_, err = ParseStorageTargets("s3,s3")
+	require.EqualError(t, err, `duplicate storage target "s3"`)
+}
+
+func TestGetStorageTargets(t *testing.T) {
+	var cfg RecorderConfig
+	targets, err := cfg.GetStorageTargets()
+	require.NoError(t, err)
+	require.Equal(t, []StorageTarget{StorageTargetMattermost}, targets)
+
+	cfg.StorageTargets = "local,s3"
+	targets, err = cfg.GetStorageTargets()
+	require.NoError(t, err)
+	require.Equal(t, []StorageTarget{StorageTargetLocal, StorageTargetS3}, targets)
+}
+
 func TestLoadFromEnv(t *testing.T) {
 	t.Run("no env set", func(t *testing.T) {
 		cfg, err := LoadFromEnv()
@@ -260,6 +551,42 @@ func TestLoadFromEnv(t *testing.T) {
 		require.Empty(t, cfg)
 		require.EqualError(t, err, `failed to parse FrameRate: strconv.ParseInt: parsing "invalid": invalid syntax`)
 		os.Unsetenv("FRAME_RATE")
+
+		os.Setenv("ATTENDANCE_REPORT", "invalid")
+		cfg, err = LoadFromEnv()
+		require.Empty(t, cfg)
+		require.EqualError(t, err, `failed to parse AttendanceReport: strconv.ParseBool: parsing "invalid": invalid syntax`)
+		os.Unsetenv("ATTENDANCE_REPORT")
+
+		os.Setenv("STREAMING_UPLOAD", "invalid")
+		cfg, err = LoadFromEnv()
+		require.Empty(t, cfg)
+		require.EqualError(t, err, `failed to parse StreamingUpload: strconv.ParseBool: parsing "invalid": invalid syntax`)
+		os.Unsetenv("STREAMING_UPLOAD")
+
+		os.Setenv("DEVICE_SCALE", "invalid")
+		cfg, err = LoadFromEnv()
+		require.Empty(t, cfg)
+		require.EqualError(t, err, `failed to parse DeviceScale: strconv.ParseFloat: parsing "invalid": invalid syntax`)
+		os.Unsetenv("DEVICE_SCALE")
+
+		os.Setenv("IDLE_TIMEOUT", "invalid")
+		cfg, err = LoadFromEnv()
+		require.Empty(t, cfg)
+		require.EqualError(t, err, `failed to parse IdleTimeout: strconv.ParseInt: parsing "invalid": invalid syntax`)
+		os.Unsetenv("IDLE_TIMEOUT")
+
+		os.Setenv("MAX_DURATION", "invalid")
+		cfg, err = LoadFromEnv()
+		require.Empty(t, cfg)
+		require.EqualError(t, err, `failed to parse MaxDuration: strconv.ParseInt: parsing "invalid": invalid syntax`)
+		os.Unsetenv("MAX_DURATION")
+
+		os.Setenv("MAX_FILE_SIZE", "invalid")
+		cfg, err = LoadFromEnv()
+		require.Empty(t, cfg)
+		require.EqualError(t, err, `failed to parse MaxFileSize: strconv.ParseInt: parsing "invalid": invalid syntax`)
+		os.Unsetenv("MAX_FILE_SIZE")
 	})
 
 	t.Run("valid config", func(t *testing.T) {
@@ -285,6 +612,26 @@ func TestLoadFromEnv(t *testing.T) {
 		defer os.Unsetenv("FRAME_RATE")
 		os.Setenv("VIDEO_PRESET", "medium")
 		defer os.Unsetenv("VIDEO_PRESET")
+		os.Setenv("CAPTURE_MODE", "screencast")
+		defer os.Unsetenv("CAPTURE_MODE")
+		os.Setenv("ATTENDANCE_REPORT", "true")
+		defer os.Unsetenv("ATTENDANCE_REPORT")
+		os.Setenv("LOCALE", "de-DE")
+		defer os.Unsetenv("LOCALE")
+		os.Setenv("TIMEZONE", "Europe/Berlin")
+		defer os.Unsetenv("TIMEZONE")
+		os.Setenv("DEVICE_SCALE", "1.5")
+		defer os.Unsetenv("DEVICE_SCALE")
+		os.Setenv("IDLE_TIMEOUT", "600")
+		defer os.Unsetenv("IDLE_TIMEOUT")
+		os.Setenv("MAX_DURATION", "7200")
+		defer os.Unsetenv("MAX_DURATION")
+		os.Setenv("MAX_FILE_SIZE", "2048")
+		defer os.Unsetenv("MAX_FILE_SIZE")
+		os.Setenv("STORAGE_TARGETS", "mattermost,s3")
+		defer os.Unsetenv("STORAGE_TARGETS")
+		os.Setenv("STREAMING_UPLOAD", "true")
+		defer os.Unsetenv("STREAMING_UPLOAD")
 		cfg, err := LoadFromEnv()
 		require.NoError(t, err)
 		require.NotEmpty(t, cfg)
@@ -300,6 +647,17 @@ func TestLoadFromEnv(t *testing.T) {
 			AudioRate:   64,
 			FrameRate:   30,
 			VideoPreset: H264PresetMedium,
+			CaptureMode: CaptureModeScreencast,
+			Locale:      "de-DE",
+			Timezone:    "Europe/Berlin",
+			DeviceScale: 1.5,
+
+			AttendanceReport: true,
+			IdleTimeout:      600,
+			MaxDuration:      7200,
+			MaxFileSize:      2048,
+			StorageTargets:   "mattermost,s3",
+			StreamingUpload:  true,
 		}, cfg)
 	})
 }
@@ -325,6 +683,16 @@ func TestRecorderConfigToEnv(t *testing.T) {
 		"FRAME_RATE=30",
 		"VIDEO_PRESET=fast",
 		"OUTPUT_FORMAT=mp4",
+		"CAPTURE_MODE=x11grab",
+		"LOCALE=",
+		"TIMEZONE=",
+		"DEVICE_SCALE=1",
+		"ATTENDANCE_REPORT=false",
+		"IDLE_TIMEOUT=0",
+		"MAX_DURATION=0",
+		"MAX_FILE_SIZE=0",
+		"STORAGE_TARGETS=mattermost",
+		"STREAMING_UPLOAD=false",
 	}, cfg.ToEnv())
 }
 
@@ -342,4 +710,27 @@ func TestRecorderConfigMap(t *testing.T) {
 		err := c.FromMap(cfg.ToMap()).IsValid()
 		require.NoError(t, err)
 	})
+
+	t.Run("round trip", func(t *testing.T) {
+		cfg := cfg
+		cfg.CaptureMode = CaptureModeScreencast
+		cfg.AttendanceReport = true
+		cfg.Locale = "it-IT"
+		cfg.Timezone = "Europe/Rome"
+		cfg.DeviceScale = 2
+		cfg.IdleTimeout = 1800
+		cfg.MaxDuration = 3600
+		cfg.MaxFileSize = 500
+		cfg.StorageTargets = "mattermost,s3"
+		cfg.StreamingUpload = true
+		var c RecorderConfig
+		require.Equal(t, cfg, *c.FromMap(cfg.ToMap()))
+	})
+
+	t.Run("storage targets list", func(t *testing.T) {
+		m := cfg.ToMap()
+		m["storage_targets"] = []any{"s3", "local"}
+		var c RecorderConfig
+		require.Equal(t, "s3,local", c.FromMap(m).StorageTargets)
+	})
 }
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+const (
+	customFileMaxSize = 1024 * 1024 // 1MB
+)
+
+const customCSSScriptTmpl = `(() => {
+    const inject = () => {
+        const style = document.createElement('style');
+        style.id = 'calls-recorder-custom-css';
+        style.textContent = %s;
+        (document.head || document.documentElement).appendChild(style);
+    };
+    if (document.readyState === 'loading') {
+        document.addEventListener('DOMContentLoaded', inject);
+    } else {
+        inject();
+    }
+})();`
+
+func readCustomFile(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to stat file: %w", err)
+	}
+	if info.Size() > customFileMaxSize {
+		return "", fmt.Errorf("file is too big (%d bytes)", info.Size())
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read file: %w", err)
+	}
+
+	return string(data), nil
+}
+
+func genCustomCSSScript(css string) (string, error) {
+	data, err := json.Marshal(css)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode CSS: %w", err)
+	}
+
+	return fmt.Sprintf(customCSSScriptTmpl, string(data)), nil
+}
+
+// loadCustomPageScripts returns the scripts, if any, that should be injected in
+// the recording page to customize how it gets rendered. These are loaded from
+// the files pointed by the CUSTOM_CSS_FILE and CUSTOM_JS_FILE environment
+// variables.
+func loadCustomPageScripts() ([]string, error) {
+	var scripts []string
+
+	if path := os.Getenv("CUSTOM_CSS_FILE"); path != "" {
+		css, err := readCustomFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load custom CSS: %w", err)
+		}
+		script, err := genCustomCSSScript(css)
+		if err != nil {
+			return nil, err
+		}
+		slog.Info("loaded custom CSS", slog.String("path", path))
+		scripts = append(scripts, script)
+	}
+
+	if path := os.Getenv("CUSTOM_JS_FILE"); path != "" {
+		js, err := readCustomFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load custom JS: %w", err)
+		}
+		slog.Info("loaded custom JS", slog.String("path", path))
+		scripts = append(scripts, js)
+	}
+
+	return scripts, nil
+}
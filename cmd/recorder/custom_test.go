@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenCustomCSSScript(t *testing.T) {
+	script, err := genCustomCSSScript(".calls-header { display: none; }\n/* \"quoted\" </style> */")
+	require.NoError(t, err)
+	require.Contains(t, script, `style.textContent = ".calls-header { display: none; }\n/* \"quoted\" \u003c/style\u003e */";`)
+}
+
+func TestLoadCustomPageScripts(t *testing.T) {
+	dir := t.TempDir()
+	cssPath := filepath.Join(dir, "custom.css")
+	require.NoError(t, os.WriteFile(cssPath, []byte("body { background: black; }"), 0600))
+	jsPath := filepath.Join(dir, "custom.js")
+	require.NoError(t, os.WriteFile(jsPath, []byte("console.log('custom');"), 0600))
+
+	t.Run("none", func(t *testing.T) {
+		t.Setenv("CUSTOM_CSS_FILE", "")
+		t.Setenv("CUSTOM_JS_FILE", "")
+		scripts, err := loadCustomPageScripts()
+		require.NoError(t, err)
+		require.Empty(t, scripts)
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		t.Setenv("CUSTOM_CSS_FILE", filepath.Join(dir, "missing.css"))
+		_, err := loadCustomPageScripts()
+		require.ErrorContains(t, err, "failed to load custom CSS: failed to stat file")
+	})
+
+	t.Run("file too big", func(t *testing.T) {
+		bigPath := filepath.Join(dir, "big.js")
+		require.NoError(t, os.WriteFile(bigPath, []byte(strings.Repeat("a", customFileMaxSize+1)), 0600))
+		t.Setenv("CUSTOM_CSS_FILE", "")
+		t.Setenv("CUSTOM_JS_FILE", bigPath)
+		_, err := loadCustomPageScripts()
+		require.EqualError(t, err, "failed to load custom JS: file is too big (1048577 bytes)")
+	})
+
+	t.Run("both", func(t *testing.T) {
+		t.Setenv("CUSTOM_CSS_FILE", cssPath)
+		t.Setenv("CUSTOM_JS_FILE", jsPath)
+		scripts, err := loadCustomPageScripts()
+		require.NoError(t, err)
+		require.Len(t, scripts, 2)
+		require.Contains(t, scripts[0], `"body { background: black; }"`)
+		require.Equal(t, "console.log('custom');", scripts[1])
+	})
+}
@@ -0,0 +1,580 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/mattermost/calls-recorder/cmd/recorder/config"
+)
+
+const (
+	daemonMaxJobsDefault     = 1
+	daemonRequestMaxBodySize = 1 << 20
+	daemonReadHeaderTimeout  = 10 * time.Second
+	daemonShutdownTimeout    = 10 * time.Second
+	// How long finished jobs are kept around so that their status can still be
+	// queried.
+	daemonJobRetention = time.Hour
+)
+
+type JobState string
+
+const (
+	JobStateStarting  JobState = "starting"
+	JobStateRecording JobState = "recording"
+	JobStateStopping  JobState = "stopping"
+	JobStateDone      JobState = "done"
+	JobStateFailed    JobState = "failed"
+)
+
+func (s JobState) isFinal() bool {
+	return s == JobStateDone || s == JobStateFailed
+}
+
+// daemonConfig holds the settings of the daemon mode.
+type daemonConfig struct {
+	// ListenAddr is the address the job API listens on. Paths prefixed by
+	// "unix:" are served through a unix socket.
+	ListenAddr string
+	// MaxJobs is the maximum number of jobs that can be running at the same
+	// time.
+	MaxJobs int
+	// APIToken, if set, is required as bearer token on every API request. It
+	// can only be omitted when listening on a unix socket or loopback address.
+	APIToken string
+}
+
+// getDaemonConfig returns the daemon settings from the environment. An empty
+// ListenAddr means the daemon mode is disabled.
+func getDaemonConfig() (daemonConfig, error) {
+	cfg := daemonConfig{
+		ListenAddr: os.Getenv("DAEMON_LISTEN_ADDR"),
+		MaxJobs:    daemonMaxJobsDefault,
+		APIToken:   os.Getenv("DAEMON_API_TOKEN"),
+	}
+
+	if val := os.Getenv("DAEMON_MAX_JOBS"); val != "" {
+		n, err := strconv.Atoi(val)
+		if err != nil {
+			return cfg, fmt.Errorf("failed to parse DAEMON_MAX_JOBS: %w", err)
+		} else if n <= 0 {
+			return cfg, fmt.Errorf("failed to parse DAEMON_MAX_JOBS: value should be positive")
+		}
+		cfg.MaxJobs = n
+	}
+
+	// The API can start recordings with arbitrary credentials so it's never
+	// exposed beyond the host without authentication.
+	if cfg.ListenAddr != "" && cfg.APIToken == "" && !isLocalListenAddr(cfg.ListenAddr) {
+		return cfg, fmt.Errorf("failed to parse DAEMON_LISTEN_ADDR: DAEMON_API_TOKEN is required to listen on a non-loopback address")
+	}
+
+	return cfg, nil
+}
+
+// isLocalListenAddr returns whether the given listen address is only
+// reachable from the host, that is a unix socket or a loopback address.
+func isLocalListenAddr(addr string) bool {
+	if strings.HasPrefix(addr, "unix:") {
+		return true
+	}
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// jobRecorder is the subset of the Recorder API needed to run a job.
+type jobRecorder interface {
+	ResumeUpload() (bool, error)
+	Start() error
+	Stop() error
+	ReportJobFailure(errMsg string) error
+	StopRequested() <-chan struct{}
+	TruncationReason() string
+}
+
+type daemonJob struct {
+	id  string
+	cfg config.RecorderConfig
+
+	mut       sync.RWMutex
+	state     JobState
+	err       string
+	truncated string
+	createAt  time.Time
+	endAt     time.Time
+
+	stopOnce sync.Once
+	stopCh   chan struct{}
+	doneCh   chan struct{}
+}
+
+// JobStatus is the job representation returned by the API.
+type JobStatus struct {
+	ID       string   `json:"id"`
+	CallID   string   `json:"call_id"`
+	State    JobState `json:"state"`
+	Error    string   `json:"error,omitempty"`
+	CreateAt int64    `json:"create_at"`
+	EndAt    int64    `json:"end_at,omitempty"`
+	// Truncated holds why the recording was cut short by policy, if it was.
+	Truncated string `json:"truncated,omitempty"`
+}
+
+func (j *daemonJob) status() JobStatus {
+	j.mut.RLock()
+	defer j.mut.RUnlock()
+
+	st := JobStatus{
+		ID:        j.id,
+		CallID:    j.cfg.CallID,
+		State:     j.state,
+		Error:     j.err,
+		CreateAt:  j.createAt.UnixMilli(),
+		Truncated: j.truncated,
+	}
+	if !j.endAt.IsZero() {
+		st.EndAt = j.endAt.UnixMilli()
+	}
+
+	return st
+}
+
+func (j *daemonJob) getState() JobState {
+	j.mut.RLock()
+	defer j.mut.RUnlock()
+	return j.state
+}
+
+func (j *daemonJob) setState(state JobState) {
+	j.mut.Lock()
+	defer j.mut.Unlock()
+	// A stop request can come in while the job is still starting, in which
+	// case it should not be overridden.
+	if state == JobStateRecording && j.state == JobStateStopping {
+		return
+	}
+	j.state = state
+}
+
+func (j *daemonJob) finish(err error) {
+	j.mut.Lock()
+	defer j.mut.Unlock()
+	j.state = JobStateDone
+	if err != nil {
+		j.state = JobStateFailed
+		j.err = err.Error()
+	}
+	j.endAt = time.Now()
+}
+
+func (j *daemonJob) setTruncated(reason string) {
+	j.mut.Lock()
+	defer j.mut.Unlock()
+	j.truncated = reason
+}
+
+func (j *daemonJob) stop() {
+	j.stopOnce.Do(func() {
+		close(j.stopCh)
+	})
+}
+
+// daemon runs recording jobs submitted through its HTTP API.
+type daemon struct {
+	cfg      daemonConfig
+	redactor *redactor
+
+	// newRecorder creates the recorder for a job. Overridable for testing.
+	newRecorder func(cfg config.RecorderConfig, dataPath string) (jobRecorder, error)
+
+	mut  sync.RWMutex
+	jobs map[string]*daemonJob
+	// set once the daemon is shutting down to refuse new jobs
+	closed bool
+
+	wg sync.WaitGroup
+}
+
+func newDaemon(cfg daemonConfig, redactor *redactor) *daemon {
+	return &daemon{
+		cfg:      cfg,
+		redactor: redactor,
+		newRecorder: func(cfg config.RecorderConfig, dataPath string) (jobRecorder, error) {
+			return NewRecorder(cfg, dataPath)
+		},
+		jobs: map[string]*daemonJob{},
+	}
+}
+
+var (
+	errDaemonClosed     = errors.New("daemon is shutting down")
+	errJobExists        = errors.New("job already exists")
+	errTooManyJobs      = errors.New("maximum number of jobs reached")
+	errJobNotFound      = errors.New("job not found")
+	errDaemonBadRequest = errors.New("bad request")
+)
+
+// pruneJobsLocked removes finished jobs past their retention period.
+func (d *daemon) pruneJobsLocked() {
+	for id, job := range d.jobs {
+		st := job.status()
+		if st.State.isFinal() && time.Since(time.UnixMilli(st.EndAt)) > daemonJobRetention {
+			delete(d.jobs, id)
+		}
+	}
+}
+
+func (d *daemon) activeJobsLocked() int {
+	var n int
+	for _, job := range d.jobs {
+		if !job.getState().isFinal() {
+			n++
+		}
+	}
+	return n
+}
+
+// createJob validates the given config and starts the job for it.
+func (d *daemon) createJob(cfg config.RecorderConfig) (*daemonJob, error) {
+	cfg.SetDefaults()
+	if err := cfg.IsValid(); err != nil {
+		return nil, fmt.Errorf("%w: invalid config: %s", errDaemonBadRequest, err.Error())
+	}
+
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	if d.closed {
+		return nil, errDaemonClosed
+	}
+
+	d.pruneJobsLocked()
+
+	if job, ok := d.jobs[cfg.RecordingID]; ok && !job.getState().isFinal() {
+		return nil, errJobExists
+	}
+
+	if d.activeJobsLocked() >= d.cfg.MaxJobs {
+		return nil, errTooManyJobs
+	}
+
+	if d.redactor != nil {
+		d.redactor.addSecret(cfg.AuthToken)
+	}
+
+	job := &daemonJob{
+		id:       cfg.RecordingID,
+		cfg:      cfg,
+		state:    JobStateStarting,
+		createAt: time.Now(),
+		stopCh:   make(chan struct{}),
+		doneCh:   make(chan struct{}),
+	}
+	d.jobs[job.id] = job
+
+	d.wg.Add(1)
+	go d.runJob(job)
+
+	return job, nil
+}
+
+func (d *daemon) getJob(id string) (*daemonJob, error) {
+	d.mut.RLock()
+	defer d.mut.RUnlock()
+	job, ok := d.jobs[id]
+	if !ok {
+		return nil, errJobNotFound
+	}
+	return job, nil
+}
+
+func (d *daemon) listJobs() []JobStatus {
+	d.mut.RLock()
+	defer d.mut.RUnlock()
+
+	list := make([]JobStatus, 0, len(d.jobs))
+	for _, job := range d.jobs {
+		list = append(list, job.status())
+	}
+	slices.SortFunc(list, func(a, b JobStatus) int {
+		if c := cmp.Compare(a.CreateAt, b.CreateAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+
+	return list
+}
+
+func (d *daemon) stopJob(id string) (*daemonJob, error) {
+	job, err := d.getJob(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if !job.getState().isFinal() {
+		job.setState(JobStateStopping)
+		job.stop()
+	}
+
+	return job, nil
+}
+
+// runJob drives the lifecycle of a job, mirroring what the single job mode
+// does in main.
+func (d *daemon) runJob(job *daemonJob) {
+	defer d.wg.Done()
+	defer close(job.doneCh)
+
+	logger := slog.With(slog.String("jobID", job.id))
+
+	dataPath := getDataDir(job.id)
+	if err := os.MkdirAll(dataPath, 0700); err != nil {
+		logger.Error("failed to create data directory", slog.String("err", err.Error()))
+		job.finish(fmt.Errorf("failed to create data directory: %w", err))
+		return
+	}
+
+	rec, err := d.newRecorder(job.cfg, dataPath)
+	if err != nil {
+		logger.Error("failed to create recorder", slog.String("err", err.Error()))
+		job.finish(fmt.Errorf("failed to create recorder: %w", err))
+		return
+	}
+
+	// The process may have been restarted while uploading a previous run of
+	// this job, in which case there's nothing left to record.
+	if resumed, err := rec.ResumeUpload(); err != nil {
+		logger.Error("failed to resume upload", slog.String("err", err.Error()))
+		job.finish(fmt.Errorf("failed to resume upload: %w", err))
+		return
+	} else if resumed {
+		logger.Info("recording has finished")
+		job.finish(nil)
+		return
+	}
+
+	logger.Info("starting recording")
+
+	if err := rec.Start(); err != nil {
+		logger.Error("failed to start recording", slog.String("err", err.Error()))
+		if err := rec.ReportJobFailure(err.Error()); err != nil {
+			logger.Error("failed to report job failure", slog.String("err", err.Error()))
+		}
+		if err := rec.Stop(); err != nil {
+			logger.Error("failed to stop recorder", slog.String("err", err.Error()))
+		}
+		job.finish(fmt.Errorf("failed to start recording: %w", err))
+		return
+	}
+
+	job.setState(JobStateRecording)
+	logger.Info("recording has started")
+
+	select {
+	case <-job.stopCh:
+		logger.Info("stop requested through API, stopping recording")
+	case <-rec.StopRequested():
+		logger.Info("stop requested, stopping recording")
+	}
+
+	job.setState(JobStateStopping)
+	job.setTruncated(rec.TruncationReason())
+
+	if err := rec.Stop(); err != nil {
+		logger.Error("failed to stop recording", slog.String("err", err.Error()))
+		job.finish(fmt.Errorf("failed to stop recording: %w", err))
+		return
+	}
+
+	logger.Info("recording has finished")
+	job.finish(nil)
+}
+
+// shutdown refuses any new job, stops the running ones and waits for them to
+// finish.
+func (d *daemon) shutdown() {
+	d.mut.Lock()
+	d.closed = true
+	for _, job := range d.jobs {
+		if !job.getState().isFinal() {
+			job.setState(JobStateStopping)
+			job.stop()
+		}
+	}
+	d.mut.Unlock()
+
+	d.wg.Wait()
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("failed to encode response", slog.String("err", err.Error()))
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, errDaemonBadRequest):
+		code = http.StatusBadRequest
+	case errors.Is(err, errJobNotFound):
+		code = http.StatusNotFound
+	case errors.Is(err, errJobExists):
+		code = http.StatusConflict
+	case errors.Is(err, errTooManyJobs):
+		code = http.StatusTooManyRequests
+	case errors.Is(err, errDaemonClosed):
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+func (d *daemon) handleCreateJob(w http.ResponseWriter, r *http.Request) {
+	var data map[string]any
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, daemonRequestMaxBodySize)).Decode(&data); err != nil {
+		writeJSONError(w, fmt.Errorf("%w: failed to decode body: %s", errDaemonBadRequest, err.Error()))
+		return
+	}
+
+	var cfg config.RecorderConfig
+	job, err := d.createJob(*cfg.FromMap(data))
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, job.status())
+}
+
+func (d *daemon) handleListJobs(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, d.listJobs())
+}
+
+func (d *daemon) handleGetJob(w http.ResponseWriter, r *http.Request) {
+	job, err := d.getJob(r.PathValue("id"))
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, job.status())
+}
+
+func (d *daemon) handleStopJob(w http.ResponseWriter, r *http.Request) {
+	job, err := d.stopJob(r.PathValue("id"))
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, job.status())
+}
+
+// authMiddleware requires the configured API token, if any, to be passed as
+// bearer token.
+func (d *daemon) authMiddleware(next http.Handler) http.Handler {
+	if d.cfg.APIToken == "" {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(d.cfg.APIToken)) != 1 {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (d *daemon) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /jobs", d.handleCreateJob)
+	mux.HandleFunc("GET /jobs", d.handleListJobs)
+	mux.HandleFunc("GET /jobs/{id}", d.handleGetJob)
+	mux.HandleFunc("POST /jobs/{id}/stop", d.handleStopJob)
+	return d.authMiddleware(mux)
+}
+
+func listenDaemon(addr string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		// Cleaning up any socket left behind by a previous run.
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to remove socket file: %w", err)
+		}
+		return net.Listen("unix", path)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// runDaemon serves the job API until a termination signal is received, at
+// which point all the running jobs are stopped.
+func runDaemon(cfg daemonConfig, redactor *redactor) error {
+	ln, err := listenDaemon(cfg.ListenAddr)
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+
+	d := newDaemon(cfg, redactor)
+	srv := &http.Server{
+		Handler:           d.handler(),
+		ReadHeaderTimeout: daemonReadHeaderTimeout,
+	}
+
+	serveErrCh := make(chan error, 1)
+	go func() {
+		serveErrCh <- srv.Serve(ln)
+	}()
+
+	slog.Info("daemon is listening",
+		slog.String("addr", ln.Addr().String()),
+		slog.Int("maxJobs", cfg.MaxJobs),
+	)
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
+
+	var rerr error
+	select {
+	case <-sig:
+		slog.Info("received SIGTERM, stopping daemon")
+	case err := <-serveErrCh:
+		rerr = fmt.Errorf("failed to serve: %w", err)
+	}
+
+	// Jobs are stopped first so that their status can be queried while they
+	// are being finalized.
+	d.shutdown()
+
+	ctx, cancel := context.WithTimeout(context.Background(), daemonShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("failed to shutdown server", slog.String("err", err.Error()))
+	}
+
+	return rerr
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mattermost/calls-recorder/cmd/recorder/config"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeJobRecorder struct {
+	resumed       bool
+	startErr      error
+	stopRequestCh chan struct{}
+
+	mut       sync.Mutex
+	started   bool
+	stopped   bool
+	truncated string
+}
+
+func (r *fakeJobRecorder) ResumeUpload() (bool, error) {
+	return r.resumed, nil
+}
+
+func (r *fakeJobRecorder) isStarted() bool {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	return r.started
+}
+
+func (r *fakeJobRecorder) Start() error {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	r.started = true
+	return r.startErr
+}
+
+func (r *fakeJobRecorder) Stop() error {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	r.stopped = true
+	return nil
+}
+
+func (r *fakeJobRecorder) ReportJobFailure(_ string) error {
+	return nil
+}
+
+func (r *fakeJobRecorder) StopRequested() <-chan struct{} {
+	return r.stopRequestCh
+}
+
+func (r *fakeJobRecorder) TruncationReason() string {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	return r.truncated
+}
+
+func (r *fakeJobRecorder) isStopped() bool {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	return r.stopped
+}
+
+func newTestDaemon(t *testing.T, cfg daemonConfig) (*daemon, *httptest.Server, map[string]*fakeJobRecorder) {
+	t.Helper()
+
+	t.Setenv("DATA_DIR", t.TempDir())
+
+	var mut sync.Mutex
+	recorders := map[string]*fakeJobRecorder{}
+	d := newDaemon(cfg, nil)
+	d.newRecorder = func(cfg config.RecorderConfig, _ string) (jobRecorder, error) {
+		mut.Lock()
+		defer mut.Unlock()
+		rec := &fakeJobRecorder{stopRequestCh: make(chan struct{})}
+		switch cfg.CallID {
+		case "failfailfailfailfailfailfa":
+			rec.startErr = fmt.Errorf("start failed")
+		case "resumeresumeresumeresumere":
+			rec.resumed = true
+		}
+		recorders[cfg.RecordingID] = rec
+		return rec, nil
+	}
+
+	srv := httptest.NewServer(d.handler())
+	t.Cleanup(func() {
+		d.shutdown()
+		srv.Close()
+	})
+
+	return d, srv, recorders
+}
+
+func testJobConfig(recID string) map[string]any {
+	return map[string]any{
+		"site_url":     "http://localhost:8065",
+		"call_id":      "8w8jorhr7j83uqr6y1st894hqe",
+		"post_id":      "udzdsg7dwidbzcidx5khrf8nee",
+		"recording_id": recID,
+		"auth_token":   "qj75unbsef83ik9p7ueypb6iyw",
+	}
+}
+
+func doDaemonRequest(t *testing.T, method, url string, body any) (int, []byte) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if body != nil {
+		require.NoError(t, json.NewEncoder(&buf).Encode(body))
+	}
+
+	req, err := http.NewRequest(method, url, &buf)
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var out bytes.Buffer
+	_, err = out.ReadFrom(resp.Body)
+	require.NoError(t, err)
+
+	return resp.StatusCode, out.Bytes()
+}
+
+func waitJobState(t *testing.T, d *daemon, id string, state JobState) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		job, err := d.getJob(id)
+		return err == nil && job.getState() == state
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestGetDaemonConfig(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		cfg, err := getDaemonConfig()
+		require.NoError(t, err)
+		require.Empty(t, cfg.ListenAddr)
+		require.Equal(t, daemonMaxJobsDefault, cfg.MaxJobs)
+	})
+
+	t.Run("valid", func(t *testing.T) {
+		t.Setenv("DAEMON_LISTEN_ADDR", "127.0.0.1:8090")
+		t.Setenv("DAEMON_MAX_JOBS", "4")
+		cfg, err := getDaemonConfig()
+		require.NoError(t, err)
+		require.Equal(t, "127.0.0.1:8090", cfg.ListenAddr)
+		require.Equal(t, 4, cfg.MaxJobs)
+	})
+
+	t.Run("token required", func(t *testing.T) {
+		for _, addr := range []string{":8090", "0.0.0.0:8090", "192.168.1.10:8090", "mm-recorder:8090"} {
+			t.Setenv("DAEMON_LISTEN_ADDR", addr)
+			_, err := getDaemonConfig()
+			require.EqualError(t, err, "failed to parse DAEMON_LISTEN_ADDR: DAEMON_API_TOKEN is required to listen on a non-loopback address", addr)
+		}
+
+		t.Setenv("DAEMON_API_TOKEN", "secret")
+		cfg, err := getDaemonConfig()
+		require.NoError(t, err)
+		require.Equal(t, "secret", cfg.APIToken)
+	})
+
+	t.Run("local without token", func(t *testing.T) {
+		for _, addr := range []string{"unix:/tmp/recorder.sock", "localhost:8090", "127.0.0.1:8090", "[::1]:8090"} {
+			t.Setenv("DAEMON_LISTEN_ADDR", addr)
+			cfg, err := getDaemonConfig()
+			require.NoError(t, err, addr)
+			require.Equal(t, addr, cfg.ListenAddr)
+		}
+	})
+
+	t.Run("invalid max jobs", func(t *testing.T) {
+		t.Setenv("DAEMON_MAX_JOBS", "0")
+		_, err := getDaemonConfig()
+		require.EqualError(t, err, "failed to parse DAEMON_MAX_JOBS: value should be positive")
+
+		t.Setenv("DAEMON_MAX_JOBS", "many")
+		_, err = getDaemonConfig()
+		require.Error(t, err)
+	})
+}
+
+func TestDaemonJobs(t *testing.T) {
+	recID := "67t5u6cmtfbb7jug739d43xa9e"
+
+	t.Run("lifecycle", func(t *testing.T) {
+		d, srv, recorders := newTestDaemon(t, daemonConfig{MaxJobs: 1})
+
+		code, body := doDaemonRequest(t, http.MethodPost, srv.URL+"/jobs", testJobConfig(recID))
+		require.Equal(t, http.StatusCreated, code)
+		require.NotContains(t, string(body), "qj75unbsef83ik9p7ueypb6iyw")
+		var st JobStatus
+		require.NoError(t, json.Unmarshal(body, &st))
+		require.Equal(t, recID, st.ID)
+		require.Equal(t, "8w8jorhr7j83uqr6y1st894hqe", st.CallID)
+
+		waitJobState(t, d, recID, JobStateRecording)
+
+		code, body = doDaemonRequest(t, http.MethodGet, srv.URL+"/jobs/"+recID, nil)
+		require.Equal(t, http.StatusOK, code)
+		require.NoError(t, json.Unmarshal(body, &st))
+		require.Equal(t, JobStateRecording, st.State)
+
+		code, body = doDaemonRequest(t, http.MethodGet, srv.URL+"/jobs", nil)
+		require.Equal(t, http.StatusOK, code)
+		var list []JobStatus
+		require.NoError(t, json.Unmarshal(body, &list))
+		require.Len(t, list, 1)
+
+		code, _ = doDaemonRequest(t, http.MethodPost, srv.URL+"/jobs/"+recID+"/stop", nil)
+		require.Equal(t, http.StatusAccepted, code)
+
+		waitJobState(t, d, recID, JobStateDone)
+		require.True(t, recorders[recID].isStopped())
+
+		code, body = doDaemonRequest(t, http.MethodGet, srv.URL+"/jobs/"+recID, nil)
+		require.Equal(t, http.StatusOK, code)
+		require.NoError(t, json.Unmarshal(body, &st))
+		require.NotZero(t, st.EndAt)
+	})
+
+	t.Run("stop requested by recorder", func(t *testing.T) {
+		d, srv, recorders := newTestDaemon(t, daemonConfig{MaxJobs: 1})
+
+		code, _ := doDaemonRequest(t, http.MethodPost, srv.URL+"/jobs", testJobConfig(recID))
+		require.Equal(t, http.StatusCreated, code)
+		waitJobState(t, d, recID, JobStateRecording)
+
+		close(recorders[recID].stopRequestCh)
+		waitJobState(t, d, recID, JobStateDone)
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		d, srv, recorders := newTestDaemon(t, daemonConfig{MaxJobs: 1})
+
+		code, _ := doDaemonRequest(t, http.MethodPost, srv.URL+"/jobs", testJobConfig(recID))
+		require.Equal(t, http.StatusCreated, code)
+		waitJobState(t, d, recID, JobStateRecording)
+
+		rec := recorders[recID]
+		rec.mut.Lock()
+		rec.truncated = "maximum duration of 1h0m0s reached"
+		rec.mut.Unlock()
+		close(rec.stopRequestCh)
+		waitJobState(t, d, recID, JobStateDone)
+
+		code, body := doDaemonRequest(t, http.MethodGet, srv.URL+"/jobs/"+recID, nil)
+		require.Equal(t, http.StatusOK, code)
+		var st JobStatus
+		require.NoError(t, json.Unmarshal(body, &st))
+		require.Equal(t, "maximum duration of 1h0m0s reached", st.Truncated)
+	})
+
+	t.Run("start failure", func(t *testing.T) {
+		d, srv, recorders := newTestDaemon(t, daemonConfig{MaxJobs: 1})
+
+		cfg := testJobConfig(recID)
+		cfg["call_id"] = "failfailfailfailfailfailfa"
+		code, _ := doDaemonRequest(t, http.MethodPost, srv.URL+"/jobs", cfg)
+		require.Equal(t, http.StatusCreated, code)
+
+		waitJobState(t, d, recID, JobStateFailed)
+		require.True(t, recorders[recID].isStopped())
+
+		job, err := d.getJob(recID)
+		require.NoError(t, err)
+		require.Equal(t, "failed to start recording: start failed", job.status().Error)
+	})
+
+	t.Run("resumed upload", func(t *testing.T) {
+		d, srv, recorders := newTestDaemon(t, daemonConfig{MaxJobs: 1})
+
+		cfg := testJobConfig(recID)
+		cfg["call_id"] = "resumeresumeresumeresumere"
+		code, _ := doDaemonRequest(t, http.MethodPost, srv.URL+"/jobs", cfg)
+		require.Equal(t, http.StatusCreated, code)
+
+		waitJobState(t, d, recID, JobStateDone)
+		require.False(t, recorders[recID].isStarted())
+	})
+
+	t.Run("invalid config", func(t *testing.T) {
+		_, srv, _ := newTestDaemon(t, daemonConfig{MaxJobs: 1})
+
+		code, _ := doDaemonRequest(t, http.MethodPost, srv.URL+"/jobs", map[string]any{})
+		require.Equal(t, http.StatusBadRequest, code)
+
+		code, _ = doDaemonRequest(t, http.MethodPost, srv.URL+"/jobs", "not an object")
+		require.Equal(t, http.StatusBadRequest, code)
+	})
+
+	t.Run("duplicate and limit", func(t *testing.T) {
+		d, srv, _ := newTestDaemon(t, daemonConfig{MaxJobs: 1})
+
+		code, _ := doDaemonRequest(t, http.MethodPost, srv.URL+"/jobs", testJobConfig(recID))
+		require.Equal(t, http.StatusCreated, code)
+		waitJobState(t, d, recID, JobStateRecording)
+
+		code, _ = doDaemonRequest(t, http.MethodPost, srv.URL+"/jobs", testJobConfig(recID))
+		require.Equal(t, http.StatusConflict, code)
+
+		code, _ = doDaemonRequest(t, http.MethodPost, srv.URL+"/jobs", testJobConfig("ac5xg9fzxt8nmrjrxshkzpt9ph"))
+		require.Equal(t, http.StatusTooManyRequests, code)
+
+		// Once the job is finished a new one can be started.
+		code, _ = doDaemonRequest(t, http.MethodPost, srv.URL+"/jobs/"+recID+"/stop", nil)
+		require.Equal(t, http.StatusAccepted, code)
+		waitJobState(t, d, recID, JobStateDone)
+
+		code, _ = doDaemonRequest(t, http.MethodPost, srv.URL+"/jobs", testJobConfig("ac5xg9fzxt8nmrjrxshkzpt9ph"))
+		require.Equal(t, http.StatusCreated, code)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		_, srv, _ := newTestDaemon(t, daemonConfig{MaxJobs: 1})
+
+		code, _ := doDaemonRequest(t, http.MethodGet, srv.URL+"/jobs/"+recID, nil)
+		require.Equal(t, http.StatusNotFound, code)
+
+		code, _ = doDaemonRequest(t, http.MethodPost, srv.URL+"/jobs/"+recID+"/stop", nil)
+		require.Equal(t, http.StatusNotFound, code)
+	})
+
+	t.Run("shutdown", func(t *testing.T) {
+		d, srv, recorders := newTestDaemon(t, daemonConfig{MaxJobs: 1})
+
+		code, _ := doDaemonRequest(t, http.MethodPost, srv.URL+"/jobs", testJobConfig(recID))
+		require.Equal(t, http.StatusCreated, code)
+		waitJobState(t, d, recID, JobStateRecording)
+
+		d.shutdown()
+		require.True(t, recorders[recID].isStopped())
+
+		code, _ = doDaemonRequest(t, http.MethodPost, srv.URL+"/jobs", testJobConfig("ac5xg9fzxt8nmrjrxshkzpt9ph"))
+		require.Equal(t, http.StatusServiceUnavailable, code)
+	})
+}
+
+func TestDaemonAuth(t *testing.T) {
+	_, srv, _ := newTestDaemon(t, daemonConfig{MaxJobs: 1, APIToken: "secret"})
+
+	code, _ := doDaemonRequest(t, http.MethodGet, srv.URL+"/jobs", nil)
+	require.Equal(t, http.StatusUnauthorized, code)
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/jobs", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+}
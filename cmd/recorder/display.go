@@ -0,0 +1,218 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	displayIDMin              = 45
+	displayIDMax              = 144
+	displayReadyTimeout       = 5 * time.Second
+	displayReadyCheckInterval = 50 * time.Millisecond
+	displayStopTimeout        = 5 * time.Second
+	displayLivenessInterval   = 5 * time.Second
+	// Number of times we try to start the display server in case a
+	// concurrent process took the display we picked in the meantime.
+	displayStartAttempts = 3
+)
+
+var (
+	x11LockPathFmt   = "/tmp/.X%d-lock"
+	x11SocketPathFmt = "/tmp/.X11-unix/X%d"
+)
+
+var errDisplayTaken = errors.New("display is taken")
+
+// reservedDisplays are the displays handed out to the jobs of this process.
+// They are skipped even before their server has created the lock file, so
+// that concurrent jobs never pick the same one.
+var reservedDisplays = struct {
+	mut sync.Mutex
+	ids map[int]bool
+}{ids: map[int]bool{}}
+
+func releaseDisplay(id int) {
+	reservedDisplays.mut.Lock()
+	defer reservedDisplays.mut.Unlock()
+	delete(reservedDisplays.ids, id)
+}
+
+func isProcessAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// probeDisplaySocket returns whether an X server is accepting connections
+// for the given display. Both the abstract and the file system sockets are
+// checked.
+func probeDisplaySocket(id int) bool {
+	path := fmt.Sprintf(x11SocketPathFmt, id)
+	for _, addr := range []string{"@" + path, path} {
+		conn, err := net.DialTimeout("unix", addr, time.Second)
+		if err == nil {
+			conn.Close()
+			return true
+		}
+	}
+	return false
+}
+
+// isDisplayOwnedBy returns whether the lock file of the given display is held
+// by the process with the given pid.
+func isDisplayOwnedBy(id, pid int) bool {
+	data, err := os.ReadFile(fmt.Sprintf(x11LockPathFmt, id))
+	if err != nil {
+		return false
+	}
+	lockPID, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	return err == nil && lockPID == pid
+}
+
+// isDisplayInUse returns whether the given display is taken, either because
+// a live process holds its lock file or because something is listening on its
+// socket.
+func isDisplayInUse(id int) bool {
+	if data, err := os.ReadFile(fmt.Sprintf(x11LockPathFmt, id)); err == nil {
+		pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		if err != nil || isProcessAlive(pid) {
+			// An unparsable lock file is treated as taken to be safe.
+			return true
+		}
+		// Stale lock files get removed by the X server on start.
+	}
+
+	return probeDisplaySocket(id)
+}
+
+// findFreeDisplay returns the first display number, starting from start, that
+// is not in use, and reserves it. It should be released through
+// releaseDisplay once done with.
+func findFreeDisplay(start int) (int, error) {
+	reservedDisplays.mut.Lock()
+	defer reservedDisplays.mut.Unlock()
+
+	for id := max(start, displayIDMin); id <= displayIDMax; id++ {
+		if !reservedDisplays.ids[id] && !isDisplayInUse(id) {
+			reservedDisplays.ids[id] = true
+			return id, nil
+		}
+	}
+	return 0, fmt.Errorf("no free display found in range [%d, %d]", displayIDMin, displayIDMax)
+}
+
+type displayServer struct {
+	id   int
+	proc *supervisedProcess
+}
+
+// waitReady blocks until the display server accepts connections. An error
+// wrapping errDisplayTaken is returned if it exited early, which is what Xvfb
+// does when another server holds the display.
+func (s *displayServer) waitReady(timeout time.Duration) error {
+	ticker := time.NewTicker(displayReadyCheckInterval)
+	defer ticker.Stop()
+	timeoutCh := time.After(timeout)
+
+	for {
+		// The socket could belong to another server that took the display
+		// in the meantime, so it's only trusted while our process is alive
+		// and holds the lock.
+		select {
+		case <-s.proc.done():
+			return fmt.Errorf("%w: display server exited before becoming ready: %v", errDisplayTaken, s.proc.err())
+		default:
+		}
+		if isDisplayOwnedBy(s.id, s.proc.pid()) && probeDisplaySocket(s.id) {
+			return nil
+		}
+
+		select {
+		case <-timeoutCh:
+			return fmt.Errorf("timed out waiting for display server to accept connections")
+		case <-ticker.C:
+		}
+	}
+}
+
+// stop terminates the display server, returning the error it exited with.
+func (s *displayServer) stop() error {
+	defer releaseDisplay(s.id)
+	if err := s.proc.stop(); err != nil {
+		return err
+	}
+	return s.proc.err()
+}
+
+func displayLivenessProbe(id int) func() error {
+	return func() error {
+		if !probeDisplaySocket(id) {
+			return fmt.Errorf("display :%d is not accepting connections", id)
+		}
+		return nil
+	}
+}
+
+// runDisplayServer starts Xvfb on a free display and waits for it to be
+// ready to accept connections.
+func runDisplayServer(sup *supervisor, width, height int) (*displayServer, error) {
+	var id int
+	var lastErr error
+	for i := 0; i < displayStartAttempts; i++ {
+		var err error
+		id, err = findFreeDisplay(id)
+		if err != nil {
+			return nil, err
+		}
+
+		args := fmt.Sprintf(`:%d -screen 0 %dx%dx24 -dpi 96 -nolisten tcp -nolisten unix`, id, width, height)
+		proc, err := sup.start(processSpec{
+			Name: "Xvfb",
+			Cmd:  "Xvfb",
+			Args: args,
+			// The browser can't survive the display going away so there's no
+			// point in restarting it.
+			Restart:          restartPolicyNever,
+			StopTimeout:      displayStopTimeout,
+			LivenessProbe:    displayLivenessProbe(id),
+			LivenessInterval: displayLivenessInterval,
+		})
+		if err != nil {
+			releaseDisplay(id)
+			return nil, fmt.Errorf("failed to start Xvfb: %w", err)
+		}
+
+		s := &displayServer{id: id, proc: proc}
+		lastErr = s.waitReady(displayReadyTimeout)
+		if lastErr == nil {
+			slog.Debug("display server ready", slog.Int("display", id))
+			return s, nil
+		}
+
+		if err := s.stop(); err != nil {
+			slog.Debug("display server exited", slog.String("err", err.Error()))
+		}
+		if !errors.Is(lastErr, errDisplayTaken) {
+			return nil, lastErr
+		}
+
+		slog.Warn("display taken, trying the next one",
+			slog.Int("display", id),
+			slog.String("err", lastErr.Error()),
+		)
+		id++
+	}
+
+	return nil, lastErr
+}
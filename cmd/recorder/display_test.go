@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func setupX11Paths(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	lockPathFmt, socketPathFmt := x11LockPathFmt, x11SocketPathFmt
+	x11LockPathFmt = filepath.Join(dir, ".X%d-lock")
+	x11SocketPathFmt = filepath.Join(dir, "X%d")
+	t.Cleanup(func() {
+		x11LockPathFmt, x11SocketPathFmt = lockPathFmt, socketPathFmt
+		reservedDisplays.mut.Lock()
+		clear(reservedDisplays.ids)
+		reservedDisplays.mut.Unlock()
+	})
+
+	return dir
+}
+
+func TestFindFreeDisplay(t *testing.T) {
+	setupX11Paths(t)
+
+	t.Run("all free", func(t *testing.T) {
+		id, err := findFreeDisplay(0)
+		require.NoError(t, err)
+		require.Equal(t, displayIDMin, id)
+		releaseDisplay(id)
+	})
+
+	t.Run("reserved", func(t *testing.T) {
+		id, err := findFreeDisplay(0)
+		require.NoError(t, err)
+		require.Equal(t, displayIDMin, id)
+
+		// Not in use yet but handed out already.
+		id2, err := findFreeDisplay(0)
+		require.NoError(t, err)
+		require.Equal(t, displayIDMin+1, id2)
+
+		releaseDisplay(id)
+		releaseDisplay(id2)
+
+		id, err = findFreeDisplay(0)
+		require.NoError(t, err)
+		require.Equal(t, displayIDMin, id)
+		releaseDisplay(id)
+	})
+
+	t.Run("lock files", func(t *testing.T) {
+		// Held by a live process.
+		err := os.WriteFile(fmt.Sprintf(x11LockPathFmt, displayIDMin), []byte(fmt.Sprintf("%10d\n", os.Getpid())), 0600)
+		require.NoError(t, err)
+		// Unparsable.
+		err = os.WriteFile(fmt.Sprintf(x11LockPathFmt, displayIDMin+1), []byte("invalid"), 0600)
+		require.NoError(t, err)
+		// Stale.
+		cmd := exec.Command("true")
+		require.NoError(t, cmd.Run())
+		err = os.WriteFile(fmt.Sprintf(x11LockPathFmt, displayIDMin+2), []byte(fmt.Sprintf("%10d\n", cmd.Process.Pid)), 0600)
+		require.NoError(t, err)
+
+		id, err := findFreeDisplay(0)
+		require.NoError(t, err)
+		require.Equal(t, displayIDMin+2, id)
+	})
+
+	t.Run("sockets", func(t *testing.T) {
+		// File system socket.
+		l1, err := net.Listen("unix", fmt.Sprintf(x11SocketPathFmt, displayIDMin+2))
+		require.NoError(t, err)
+		defer l1.Close()
+		// Abstract socket.
+		l2, err := net.Listen("unix", "@"+fmt.Sprintf(x11SocketPathFmt, displayIDMin+3))
+		require.NoError(t, err)
+		defer l2.Close()
+
+		id, err := findFreeDisplay(0)
+		require.NoError(t, err)
+		require.Equal(t, displayIDMin+4, id)
+
+		id, err = findFreeDisplay(displayIDMin + 10)
+		require.NoError(t, err)
+		require.Equal(t, displayIDMin+10, id)
+	})
+
+	t.Run("none free", func(t *testing.T) {
+		_, err := findFreeDisplay(displayIDMax + 1)
+		require.EqualError(t, err, fmt.Sprintf("no free display found in range [%d, %d]", displayIDMin, displayIDMax))
+	})
+}
+
+func TestDisplayServerWaitReady(t *testing.T) {
+	setupX11Paths(t)
+
+	sup := newSupervisor(nil)
+	defer sup.stopAll()
+
+	startDisplayServer := func(t *testing.T, id int, cmd, args string) *displayServer {
+		t.Helper()
+		proc, err := sup.start(processSpec{Name: "Xvfb", Cmd: cmd, Args: args})
+		require.NoError(t, err)
+		return &displayServer{id: id, proc: proc}
+	}
+
+	t.Run("ready", func(t *testing.T) {
+		s := startDisplayServer(t, displayIDMin, "sleep", "10")
+
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			err := os.WriteFile(fmt.Sprintf(x11LockPathFmt, displayIDMin), []byte(fmt.Sprintf("%10d\n", s.proc.pid())), 0600)
+			if err != nil {
+				return
+			}
+			l, err := net.Listen("unix", "@"+fmt.Sprintf(x11SocketPathFmt, displayIDMin))
+			if err == nil {
+				t.Cleanup(func() { l.Close() })
+			}
+		}()
+
+		require.NoError(t, s.waitReady(time.Second))
+		require.Error(t, s.stop())
+	})
+
+	t.Run("exited", func(t *testing.T) {
+		s := startDisplayServer(t, displayIDMin+1, "false", "")
+
+		err := s.waitReady(time.Second)
+		require.ErrorIs(t, err, errDisplayTaken)
+		require.EqualError(t, err, "display is taken: display server exited before becoming ready: exit status 1")
+		require.EqualError(t, s.stop(), "exit status 1")
+	})
+
+	t.Run("taken by another server", func(t *testing.T) {
+		// Another server is listening on the display while ours is failing.
+		err := os.WriteFile(fmt.Sprintf(x11LockPathFmt, displayIDMin+3), []byte(fmt.Sprintf("%10d\n", os.Getpid())), 0600)
+		require.NoError(t, err)
+		l, err := net.Listen("unix", "@"+fmt.Sprintf(x11SocketPathFmt, displayIDMin+3))
+		require.NoError(t, err)
+		defer l.Close()
+
+		s := startDisplayServer(t, displayIDMin+3, "sleep", "0.2")
+		err = s.waitReady(time.Second)
+		require.ErrorIs(t, err, errDisplayTaken)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		s := startDisplayServer(t, displayIDMin+2, "sleep", "10")
+
+		err := s.waitReady(200 * time.Millisecond)
+		require.EqualError(t, err, "timed out waiting for display server to accept connections")
+		require.EqualError(t, s.stop(), "signal: terminated")
+	})
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+const (
+	browserEventBinding    = "callsRecorderEvent"
+	browserEventsQueueSize = 256
+)
+
+//go:embed scripts/events.js
+var browserEventsScript string
+
+type BrowserEventType string
+
+const (
+	BrowserEventTypeInit       BrowserEventType = "init"
+	BrowserEventTypeConnect    BrowserEventType = "connect"
+	BrowserEventTypeClose      BrowserEventType = "close"
+	BrowserEventTypeError      BrowserEventType = "error"
+	BrowserEventTypeUserJoined BrowserEventType = "user_joined"
+	BrowserEventTypeUserLeft   BrowserEventType = "user_left"
+	BrowserEventTypeScreenOn   BrowserEventType = "screen_on"
+	BrowserEventTypeScreenOff  BrowserEventType = "screen_off"
+	BrowserEventTypeVoiceOn    BrowserEventType = "voice_on"
+	BrowserEventTypeVoiceOff   BrowserEventType = "voice_off"
+
+	// internal events, not emitted by the page
+	browserEventTypeReloadRequested BrowserEventType = "reload_requested"
+)
+
+// BrowserEvent is an event forwarded by the recording page from
+// window.callsClient.
+type BrowserEvent struct {
+	Type      BrowserEventType `json:"type"`
+	UserID    string           `json:"user_id,omitempty"`
+	SessionID string           `json:"session_id,omitempty"`
+	Error     string           `json:"error,omitempty"`
+	// Timestamp (in milliseconds) at which the event was emitted by the page.
+	Timestamp int64 `json:"ts"`
+}
+
+func (ev BrowserEvent) Time() time.Time {
+	return time.UnixMilli(ev.Timestamp)
+}
+
+func parseBrowserEvent(payload string) (BrowserEvent, error) {
+	var ev BrowserEvent
+	if err := json.Unmarshal([]byte(payload), &ev); err != nil {
+		return ev, fmt.Errorf("failed to unmarshal event: %w", err)
+	}
+	if ev.Type == "" {
+		return ev, fmt.Errorf("invalid empty event type")
+	}
+	if ev.Timestamp == 0 {
+		ev.Timestamp = time.Now().UnixMilli()
+	}
+	return ev, nil
+}
+
+// handleBindingPayload is called from the CDP event listener, so it must not
+// block.
+func (rec *Recorder) handleBindingPayload(payload string) {
+	ev, err := parseBrowserEvent(payload)
+	if err != nil {
+		slog.Error("failed to parse browser event", slog.String("err", err.Error()))
+		return
+	}
+
+	select {
+	case rec.browserEventsCh <- ev:
+	default:
+		slog.Error("browser events queue is full, dropping event", slog.String("type", string(ev.Type)))
+	}
+}
+
+// onBrowserEvent registers a handler that gets called for every event
+// received from the browser. Handlers must be registered before starting.
+func (rec *Recorder) onBrowserEvent(h func(ev BrowserEvent)) {
+	rec.browserEventHandlers = append(rec.browserEventHandlers, h)
+}
+
+func (rec *Recorder) dispatchBrowserEvent(ev BrowserEvent) {
+	slog.Debug("browser event",
+		slog.String("type", string(ev.Type)),
+		slog.String("userID", ev.UserID),
+		slog.String("sessionID", ev.SessionID),
+		slog.String("error", ev.Error),
+	)
+
+	if ev.Type == BrowserEventTypeError {
+		slog.Error("calls client error", slog.String("err", ev.Error))
+	}
+
+	for _, h := range rec.browserEventHandlers {
+		h(ev)
+	}
+}
+
+func (rec *Recorder) drainBrowserEvents() {
+	for {
+		select {
+		case <-rec.browserEventsCh:
+		default:
+			return
+		}
+	}
+}
+
+// waitForBrowserEvent blocks until an event of one of the given types is
+// received. Any event received in the meantime gets dispatched to the
+// registered handlers. A zero timeout means waiting indefinitely.
+func (rec *Recorder) waitForBrowserEvent(timeout time.Duration, types ...BrowserEventType) (BrowserEvent, error) {
+	var timeoutCh <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutCh = timer.C
+	}
+
+	for {
+		select {
+		case <-timeoutCh:
+			return BrowserEvent{}, fmt.Errorf("timed out")
+		case <-rec.stopCh:
+			return BrowserEvent{}, fmt.Errorf("stop signal received while waiting for event")
+		case ev := <-rec.browserEventsCh:
+			rec.dispatchBrowserEvent(ev)
+			for _, t := range types {
+				if ev.Type == t {
+					return ev, nil
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBrowserEvent(t *testing.T) {
+	tcs := []struct {
+		name     string
+		payload  string
+		expected BrowserEvent
+		err      string
+	}{
+		{
+			name:    "empty payload",
+			payload: "",
+			err:     "failed to unmarshal event: unexpected end of JSON input",
+		},
+		{
+			name:    "missing type",
+			payload: `{"ts": 1000}`,
+			err:     "invalid empty event type",
+		},
+		{
+			name:    "connect",
+			payload: `{"type": "connect", "ts": 1000}`,
+			expected: BrowserEvent{
+				Type:      BrowserEventTypeConnect,
+				Timestamp: 1000,
+			},
+		},
+		{
+			name:    "user joined",
+			payload: `{"type": "user_joined", "user_id": "userA", "session_id": "sessionA", "ts": 1000}`,
+			expected: BrowserEvent{
+				Type:      BrowserEventTypeUserJoined,
+				UserID:    "userA",
+				SessionID: "sessionA",
+				Timestamp: 1000,
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			ev, err := parseBrowserEvent(tc.payload)
+			if tc.err != "" {
+				require.EqualError(t, err, tc.err)
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, tc.expected, ev)
+			}
+		})
+	}
+
+	t.Run("missing timestamp", func(t *testing.T) {
+		ev, err := parseBrowserEvent(`{"type": "close"}`)
+		require.NoError(t, err)
+		require.NotZero(t, ev.Timestamp)
+	})
+}
+
+func TestBrowserEventsScript(t *testing.T) {
+	require.True(t, strings.Contains(browserEventsScript, browserEventBinding))
+}
+
+func TestWaitForBrowserEvent(t *testing.T) {
+	newRecorder := func() *Recorder {
+		return &Recorder{
+			stopCh:          make(chan struct{}),
+			browserEventsCh: make(chan BrowserEvent, browserEventsQueueSize),
+		}
+	}
+
+	t.Run("timeout", func(t *testing.T) {
+		rec := newRecorder()
+		_, err := rec.waitForBrowserEvent(10*time.Millisecond, BrowserEventTypeInit)
+		require.EqualError(t, err, "timed out")
+	})
+
+	t.Run("stop", func(t *testing.T) {
+		rec := newRecorder()
+		close(rec.stopCh)
+		_, err := rec.waitForBrowserEvent(0, BrowserEventTypeInit)
+		require.EqualError(t, err, "stop signal received while waiting for event")
+	})
+
+	t.Run("dispatches events", func(t *testing.T) {
+		rec := newRecorder()
+		var received []BrowserEventType
+		rec.onBrowserEvent(func(ev BrowserEvent) {
+			received = append(received, ev.Type)
+		})
+
+		rec.handleBindingPayload(`{"type": "init"}`)
+		rec.handleBindingPayload(`invalid`)
+		rec.handleBindingPayload(`{"type": "user_joined", "user_id": "userA"}`)
+		rec.handleBindingPayload(`{"type": "connect"}`)
+		rec.handleBindingPayload(`{"type": "user_left", "user_id": "userA"}`)
+
+		ev, err := rec.waitForBrowserEvent(time.Second, BrowserEventTypeConnect, BrowserEventTypeClose)
+		require.NoError(t, err)
+		require.Equal(t, BrowserEventTypeConnect, ev.Type)
+		require.Equal(t, []BrowserEventType{
+			BrowserEventTypeInit,
+			BrowserEventTypeUserJoined,
+			BrowserEventTypeConnect,
+		}, received)
+
+		rec.drainBrowserEvents()
+		require.Empty(t, rec.browserEventsCh)
+	})
+
+	t.Run("full queue", func(t *testing.T) {
+		rec := newRecorder()
+		for i := 0; i < browserEventsQueueSize+1; i++ {
+			rec.handleBindingPayload(`{"type": "voice_on"}`)
+		}
+		require.Len(t, rec.browserEventsCh, browserEventsQueueSize)
+	})
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/network"
+)
+
+const (
+	harFilename                = "network.har"
+	harMaxEntries              = 10000
+	harMaxWebSocketMessages    = 5000
+	harRedactedValue           = "XXX"
+	harWebSocketOpcodeText     = 1
+	harWebSocketMessageSend    = "send"
+	harWebSocketMessageReceive = "receive"
+)
+
+var (
+	harSensitiveHeaders = map[string]bool{
+		"authorization":       true,
+		"proxy-authorization": true,
+		"cookie":              true,
+		"set-cookie":          true,
+		"x-csrf-token":        true,
+	}
+	harSensitiveParams = map[string]bool{
+		"token":        true,
+		"access_token": true,
+		"auth_token":   true,
+		"password":     true,
+	}
+)
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	Cookies     []harNameValue `json:"cookies"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+}
+
+type harResponse struct {
+	Status      int64          `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Headers     []harNameValue `json:"headers"`
+	Cookies     []harNameValue `json:"cookies"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+	Error       string         `json:"_error,omitempty"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+type harWebSocketMessage struct {
+	Type   string  `json:"type"`
+	Time   float64 `json:"time"`
+	Opcode float64 `json:"opcode"`
+	Data   string  `json:"data"`
+}
+
+type harEntry struct {
+	StartedDateTime   string                `json:"startedDateTime"`
+	Time              float64               `json:"time"`
+	Request           harRequest            `json:"request"`
+	Response          harResponse           `json:"response"`
+	Cache             struct{}              `json:"cache"`
+	Timings           harTimings            `json:"timings"`
+	ResourceType      string                `json:"_resourceType,omitempty"`
+	WebSocketMessages []harWebSocketMessage `json:"_webSocketMessages,omitempty"`
+	DroppedMessages   int                   `json:"_droppedWebSocketMessages,omitempty"`
+
+	startedAt  time.Time
+	finishedAt time.Time
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harLog struct {
+	Version        string      `json:"version"`
+	Creator        harCreator  `json:"creator"`
+	Entries        []*harEntry `json:"entries"`
+	DroppedEntries int         `json:"_droppedEntries,omitempty"`
+}
+
+type harFile struct {
+	Log harLog `json:"log"`
+}
+
+// harCapture records the network activity of the browser (including
+// WebSocket frames) so that it can be exported as a HAR file. Sensitive
+// headers, parameters and payload fields are redacted as they are captured.
+type harCapture struct {
+	mut            sync.Mutex
+	entries        map[network.RequestID]*harEntry
+	order          []network.RequestID
+	droppedEntries int
+}
+
+func newHARCapture() *harCapture {
+	return &harCapture{
+		entries: map[network.RequestID]*harEntry{},
+	}
+}
+
+func harRedactURL(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return u
+	}
+
+	if parsed.User != nil {
+		parsed.User = url.User(harRedactedValue)
+	}
+
+	// The fragment can carry the authentication data.
+	if parsed.Fragment != "" {
+		parsed.Fragment = harRedactedValue
+	}
+
+	if parsed.RawQuery != "" {
+		query := parsed.Query()
+		for key := range query {
+			if harSensitiveParams[strings.ToLower(key)] {
+				query.Set(key, harRedactedValue)
+			}
+		}
+		parsed.RawQuery = query.Encode()
+	}
+
+	return parsed.String()
+}
+
+func harRedactPayload(data string) string {
+	return redactSecrets(data)
+}
+
+func harHeaders(headers network.Headers) []harNameValue {
+	values := make([]harNameValue, 0, len(headers))
+	for name, val := range headers {
+		value := fmt.Sprintf("%v", val)
+		if harSensitiveHeaders[strings.ToLower(name)] {
+			value = harRedactedValue
+		}
+		values = append(values, harNameValue{Name: name, Value: value})
+	}
+	sort.Slice(values, func(i, j int) bool {
+		return values[i].Name < values[j].Name
+	})
+	return values
+}
+
+func harQueryString(u string) []harNameValue {
+	values := []harNameValue{}
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return values
+	}
+	for key, vals := range parsed.Query() {
+		for _, val := range vals {
+			values = append(values, harNameValue{Name: key, Value: val})
+		}
+	}
+	sort.Slice(values, func(i, j int) bool {
+		return values[i].Name < values[j].Name
+	})
+	return values
+}
+
+func harMillis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// addEntry must be called with the lock held.
+func (h *harCapture) addEntry(id network.RequestID, entry *harEntry) {
+	if _, ok := h.entries[id]; !ok {
+		if len(h.order) >= harMaxEntries {
+			h.droppedEntries++
+			return
+		}
+		h.order = append(h.order, id)
+	}
+	h.entries[id] = entry
+}
+
+func newHAREntry(method, u string, headers network.Headers, resourceType string, now time.Time) *harEntry {
+	redactedURL := harRedactURL(u)
+	return &harEntry{
+		StartedDateTime: now.UTC().Format(time.RFC3339Nano),
+		Request: harRequest{
+			Method:      method,
+			URL:         redactedURL,
+			HTTPVersion: "HTTP/1.1",
+			Headers:     harHeaders(headers),
+			QueryString: harQueryString(redactedURL),
+			Cookies:     []harNameValue{},
+			HeadersSize: -1,
+			BodySize:    -1,
+		},
+		Response: harResponse{
+			HTTPVersion: "HTTP/1.1",
+			Headers:     []harNameValue{},
+			Cookies:     []harNameValue{},
+			HeadersSize: -1,
+			BodySize:    -1,
+		},
+		ResourceType: resourceType,
+		startedAt:    now,
+	}
+}
+
+func (h *harCapture) addWebSocketMessage(id network.RequestID, msgType string, frame *network.WebSocketFrame, now time.Time) {
+	entry := h.entries[id]
+	if entry == nil || frame == nil {
+		return
+	}
+
+	if len(entry.WebSocketMessages) >= harMaxWebSocketMessages {
+		entry.DroppedMessages++
+		return
+	}
+
+	data := frame.PayloadData
+	if frame.Opcode == harWebSocketOpcodeText {
+		data = harRedactPayload(data)
+	}
+
+	entry.WebSocketMessages = append(entry.WebSocketMessages, harWebSocketMessage{
+		Type:   msgType,
+		Time:   float64(now.UnixNano()) / float64(time.Second),
+		Opcode: frame.Opcode,
+		Data:   data,
+	})
+}
+
+// handleEvent is meant to be called from the CDP event listener.
+func (h *harCapture) handleEvent(ev interface{}) {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+
+	now := time.Now()
+
+	switch ev := ev.(type) {
+	case *network.EventRequestWillBeSent:
+		if ev.Request == nil {
+			return
+		}
+		if ev.RedirectResponse != nil {
+			// The same request ID is reused on redirects so we finalize the
+			// previous entry under a different key.
+			if entry := h.entries[ev.RequestID]; entry != nil {
+				h.setResponse(entry, ev.RedirectResponse)
+				entry.Response.RedirectURL = harRedactURL(ev.Request.URL)
+				entry.finishedAt = now
+				redirectID := network.RequestID(fmt.Sprintf("%s-redirect-%d", ev.RequestID, len(h.order)))
+				delete(h.entries, ev.RequestID)
+				for i, id := range h.order {
+					if id == ev.RequestID {
+						h.order[i] = redirectID
+					}
+				}
+				h.entries[redirectID] = entry
+			}
+		}
+		h.addEntry(ev.RequestID, newHAREntry(ev.Request.Method, ev.Request.URL, ev.Request.Headers, ev.Type.String(), now))
+	case *network.EventResponseReceived:
+		if entry := h.entries[ev.RequestID]; entry != nil && ev.Response != nil {
+			h.setResponse(entry, ev.Response)
+		}
+	case *network.EventLoadingFinished:
+		if entry := h.entries[ev.RequestID]; entry != nil {
+			entry.Response.BodySize = int64(ev.EncodedDataLength)
+			entry.finishedAt = now
+		}
+	case *network.EventLoadingFailed:
+		if entry := h.entries[ev.RequestID]; entry != nil {
+			entry.Response.Error = ev.ErrorText
+			entry.finishedAt = now
+		}
+	case *network.EventWebSocketCreated:
+		h.addEntry(ev.RequestID, newHAREntry("GET", ev.URL, nil, "websocket", now))
+	case *network.EventWebSocketWillSendHandshakeRequest:
+		if entry := h.entries[ev.RequestID]; entry != nil && ev.Request != nil {
+			entry.Request.Headers = harHeaders(ev.Request.Headers)
+		}
+	case *network.EventWebSocketHandshakeResponseReceived:
+		if entry := h.entries[ev.RequestID]; entry != nil && ev.Response != nil {
+			entry.Response.Status = ev.Response.Status
+			entry.Response.StatusText = ev.Response.StatusText
+			entry.Response.Headers = harHeaders(ev.Response.Headers)
+		}
+	case *network.EventWebSocketFrameSent:
+		h.addWebSocketMessage(ev.RequestID, harWebSocketMessageSend, ev.Response, now)
+	case *network.EventWebSocketFrameReceived:
+		h.addWebSocketMessage(ev.RequestID, harWebSocketMessageReceive, ev.Response, now)
+	case *network.EventWebSocketFrameError:
+		if entry := h.entries[ev.RequestID]; entry != nil {
+			entry.Response.Error = ev.ErrorMessage
+		}
+	case *network.EventWebSocketClosed:
+		if entry := h.entries[ev.RequestID]; entry != nil {
+			entry.finishedAt = now
+		}
+	}
+}
+
+// setResponse must be called with the lock held.
+func (h *harCapture) setResponse(entry *harEntry, res *network.Response) {
+	entry.Response.Status = res.Status
+	entry.Response.StatusText = res.StatusText
+	entry.Response.Headers = harHeaders(res.Headers)
+	entry.Response.Content = harContent{
+		Size:     int64(res.EncodedDataLength),
+		MimeType: res.MimeType,
+	}
+	if res.Protocol != "" {
+		entry.Response.HTTPVersion = strings.ToUpper(res.Protocol)
+		entry.Request.HTTPVersion = entry.Response.HTTPVersion
+	}
+	if len(res.RequestHeaders) > 0 {
+		entry.Request.Headers = harHeaders(res.RequestHeaders)
+	}
+}
+
+func (h *harCapture) export() harFile {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+
+	log := harLog{
+		Version: "1.2",
+		Creator: harCreator{
+			Name:    "calls-recorder",
+			Version: "1.0",
+		},
+		Entries:        make([]*harEntry, 0, len(h.order)),
+		DroppedEntries: h.droppedEntries,
+	}
+
+	for _, id := range h.order {
+		entry := *h.entries[id]
+		if !entry.finishedAt.IsZero() {
+			entry.Time = harMillis(entry.finishedAt.Sub(entry.startedAt))
+			entry.Timings.Receive = entry.Time
+		}
+		log.Entries = append(log.Entries, &entry)
+	}
+
+	return harFile{Log: log}
+}
+
+func (h *harCapture) writeFile(path string) error {
+	data, err := json.Marshal(h.export())
+	if err != nil {
+		return fmt.Errorf("failed to marshal HAR: %w", err)
+	}
+
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write HAR file: %w", err)
+	}
+
+	return nil
+}
+
+// saveNetworkCapture writes the captured network activity, if enabled, into
+// the job's data directory and returns the path of the file.
+func (rec *Recorder) saveNetworkCapture() (string, error) {
+	if rec.networkCapture == nil {
+		return "", nil
+	}
+
+	path := filepath.Join(rec.dataPath, harFilename)
+	if err := rec.networkCapture.writeFile(path); err != nil {
+		return "", err
+	}
+
+	slog.Info("network capture saved", slog.String("path", path))
+
+	return path, nil
+}
+
+func isNetworkCaptureEnabled() bool {
+	return os.Getenv("NETWORK_CAPTURE") == "true"
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/chromedp"
+)
+
+const (
+	idleCheckInterval = 10 * time.Second
+	// Time to wait before ending the recording once everybody has left, so
+	// that participants briefly reconnecting don't cause it to end.
+	idleEmptyCallGracePeriod = time.Minute
+	idleScreenshotQuality    = 50
+	// The screen is compared over a coarse grid of cells, which makes the
+	// comparison cheap and tolerant to encoding noise.
+	idleScreenGridWidth  = 64
+	idleScreenGridHeight = 36
+	// A cell is considered changed when its mean luminance moves by more
+	// than this (0-255).
+	idleScreenCellThreshold = 8.0
+	// The screen is considered changed when more than this fraction of cells
+	// changed. Small updates like the call timer or a moving cursor stay
+	// below it.
+	idleScreenChangedRatio = 0.02
+)
+
+// idleMonitor keeps track of activity in the call so that the recording can
+// end on its own when it's been forgotten: either everybody left or nobody
+// has spoken and the screen hasn't changed for a while.
+type idleMonitor struct {
+	timeout time.Duration
+	// returns the number of participants in the call, excluding the bot
+	participants func() int
+	// returns when audio was last captured
+	lastSoundAt func() time.Time
+
+	mut            sync.Mutex
+	lastActivityAt time.Time
+	emptySince     time.Time
+	screenGrid     []float64
+	// set when the participants count can't be trusted, in which case only
+	// audio and screen activity are considered
+	emptyCallDisabled bool
+}
+
+func newIdleMonitor(timeout time.Duration, participants func() int, lastSoundAt func() time.Time) *idleMonitor {
+	return &idleMonitor{
+		timeout:        timeout,
+		participants:   participants,
+		lastSoundAt:    lastSoundAt,
+		lastActivityAt: time.Now(),
+	}
+}
+
+// disableEmptyCallCheck stops the call from being considered idle because
+// it has no participants left.
+func (m *idleMonitor) disableEmptyCallCheck() {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	m.emptyCallDisabled = true
+	m.emptySince = time.Time{}
+}
+
+// touch records activity happening at the given time.
+func (m *idleMonitor) touch(at time.Time) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	if at.After(m.lastActivityAt) {
+		m.lastActivityAt = at
+	}
+}
+
+func (m *idleMonitor) handleEvent(ev BrowserEvent) {
+	switch ev.Type {
+	case BrowserEventTypeUserJoined, BrowserEventTypeVoiceOn, BrowserEventTypeScreenOn, BrowserEventTypeScreenOff:
+		m.touch(ev.Time())
+	}
+}
+
+// updateScreen compares the given screen grid with the previous one,
+// recording activity if it changed.
+func (m *idleMonitor) updateScreen(grid []float64, at time.Time) {
+	m.mut.Lock()
+	prev := m.screenGrid
+	m.screenGrid = grid
+	m.mut.Unlock()
+
+	if prev != nil && screenGridChanged(prev, grid) {
+		m.touch(at)
+	}
+}
+
+// check returns whether the call is idle as of now, and why.
+func (m *idleMonitor) check(now time.Time) (string, bool) {
+	participants := m.participants()
+	lastSoundAt := m.lastSoundAt()
+
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	switch {
+	case m.emptyCallDisabled:
+		// Only audio and screen activity count.
+	case participants == 0:
+		if m.emptySince.IsZero() {
+			m.emptySince = now
+		}
+		if now.Sub(m.emptySince) >= idleEmptyCallGracePeriod {
+			return "no participants left in the call", true
+		}
+	default:
+		m.emptySince = time.Time{}
+	}
+
+	if lastSoundAt.After(m.lastActivityAt) {
+		m.lastActivityAt = lastSoundAt
+	}
+
+	if d := now.Sub(m.lastActivityAt); d >= m.timeout {
+		return fmt.Sprintf("no activity in the call for %s", d.Round(time.Second)), true
+	}
+
+	return "", false
+}
+
+// run periodically checks whether the call is idle until stopCh gets closed.
+// onIdle is called at most once.
+func (m *idleMonitor) run(ctx context.Context, stopCh <-chan struct{}, onIdle func(reason string)) {
+	ticker := time.NewTicker(idleCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		if grid, err := captureScreenGrid(ctx); err != nil {
+			slog.Warn("failed to capture screen", slog.String("err", err.Error()))
+		} else {
+			m.updateScreen(grid, now)
+		}
+
+		if reason, idle := m.check(now); idle {
+			onIdle(reason)
+			return
+		}
+	}
+}
+
+// computeScreenGrid returns the mean luminance of each cell of the grid the
+// image gets divided into.
+func computeScreenGrid(img image.Image) []float64 {
+	bounds := img.Bounds()
+	grid := make([]float64, idleScreenGridWidth*idleScreenGridHeight)
+	if bounds.Empty() {
+		return grid
+	}
+
+	counts := make([]int, len(grid))
+	// Sampling every other pixel is plenty for this purpose.
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += 2 {
+		cy := (y - bounds.Min.Y) * idleScreenGridHeight / bounds.Dy()
+		for x := bounds.Min.X; x < bounds.Max.X; x += 2 {
+			cx := (x - bounds.Min.X) * idleScreenGridWidth / bounds.Dx()
+			r, g, b, _ := img.At(x, y).RGBA()
+			idx := cy*idleScreenGridWidth + cx
+			grid[idx] += (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)) / 257
+			counts[idx]++
+		}
+	}
+
+	for i := range grid {
+		if counts[i] > 0 {
+			grid[i] /= float64(counts[i])
+		}
+	}
+
+	return grid
+}
+
+func screenGridChanged(prev, curr []float64) bool {
+	if len(prev) != len(curr) {
+		return true
+	}
+
+	var changed int
+	for i := range curr {
+		if math.Abs(curr[i]-prev[i]) > idleScreenCellThreshold {
+			changed++
+		}
+	}
+
+	return float64(changed)/float64(len(curr)) > idleScreenChangedRatio
+}
+
+func captureScreenGrid(ctx context.Context) ([]float64, error) {
+	tctx, cancel := context.WithTimeout(ctx, screenshotTimeout)
+	defer cancel()
+
+	var data []byte
+	if err := chromedp.Run(tctx, chromedp.ActionFunc(func(ctx context.Context) error {
+		var err error
+		data, err = page.CaptureScreenshot().
+			WithFormat(page.CaptureScreenshotFormatJpeg).
+			WithQuality(idleScreenshotQuality).
+			Do(ctx)
+		return err
+	})); err != nil {
+		return nil, fmt.Errorf("failed to capture screenshot: %w", err)
+	}
+
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode screenshot: %w", err)
+	}
+
+	return computeScreenGrid(img), nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdleMonitorCheck(t *testing.T) {
+	newMonitor := func(participants *int, lastSoundAt *time.Time) *idleMonitor {
+		m := newIdleMonitor(5*time.Minute, func() int {
+			return *participants
+		}, func() time.Time {
+			return *lastSoundAt
+		})
+		m.lastActivityAt = time.UnixMilli(0)
+		return m
+	}
+
+	t.Run("active", func(t *testing.T) {
+		participants := 2
+		var lastSoundAt time.Time
+		m := newMonitor(&participants, &lastSoundAt)
+
+		_, idle := m.check(time.UnixMilli(0).Add(4 * time.Minute))
+		require.False(t, idle)
+
+		// Somebody speaking resets the timer.
+		lastSoundAt = time.UnixMilli(0).Add(4 * time.Minute)
+		_, idle = m.check(time.UnixMilli(0).Add(8 * time.Minute))
+		require.False(t, idle)
+
+		// And so do call events.
+		m.handleEvent(BrowserEvent{Type: BrowserEventTypeScreenOn, Timestamp: (8 * time.Minute).Milliseconds()})
+		_, idle = m.check(time.UnixMilli(0).Add(12 * time.Minute))
+		require.False(t, idle)
+
+		// Muting isn't activity.
+		m.handleEvent(BrowserEvent{Type: BrowserEventTypeVoiceOff, Timestamp: (12 * time.Minute).Milliseconds()})
+		reason, idle := m.check(time.UnixMilli(0).Add(13 * time.Minute))
+		require.True(t, idle)
+		require.Equal(t, "no activity in the call for 5m0s", reason)
+	})
+
+	t.Run("empty call", func(t *testing.T) {
+		participants := 0
+		var lastSoundAt time.Time
+		m := newMonitor(&participants, &lastSoundAt)
+
+		_, idle := m.check(time.UnixMilli(0).Add(time.Second))
+		require.False(t, idle)
+
+		// Participants coming back within the grace period.
+		participants = 1
+		_, idle = m.check(time.UnixMilli(0).Add(30 * time.Second))
+		require.False(t, idle)
+
+		participants = 0
+		_, idle = m.check(time.UnixMilli(0).Add(40 * time.Second))
+		require.False(t, idle)
+
+		reason, idle := m.check(time.UnixMilli(0).Add(40*time.Second + idleEmptyCallGracePeriod))
+		require.True(t, idle)
+		require.Equal(t, "no participants left in the call", reason)
+	})
+
+	t.Run("empty call check disabled", func(t *testing.T) {
+		participants := 0
+		var lastSoundAt time.Time
+		m := newMonitor(&participants, &lastSoundAt)
+		m.disableEmptyCallCheck()
+
+		// The call is never considered empty.
+		lastSoundAt = time.UnixMilli(0).Add(2 * time.Minute)
+		_, idle := m.check(time.UnixMilli(0).Add(time.Second))
+		require.False(t, idle)
+		_, idle = m.check(time.UnixMilli(0).Add(time.Second + idleEmptyCallGracePeriod))
+		require.False(t, idle)
+
+		// Inactivity is still detected.
+		reason, idle := m.check(time.UnixMilli(0).Add(7 * time.Minute))
+		require.True(t, idle)
+		require.Equal(t, "no activity in the call for 5m0s", reason)
+	})
+
+	t.Run("screen changes", func(t *testing.T) {
+		participants := 1
+		var lastSoundAt time.Time
+		m := newMonitor(&participants, &lastSoundAt)
+
+		black := computeScreenGrid(newTestScreen(0, 0))
+		white := computeScreenGrid(newTestScreen(255, 0))
+
+		m.updateScreen(black, time.UnixMilli(0).Add(time.Minute))
+		m.updateScreen(black, time.UnixMilli(0).Add(2*time.Minute))
+		_, idle := m.check(time.UnixMilli(0).Add(5 * time.Minute))
+		require.True(t, idle)
+
+		m.updateScreen(white, time.UnixMilli(0).Add(6*time.Minute))
+		_, idle = m.check(time.UnixMilli(0).Add(10 * time.Minute))
+		require.False(t, idle)
+	})
+}
+
+// newTestScreen returns a 1280x720 image of the given gray level, with a
+// square of the given size drawn in the corner.
+func newTestScreen(level uint8, square int) image.Image {
+	img := image.NewGray(image.Rect(0, 0, 1280, 720))
+	for y := 0; y < 720; y++ {
+		for x := 0; x < 1280; x++ {
+			c := level
+			if x < square && y < square {
+				c = 255 - level
+			}
+			img.SetGray(x, y, color.Gray{Y: c})
+		}
+	}
+	return img
+}
+
+func TestScreenGridChanged(t *testing.T) {
+	empty := computeScreenGrid(newTestScreen(0, 0))
+	require.Len(t, empty, idleScreenGridWidth*idleScreenGridHeight)
+	require.False(t, screenGridChanged(empty, computeScreenGrid(newTestScreen(0, 0))))
+
+	// Small updates (e.g. a timer) are ignored.
+	require.False(t, screenGridChanged(empty, computeScreenGrid(newTestScreen(0, 60))))
+
+	// Larger ones are not.
+	require.True(t, screenGridChanged(empty, computeScreenGrid(newTestScreen(0, 300))))
+	require.True(t, screenGridChanged(empty, computeScreenGrid(newTestScreen(128, 0))))
+
+	require.True(t, screenGridChanged(nil, empty))
+}
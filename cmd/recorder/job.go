@@ -9,6 +9,8 @@ import (
 	"time"
 
 	"github.com/mattermost/mattermost-plugin-calls/server/public"
+
+	"github.com/mattermost/mattermost/server/public/model"
 )
 
 const (
@@ -45,6 +47,19 @@ func (rec *Recorder) postJobStatus(status public.JobStatus) error {
 }
 
 func (rec *Recorder) ReportJobFailure(errMsg string) error {
+	if rec.screenshotter != nil {
+		if err := rec.screenshotter.capture(rec.getBrowserContext(), "failure"); err != nil {
+			slog.Error("failed to take failure screenshot", slog.String("err", err.Error()))
+		}
+	}
+
+	// Saving the network activity up to this point for diagnosing the
+	// failure. It holds raw traffic so it's kept in the job's data directory,
+	// which only operators have access to, rather than posted to the channel.
+	if _, err := rec.saveNetworkCapture(); err != nil {
+		slog.Error("failed to save network capture", slog.String("err", err.Error()))
+	}
+
 	return rec.postJobStatus(public.JobStatus{
 		JobType: public.JobTypeRecording,
 		Status:  public.JobStatusTypeFailed,
@@ -52,6 +67,32 @@ func (rec *Recorder) ReportJobFailure(errMsg string) error {
 	})
 }
 
+// ReportJobTruncated lets the call participants know the recording was
+// stopped before the call ended because it reached one of its limits. This
+// isn't a failure as what was recorded still gets published, so it's
+// reported the same way as warnings rather than through the job status.
+func (rec *Recorder) ReportJobTruncated(msg string) error {
+	return rec.ReportJobWarning(msg)
+}
+
+// ReportJobWarning lets the call participants know about an issue that
+// doesn't stop the recording. The plugin has no job status for warnings so
+// the message is posted as a reply to the call thread instead.
+func (rec *Recorder) ReportJobWarning(msg string) error {
+	ctx, cancelCtx := context.WithTimeout(context.Background(), httpRequestTimeout)
+	defer cancelCtx()
+
+	if _, _, err := rec.client.CreatePost(ctx, &model.Post{
+		ChannelId: rec.cfg.CallID,
+		RootId:    rec.cfg.PostID,
+		Message:   msg,
+	}); err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+
+	return nil
+}
+
 func (rec *Recorder) ReportJobStarted() error {
 	return rec.postJobStatus(public.JobStatus{
 		JobType: public.JobTypeRecording,
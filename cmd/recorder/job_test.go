@@ -5,11 +5,16 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/mattermost/calls-recorder/cmd/recorder/config"
 	"github.com/mattermost/mattermost-plugin-calls/server/public"
 
+	"github.com/mattermost/mattermost/server/public/model"
+
 	"github.com/stretchr/testify/require"
 )
 
@@ -91,4 +96,120 @@ func TestReportJobFailure(t *testing.T) {
 		require.Nil(t, err)
 		require.Equal(t, "some error", errMsg)
 	})
+
+	t.Run("network capture", func(t *testing.T) {
+		rec.dataPath = t.TempDir()
+		rec.networkCapture = newHARCapture()
+		defer func() { rec.networkCapture = nil }()
+
+		var uploads int
+		var errMsg string
+		middlewares = []middleware{
+			func(w http.ResponseWriter, r *http.Request) bool {
+				if strings.HasPrefix(r.URL.Path, "/plugins/com.mattermost.calls/bot/uploads") {
+					uploads++
+					w.WriteHeader(400)
+					return true
+				}
+
+				return false
+			},
+			func(w http.ResponseWriter, r *http.Request) bool {
+				if r.URL.Path == "/plugins/com.mattermost.calls/bot/calls/8w8jorhr7j83uqr6y1st894hqe/jobs/67t5u6cmtfbb7jug739d43xa9e/status" {
+					var status public.JobStatus
+					_ = json.NewDecoder(r.Body).Decode(&status)
+					errMsg = status.Error
+					w.WriteHeader(200)
+					return true
+				}
+
+				return false
+			},
+		}
+
+		err := rec.ReportJobFailure("some error")
+		require.NoError(t, err)
+		require.Equal(t, "some error", errMsg)
+
+		// The capture stays in the data directory, out of the channel.
+		require.Zero(t, uploads)
+		require.FileExists(t, filepath.Join(rec.dataPath, harFilename))
+	})
+}
+
+func TestReportJobTruncated(t *testing.T) {
+	var statuses int
+	var post model.Post
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/plugins/com.mattermost.calls/bot/calls/8w8jorhr7j83uqr6y1st894hqe/jobs/67t5u6cmtfbb7jug739d43xa9e/status":
+			statuses++
+			w.WriteHeader(200)
+		case "/api/v4/posts":
+			if err := json.NewDecoder(r.Body).Decode(&post); err != nil {
+				w.WriteHeader(400)
+				fmt.Fprintf(w, `{"message": %q}`, err.Error())
+				return
+			}
+			w.WriteHeader(201)
+			_ = json.NewEncoder(w).Encode(&post)
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer ts.Close()
+
+	cfg := config.RecorderConfig{
+		SiteURL:     ts.URL,
+		CallID:      "8w8jorhr7j83uqr6y1st894hqe",
+		PostID:      "udzdsg7dwidbzcidx5khrf8nee",
+		RecordingID: "67t5u6cmtfbb7jug739d43xa9e",
+		AuthToken:   "qj75unbsef83ik9p7ueypb6iyw",
+	}
+	cfg.SetDefaults()
+	rec, err := NewRecorder(cfg, getDataDir(""))
+	require.NoError(t, err)
+
+	msg := fmt.Sprintf(limitsTruncatedTemplate, "maximum duration of 1h0m0s reached")
+	require.NoError(t, rec.ReportJobTruncated(msg))
+
+	// The job doesn't fail, participants get told through the call thread.
+	require.Zero(t, statuses)
+	require.Equal(t, "udzdsg7dwidbzcidx5khrf8nee", post.RootId)
+	require.Equal(t, "recording truncated by policy: maximum duration of 1h0m0s reached", post.Message)
+}
+
+func TestReportJobWarning(t *testing.T) {
+	var post model.Post
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v4/posts" || r.Method != http.MethodPost {
+			w.WriteHeader(404)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&post); err != nil {
+			w.WriteHeader(400)
+			fmt.Fprintf(w, `{"message": %q}`, err.Error())
+			return
+		}
+		w.WriteHeader(201)
+		_ = json.NewEncoder(w).Encode(&post)
+	}))
+	defer ts.Close()
+
+	cfg := config.RecorderConfig{
+		SiteURL:     ts.URL,
+		CallID:      "8w8jorhr7j83uqr6y1st894hqe",
+		PostID:      "udzdsg7dwidbzcidx5khrf8nee",
+		RecordingID: "67t5u6cmtfbb7jug739d43xa9e",
+		AuthToken:   "qj75unbsef83ik9p7ueypb6iyw",
+	}
+	cfg.SetDefaults()
+	rec, err := NewRecorder(cfg, getDataDir(""))
+	require.NoError(t, err)
+
+	msg := fmt.Sprintf(audioSilenceWarningTemplate, time.Minute)
+	require.NoError(t, rec.ReportJobWarning(msg))
+	require.Equal(t, "8w8jorhr7j83uqr6y1st894hqe", post.ChannelId)
+	require.Equal(t, "udzdsg7dwidbzcidx5khrf8nee", post.RootId)
+	require.Equal(t, "no audio has been captured for 1m0s while participants are unmuted", post.Message)
 }
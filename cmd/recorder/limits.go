@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattermost/calls-recorder/cmd/recorder/config"
+)
+
+const (
+	limitsWarningLeadTimeDefault = 5 * time.Minute
+	limitsWarningTemplate        = "recording will be stopped in about %s as its %s will be reached"
+	limitsTruncatedTemplate      = "recording truncated by policy: %s"
+)
+
+// getLimitsWarningLeadTime returns how long before reaching a limit a
+// warning should be issued. A zero lead time means warnings are disabled.
+func getLimitsWarningLeadTime() (time.Duration, error) {
+	val := os.Getenv("LIMITS_WARNING_LEAD_TIME")
+	if val == "" {
+		return limitsWarningLeadTimeDefault, nil
+	}
+
+	leadTime, err := time.ParseDuration(val)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse LIMITS_WARNING_LEAD_TIME: %w", err)
+	} else if leadTime < 0 {
+		return 0, fmt.Errorf("LIMITS_WARNING_LEAD_TIME should not be negative")
+	}
+
+	return leadTime, nil
+}
+
+// transcoderProgress holds the stats periodically reported by the transcoder.
+type transcoderProgress struct {
+	// OutTime is the duration of the output written so far.
+	OutTime time.Duration
+	// TotalSize is the size, in bytes, of the output written so far.
+	TotalSize int64
+}
+
+// transcoderProgressParser parses the key=value lines written by ffmpeg
+// (-progress). Each block of stats is terminated by a progress key.
+type transcoderProgressParser struct {
+	buf  []byte
+	curr transcoderProgress
+}
+
+// write consumes the given data, returning the stats blocks completed by
+// it. Data doesn't need to be aligned to lines.
+func (p *transcoderProgressParser) write(data []byte) []transcoderProgress {
+	p.buf = append(p.buf, data...)
+
+	var out []transcoderProgress
+	for {
+		idx := bytes.IndexByte(p.buf, '\n')
+		if idx < 0 {
+			break
+		}
+		line := strings.TrimSpace(string(p.buf[:idx]))
+		p.buf = p.buf[idx+1:]
+
+		key, val, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+
+		// Values can be N/A until the first output is written.
+		switch key {
+		case "out_time_us":
+			if us, err := strconv.ParseInt(val, 10, 64); err == nil {
+				p.curr.OutTime = time.Duration(us) * time.Microsecond
+			}
+		case "total_size":
+			if size, err := strconv.ParseInt(val, 10, 64); err == nil {
+				p.curr.TotalSize = size
+			}
+		case "progress":
+			out = append(out, p.curr)
+		}
+	}
+
+	return out
+}
+
+// recordingLimits enforces the maximum duration and size of a recording
+// based on the transcoder's progress.
+type recordingLimits struct {
+	maxDuration time.Duration
+	maxFileSize int64
+	leadTime    time.Duration
+	// called once when getting within leadTime of a limit.
+	onWarning func(msg string)
+	// called once when a limit is reached.
+	onReached func(reason string)
+
+	warned  bool
+	reached bool
+}
+
+func newRecordingLimits(cfg config.RecorderConfig, leadTime time.Duration, onWarning, onReached func(string)) *recordingLimits {
+	return &recordingLimits{
+		maxDuration: time.Duration(cfg.MaxDuration) * time.Second,
+		maxFileSize: int64(cfg.MaxFileSize) * 1024 * 1024,
+		leadTime:    leadTime,
+		onWarning:   onWarning,
+		onReached:   onReached,
+	}
+}
+
+// update checks the given progress against the limits. It's not safe for
+// concurrent use.
+func (l *recordingLimits) update(p transcoderProgress) {
+	if l.reached {
+		return
+	}
+
+	if l.maxDuration > 0 && p.OutTime >= l.maxDuration {
+		l.reached = true
+		l.onReached(fmt.Sprintf("maximum duration of %s reached", l.maxDuration))
+		return
+	}
+
+	if l.maxFileSize > 0 && p.TotalSize >= l.maxFileSize {
+		l.reached = true
+		l.onReached(fmt.Sprintf("maximum file size of %dMB reached", l.maxFileSize/1024/1024))
+		return
+	}
+
+	if l.warned || l.leadTime <= 0 {
+		return
+	}
+
+	remaining := time.Duration(-1)
+	var limit string
+	if l.maxDuration > 0 {
+		remaining = l.maxDuration - p.OutTime
+		limit = "maximum duration"
+	}
+	if l.maxFileSize > 0 && p.TotalSize > 0 && p.OutTime > 0 {
+		// Estimating at the average rate so far.
+		d := time.Duration(float64(l.maxFileSize-p.TotalSize) / float64(p.TotalSize) * float64(p.OutTime))
+		if remaining < 0 || d < remaining {
+			remaining = d
+			limit = "maximum file size"
+		}
+	}
+
+	if remaining >= 0 && remaining <= l.leadTime {
+		l.warned = true
+		l.onWarning(fmt.Sprintf(limitsWarningTemplate, remaining.Round(time.Second), limit))
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mattermost/calls-recorder/cmd/recorder/config"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetLimitsWarningLeadTime(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		t.Setenv("LIMITS_WARNING_LEAD_TIME", "")
+		leadTime, err := getLimitsWarningLeadTime()
+		require.NoError(t, err)
+		require.Equal(t, limitsWarningLeadTimeDefault, leadTime)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		t.Setenv("LIMITS_WARNING_LEAD_TIME", "0")
+		leadTime, err := getLimitsWarningLeadTime()
+		require.NoError(t, err)
+		require.Zero(t, leadTime)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		t.Setenv("LIMITS_WARNING_LEAD_TIME", "-1m")
+		_, err := getLimitsWarningLeadTime()
+		require.EqualError(t, err, "LIMITS_WARNING_LEAD_TIME should not be negative")
+
+		t.Setenv("LIMITS_WARNING_LEAD_TIME", "soon")
+		_, err = getLimitsWarningLeadTime()
+		require.Error(t, err)
+	})
+}
+
+func TestTranscoderProgressParser(t *testing.T) {
+	var p transcoderProgressParser
+
+	require.Equal(t, []transcoderProgress{{}}, p.write([]byte("frame=0\nout_time_us=N/A\ntotal_size=N/A\nprogress=continue\n")))
+
+	// Blocks can be split across reads.
+	require.Empty(t, p.write([]byte("frame=30\nout_time_us=1000")))
+	require.Equal(t, []transcoderProgress{
+		{OutTime: time.Second, TotalSize: 48},
+	}, p.write([]byte("000\ntotal_size=48\nprogress=continue\nout_time_us=2000000\n")))
+
+	require.Equal(t, []transcoderProgress{
+		{OutTime: 2 * time.Second, TotalSize: 1024},
+		{OutTime: 3 * time.Second, TotalSize: 2048},
+	}, p.write([]byte("total_size=1024\nprogress=continue\nout_time_us=3000000\ntotal_size=2048\nprogress=end\n")))
+}
+
+func TestRecordingLimits(t *testing.T) {
+	newLimits := func(maxDuration, maxFileSize int) (*recordingLimits, *[]string, *[]string) {
+		var warnings, reasons []string
+		l := newRecordingLimits(config.RecorderConfig{
+			MaxDuration: maxDuration,
+			MaxFileSize: maxFileSize,
+		}, 5*time.Minute, func(msg string) {
+			warnings = append(warnings, msg)
+		}, func(reason string) {
+			reasons = append(reasons, reason)
+		})
+		return l, &warnings, &reasons
+	}
+
+	t.Run("duration", func(t *testing.T) {
+		l, warnings, reasons := newLimits(3600, 0)
+
+		l.update(transcoderProgress{OutTime: 50 * time.Minute})
+		require.Empty(t, *warnings)
+
+		l.update(transcoderProgress{OutTime: 55 * time.Minute})
+		l.update(transcoderProgress{OutTime: 56 * time.Minute})
+		require.Equal(t, []string{"recording will be stopped in about 5m0s as its maximum duration will be reached"}, *warnings)
+		require.Empty(t, *reasons)
+
+		l.update(transcoderProgress{OutTime: time.Hour})
+		l.update(transcoderProgress{OutTime: time.Hour + time.Second})
+		require.Equal(t, []string{"maximum duration of 1h0m0s reached"}, *reasons)
+	})
+
+	t.Run("file size", func(t *testing.T) {
+		l, warnings, reasons := newLimits(0, 100)
+
+		// 10MB per minute, 90 minutes to go.
+		l.update(transcoderProgress{OutTime: time.Minute, TotalSize: 10 * 1024 * 1024})
+		require.Empty(t, *warnings)
+
+		// 4 minutes to go.
+		l.update(transcoderProgress{OutTime: 6 * time.Minute, TotalSize: 60 * 1024 * 1024})
+		require.Equal(t, []string{"recording will be stopped in about 4m0s as its maximum file size will be reached"}, *warnings)
+
+		l.update(transcoderProgress{OutTime: 10 * time.Minute, TotalSize: 100 * 1024 * 1024})
+		require.Equal(t, []string{"maximum file size of 100MB reached"}, *reasons)
+	})
+
+	t.Run("closest limit", func(t *testing.T) {
+		l, warnings, _ := newLimits(3600, 100)
+
+		l.update(transcoderProgress{OutTime: 56 * time.Minute, TotalSize: 10 * 1024 * 1024})
+		require.Equal(t, []string{"recording will be stopped in about 4m0s as its maximum duration will be reached"}, *warnings)
+	})
+
+	t.Run("warnings disabled", func(t *testing.T) {
+		l, warnings, reasons := newLimits(3600, 0)
+		l.leadTime = 0
+
+		l.update(transcoderProgress{OutTime: 59 * time.Minute})
+		require.Empty(t, *warnings)
+		l.update(transcoderProgress{OutTime: time.Hour})
+		require.Len(t, *reasons, 1)
+	})
+}
@@ -27,25 +27,65 @@ func main() {
 	// This lets us write logs simultaneously to console and file.
 	logWriter := io.MultiWriter(os.Stdout, logFile)
 
-	logger := slog.New(slog.NewTextHandler(logWriter, &slog.HandlerOptions{
+	redactPatterns, redactErr := getLogRedactPatterns()
+	redactor, err := newRedactor(redactPatterns)
+	if err != nil {
+		redactErr = err
+		redactor, _ = newRedactor(nil)
+	}
+	// The token is known before the config gets loaded so that it's masked
+	// from the very first log line.
+	redactor.addSecret(os.Getenv("AUTH_TOKEN"))
+	redactor.addSecret(os.Getenv("STORAGE_S3_SECRET_ACCESS_KEY"))
+
+	// Every log record goes through the redactor, including commands and
+	// browser output.
+	logger := slog.New(newRedactHandler(slog.NewTextHandler(logWriter, &slog.HandlerOptions{
 		AddSource:   true,
 		Level:       slog.LevelDebug,
 		ReplaceAttr: slogReplaceAttr,
-	})).With("recID", recID)
+	}), redactor)).With("recID", recID)
 	slog.SetDefault(logger)
 
+	if redactErr != nil {
+		slog.Error("failed to load log redaction patterns", slog.String("err", redactErr.Error()))
+		os.Exit(1)
+	}
+
 	pid := os.Getpid()
 	if err := os.WriteFile("/tmp/recorder.pid", []byte(fmt.Sprintf("%d", pid)), 0666); err != nil {
 		slog.Error("failed to write pid file", slog.String("err", err.Error()))
 		os.Exit(1)
 	}
 
+	// Orphaned descendants, such as browser helpers, get reparented to us so
+	// that the supervisor can reap them.
+	if err := setChildSubreaper(); err != nil {
+		slog.Warn("failed to become child subreaper", slog.String("err", err.Error()))
+	}
+
+	daemonCfg, err := getDaemonConfig()
+	if err != nil {
+		slog.Error("invalid daemon config", slog.String("err", err.Error()))
+		os.Exit(1)
+	}
+	if daemonCfg.ListenAddr != "" {
+		redactor.addSecret(daemonCfg.APIToken)
+		if err := runDaemon(daemonCfg, redactor); err != nil {
+			slog.Error("daemon failed", slog.String("err", err.Error()))
+			os.Exit(1)
+		}
+		slog.Info("daemon has stopped, exiting")
+		return
+	}
+
 	cfg, err := config.LoadFromEnv()
 	if err != nil {
 		slog.Error("failed to load config", slog.String("err", err.Error()))
 		os.Exit(1)
 	}
 	cfg.SetDefaults()
+	redactor.addSecret(cfg.AuthToken)
 
 	slog.SetDefault(logger.With("jobID", cfg.RecordingID))
 
@@ -55,6 +95,16 @@ func main() {
 		os.Exit(1)
 	}
 
+	// The process may have been restarted while uploading a previous run of
+	// this job, in which case there's nothing left to record.
+	if resumed, err := recorder.ResumeUpload(); err != nil {
+		slog.Error("failed to resume upload", slog.String("err", err.Error()))
+		os.Exit(1)
+	} else if resumed {
+		slog.Info("recording has finished, exiting")
+		return
+	}
+
 	slog.Info("starting recording")
 
 	if err := recorder.Start(); err != nil {
@@ -79,9 +129,12 @@ func main() {
 
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
-	<-sig
-
-	slog.Info("received SIGTERM, stopping recording")
+	select {
+	case <-sig:
+		slog.Info("received SIGTERM, stopping recording")
+	case <-recorder.StopRequested():
+		slog.Info("stop requested, stopping recording")
+	}
 
 	if err := recorder.Stop(); err != nil {
 		slog.Error("failed to stop recording", slog.String("err", err.Error()))
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/chromedp/cdproto/fetch"
+	"github.com/chromedp/chromedp"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// proxyConfig holds the egress proxy settings. They apply to both the HTTP
+// client used to talk to the Mattermost API and the browser.
+type proxyConfig struct {
+	HTTPProxy  *url.URL
+	HTTPSProxy *url.URL
+	SOCKSProxy *url.URL
+	// NoProxy is a comma separated list of hosts which should be reached
+	// directly, using the same format as the NO_PROXY variable.
+	NoProxy string
+	// PACURL is the URL of a proxy auto-config file. It's only supported by
+	// the browser.
+	PACURL string
+}
+
+func getEnvAny(names ...string) string {
+	for _, name := range names {
+		if val := os.Getenv(name); val != "" {
+			return val
+		}
+	}
+	return ""
+}
+
+func parseProxyURL(val string, defaultScheme string, schemes ...string) (*url.URL, error) {
+	if !strings.Contains(val, "://") {
+		val = defaultScheme + "://" + val
+	}
+
+	u, err := url.Parse(val)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid empty host")
+	}
+
+	for _, scheme := range schemes {
+		if u.Scheme == scheme {
+			return u, nil
+		}
+	}
+
+	return nil, fmt.Errorf("invalid scheme %q", u.Scheme)
+}
+
+// getProxyConfig returns the proxy settings from the environment.
+func getProxyConfig() (proxyConfig, error) {
+	var cfg proxyConfig
+	var err error
+
+	if val := getEnvAny("HTTP_PROXY", "http_proxy"); val != "" {
+		cfg.HTTPProxy, err = parseProxyURL(val, "http", "http", "https")
+		if err != nil {
+			return cfg, fmt.Errorf("failed to parse HTTP_PROXY: %w", err)
+		}
+	}
+
+	if val := getEnvAny("HTTPS_PROXY", "https_proxy"); val != "" {
+		cfg.HTTPSProxy, err = parseProxyURL(val, "http", "http", "https")
+		if err != nil {
+			return cfg, fmt.Errorf("failed to parse HTTPS_PROXY: %w", err)
+		}
+	}
+
+	if val := getEnvAny("SOCKS_PROXY", "socks_proxy"); val != "" {
+		cfg.SOCKSProxy, err = parseProxyURL(val, "socks5", "socks5", "socks5h")
+		if err != nil {
+			return cfg, fmt.Errorf("failed to parse SOCKS_PROXY: %w", err)
+		}
+	}
+
+	cfg.NoProxy = getEnvAny("NO_PROXY", "no_proxy")
+
+	if val := os.Getenv("PROXY_PAC_URL"); val != "" {
+		u, err := url.Parse(val)
+		if err != nil {
+			return cfg, fmt.Errorf("failed to parse PROXY_PAC_URL: %w", err)
+		} else if u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "file" {
+			return cfg, fmt.Errorf("failed to parse PROXY_PAC_URL: invalid scheme %q", u.Scheme)
+		}
+		cfg.PACURL = val
+	}
+
+	return cfg, nil
+}
+
+func (c proxyConfig) isEnabled() bool {
+	return c.HTTPProxy != nil || c.HTTPSProxy != nil || c.SOCKSProxy != nil || c.PACURL != ""
+}
+
+// transportProxy returns the function to use as http.Transport.Proxy. The
+// SOCKS proxy, if any, is used for any scheme that has no explicit proxy set.
+func (c proxyConfig) transportProxy() func(*http.Request) (*url.URL, error) {
+	var cfg httpproxy.Config
+	if c.HTTPProxy != nil {
+		cfg.HTTPProxy = c.HTTPProxy.String()
+	} else if c.SOCKSProxy != nil {
+		cfg.HTTPProxy = c.SOCKSProxy.String()
+	}
+	if c.HTTPSProxy != nil {
+		cfg.HTTPSProxy = c.HTTPSProxy.String()
+	} else if c.SOCKSProxy != nil {
+		cfg.HTTPSProxy = c.SOCKSProxy.String()
+	}
+	cfg.NoProxy = c.NoProxy
+
+	proxyFn := cfg.ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return proxyFn(req.URL)
+	}
+}
+
+// chromiumProxyServer returns the value for Chromium's proxy-server flag.
+// Credentials are left out as they are not supported there. They get
+// provided through CDP instead.
+func (c proxyConfig) chromiumProxyServer() string {
+	server := func(u *url.URL) string {
+		scheme := u.Scheme
+		// Chromium always resolves host names through SOCKS5 proxies.
+		if scheme == "socks5h" {
+			scheme = "socks5"
+		}
+		return scheme + "://" + u.Host
+	}
+
+	if c.HTTPProxy == nil && c.HTTPSProxy == nil {
+		if c.SOCKSProxy != nil {
+			return server(c.SOCKSProxy)
+		}
+		return ""
+	}
+
+	var rules []string
+	if c.HTTPProxy != nil {
+		rules = append(rules, "http="+server(c.HTTPProxy))
+	}
+	if c.HTTPSProxy != nil {
+		rules = append(rules, "https="+server(c.HTTPSProxy))
+	}
+	if c.SOCKSProxy != nil {
+		rules = append(rules, "socks="+server(c.SOCKSProxy))
+	}
+
+	return strings.Join(rules, ";")
+}
+
+// chromiumBypassList converts the NO_PROXY list into Chromium's
+// proxy-bypass-list format.
+func (c proxyConfig) chromiumBypassList() string {
+	var rules []string
+	for _, rule := range strings.Split(c.NoProxy, ",") {
+		if rule = strings.TrimSpace(rule); rule != "" {
+			rules = append(rules, rule)
+		}
+	}
+	return strings.Join(rules, ";")
+}
+
+func (c proxyConfig) chromiumOptions() []chromedp.ExecAllocatorOption {
+	var opts []chromedp.ExecAllocatorOption
+
+	// The PAC file takes precedence over any other setting, same as it
+	// happens in Chromium.
+	if c.PACURL != "" {
+		return append(opts, chromedp.Flag("proxy-pac-url", c.PACURL))
+	}
+
+	if server := c.chromiumProxyServer(); server != "" {
+		opts = append(opts, chromedp.Flag("proxy-server", server))
+		if bypassList := c.chromiumBypassList(); bypassList != "" {
+			opts = append(opts, chromedp.Flag("proxy-bypass-list", bypassList))
+		}
+	}
+
+	return opts
+}
+
+// proxyHostPort returns the host and port of the given proxy URL, filling in
+// the default port for the scheme if missing.
+func proxyHostPort(u *url.URL) string {
+	if u.Port() != "" {
+		return strings.ToLower(u.Host)
+	}
+
+	port := "80"
+	switch u.Scheme {
+	case "https":
+		port = "443"
+	case "socks5", "socks5h":
+		port = "1080"
+	}
+
+	return strings.ToLower(net.JoinHostPort(u.Hostname(), port))
+}
+
+// credentials returns the credentials configured for the proxy at the given
+// origin, if any.
+func (c proxyConfig) credentials(origin string) (string, string, bool) {
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return "", "", false
+	}
+	hostPort := proxyHostPort(u)
+
+	for _, proxy := range []*url.URL{c.HTTPProxy, c.HTTPSProxy, c.SOCKSProxy} {
+		if proxy == nil || proxy.User == nil {
+			continue
+		}
+		if proxyHostPort(proxy) == hostPort {
+			password, _ := proxy.User.Password()
+			return proxy.User.Username(), password, true
+		}
+	}
+
+	return "", "", false
+}
+
+func (c proxyConfig) hasCredentials() bool {
+	for _, proxy := range []*url.URL{c.HTTPProxy, c.HTTPSProxy, c.SOCKSProxy} {
+		if proxy != nil && proxy.User != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyAuthenticator answers the proxy authentication challenges issued by
+// the browser.
+type proxyAuthenticator struct {
+	cfg proxyConfig
+
+	mut sync.Mutex
+	// Requests we already provided credentials for. A second challenge for the
+	// same request means the credentials were rejected.
+	attempted map[fetch.RequestID]bool
+}
+
+func newProxyAuthenticator(cfg proxyConfig) *proxyAuthenticator {
+	return &proxyAuthenticator{
+		cfg:       cfg,
+		attempted: map[fetch.RequestID]bool{},
+	}
+}
+
+func (a *proxyAuthenticator) response(ev *fetch.EventAuthRequired) *fetch.AuthChallengeResponse {
+	if ev.AuthChallenge == nil || ev.AuthChallenge.Source != fetch.AuthChallengeSourceProxy {
+		return &fetch.AuthChallengeResponse{Response: fetch.AuthChallengeResponseResponseDefault}
+	}
+
+	username, password, ok := a.cfg.credentials(ev.AuthChallenge.Origin)
+	if !ok {
+		slog.Error("no credentials found for proxy", slog.String("origin", ev.AuthChallenge.Origin))
+		return &fetch.AuthChallengeResponse{Response: fetch.AuthChallengeResponseResponseCancelAuth}
+	}
+
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	if a.attempted[ev.RequestID] {
+		slog.Error("proxy authentication failed", slog.String("origin", ev.AuthChallenge.Origin))
+		delete(a.attempted, ev.RequestID)
+		return &fetch.AuthChallengeResponse{Response: fetch.AuthChallengeResponseResponseCancelAuth}
+	}
+	a.attempted[ev.RequestID] = true
+
+	return &fetch.AuthChallengeResponse{
+		Response: fetch.AuthChallengeResponseResponseProvideCredentials,
+		Username: username,
+		Password: password,
+	}
+}
+
+// handleAuthRequired replies to an authentication challenge issued by the
+// browser.
+func (rec *Recorder) handleAuthRequired(ctx context.Context, ev *fetch.EventAuthRequired) {
+	resp := &fetch.AuthChallengeResponse{Response: fetch.AuthChallengeResponseResponseDefault}
+	if rec.proxyAuth != nil {
+		resp = rec.proxyAuth.response(ev)
+	}
+
+	// This can't happen synchronously as we are being called from the event
+	// listener.
+	go func() {
+		if err := chromedp.Run(ctx, fetch.ContinueWithAuth(ev.RequestID, resp)); err != nil {
+			slog.Error("failed to continue with auth", slog.String("err", err.Error()))
+		}
+	}()
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+const (
+	pulseSinkNamePrefix = "calls_recorder_"
+)
+
+// pulseSinkName returns the name of the audio sink scoped to the given job.
+func pulseSinkName(jobID string) string {
+	return pulseSinkNamePrefix + jobID
+}
+
+// pulseMonitorSource returns the name of the source capturing what's played
+// on the given sink.
+func pulseMonitorSource(sinkName string) string {
+	return sinkName + ".monitor"
+}
+
+func runPactl(args ...string) (string, error) {
+	out, err := exec.Command("pactl", args...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("pactl %s failed: %w: %s", args[0], err, strings.TrimSpace(string(out)))
+	}
+	return string(out), nil
+}
+
+// pulseSink is a null sink the browser plays audio to. The transcoder
+// captures its monitor source.
+type pulseSink struct {
+	name     string
+	moduleID int
+}
+
+// findPulseSinkModules returns the IDs of the modules which loaded a sink
+// with the given name, parsing the output of `pactl list modules short`.
+func findPulseSinkModules(out, name string) []int {
+	var ids []int
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) < 3 || fields[1] != "module-null-sink" {
+			continue
+		}
+		for _, arg := range strings.Fields(fields[2]) {
+			if arg != "sink_name="+name {
+				continue
+			}
+			if id, err := strconv.Atoi(fields[0]); err == nil {
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids
+}
+
+// parsePulseSinkState returns the state of the sink with the given name,
+// parsing the output of `pactl list sinks short`.
+func parsePulseSinkState(out, name string) (string, bool) {
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) >= 5 && fields[1] == name {
+			return strings.TrimSpace(fields[4]), true
+		}
+	}
+	return "", false
+}
+
+// createPulseSink loads a new null sink with the given name. Sinks with the
+// same name left behind by a previous run get removed first.
+func createPulseSink(name string) (*pulseSink, error) {
+	out, err := runPactl("list", "modules", "short")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list modules: %w", err)
+	}
+	for _, id := range findPulseSinkModules(out, name) {
+		slog.Warn("removing stale audio sink", slog.String("name", name), slog.Int("module", id))
+		if _, err := runPactl("unload-module", strconv.Itoa(id)); err != nil {
+			return nil, fmt.Errorf("failed to remove stale sink: %w", err)
+		}
+	}
+
+	out, err = runPactl("load-module", "module-null-sink",
+		"sink_name="+name,
+		"sink_properties=device.description="+name,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load sink module: %w", err)
+	}
+
+	id, err := strconv.Atoi(strings.TrimSpace(out))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse module ID: %w", err)
+	}
+
+	return &pulseSink{
+		name:     name,
+		moduleID: id,
+	}, nil
+}
+
+// state returns the current state of the sink (e.g. RUNNING, IDLE,
+// SUSPENDED). A running sink means there's at least one stream playing to
+// it.
+func (s *pulseSink) state() (string, error) {
+	out, err := runPactl("list", "sinks", "short")
+	if err != nil {
+		return "", fmt.Errorf("failed to list sinks: %w", err)
+	}
+
+	state, ok := parsePulseSinkState(out, s.name)
+	if !ok {
+		return "", fmt.Errorf("sink %q not found", s.name)
+	}
+
+	return state, nil
+}
+
+// remove unloads the sink.
+func (s *pulseSink) remove() error {
+	if _, err := runPactl("unload-module", strconv.Itoa(s.moduleID)); err != nil {
+		return fmt.Errorf("failed to unload sink module: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// setupFakePactl installs a fake pactl command which logs its arguments and
+// replies with the given outputs depending on the subcommand.
+func setupFakePactl(t *testing.T, modules, sinks string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "pactl.log")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "modules"), []byte(modules), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sinks"), []byte(sinks), 0600))
+
+	script := `#!/bin/sh
+echo "$@" >> ` + logPath + `
+case "$1 $2" in
+  "list modules") cat ` + filepath.Join(dir, "modules") + ` ;;
+  "list sinks") cat ` + filepath.Join(dir, "sinks") + ` ;;
+  "load-module module-null-sink") echo 42 ;;
+  "unload-module 404") echo "Failure: No such entity" >&2; exit 1 ;;
+esac
+`
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "pactl"), []byte(script), 0700))
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	return logPath
+}
+
+func readPactlLog(t *testing.T, logPath string) []string {
+	t.Helper()
+	data, err := os.ReadFile(logPath)
+	require.NoError(t, err)
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+func TestPulseSinkNames(t *testing.T) {
+	name := pulseSinkName("67t5u6cmtfbb7jug739d43xa9e")
+	require.Equal(t, "calls_recorder_67t5u6cmtfbb7jug739d43xa9e", name)
+	require.Equal(t, "calls_recorder_67t5u6cmtfbb7jug739d43xa9e.monitor", pulseMonitorSource(name))
+}
+
+func TestFindPulseSinkModules(t *testing.T) {
+	out := "0\tmodule-device-restore\t\t\n" +
+		"7\tmodule-null-sink\tsink_name=calls_recorder_a sink_properties=device.description=calls_recorder_a\t\n" +
+		"8\tmodule-null-sink\tsink_name=calls_recorder_ab\t\n" +
+		"9\tmodule-null-sink\tsink_name=calls_recorder_a\t\n" +
+		"invalid\n"
+
+	require.Equal(t, []int{7, 9}, findPulseSinkModules(out, "calls_recorder_a"))
+	require.Equal(t, []int{8}, findPulseSinkModules(out, "calls_recorder_ab"))
+	require.Empty(t, findPulseSinkModules(out, "calls_recorder_b"))
+	require.Empty(t, findPulseSinkModules("", "calls_recorder_a"))
+}
+
+func TestParsePulseSinkState(t *testing.T) {
+	out := "1\tcalls_recorder_a\tmodule-null-sink.c\ts16le 2ch 44100Hz\tRUNNING\n" +
+		"2\tcalls_recorder_b\tmodule-null-sink.c\ts16le 2ch 44100Hz\tSUSPENDED\n"
+
+	state, ok := parsePulseSinkState(out, "calls_recorder_a")
+	require.True(t, ok)
+	require.Equal(t, "RUNNING", state)
+
+	state, ok = parsePulseSinkState(out, "calls_recorder_b")
+	require.True(t, ok)
+	require.Equal(t, "SUSPENDED", state)
+
+	_, ok = parsePulseSinkState(out, "calls_recorder_c")
+	require.False(t, ok)
+}
+
+func TestPulseSink(t *testing.T) {
+	t.Run("create and remove", func(t *testing.T) {
+		logPath := setupFakePactl(t, "",
+			"5\tcalls_recorder_a\tmodule-null-sink.c\ts16le 2ch 44100Hz\tIDLE\n")
+
+		sink, err := createPulseSink("calls_recorder_a")
+		require.NoError(t, err)
+		require.Equal(t, "calls_recorder_a", sink.name)
+		require.Equal(t, 42, sink.moduleID)
+
+		state, err := sink.state()
+		require.NoError(t, err)
+		require.Equal(t, "IDLE", state)
+
+		require.NoError(t, sink.remove())
+
+		require.Equal(t, []string{
+			"list modules short",
+			"load-module module-null-sink sink_name=calls_recorder_a sink_properties=device.description=calls_recorder_a",
+			"list sinks short",
+			"unload-module 42",
+		}, readPactlLog(t, logPath))
+	})
+
+	t.Run("stale sink", func(t *testing.T) {
+		logPath := setupFakePactl(t, "3\tmodule-null-sink\tsink_name=calls_recorder_a\t\n", "")
+
+		sink, err := createPulseSink("calls_recorder_a")
+		require.NoError(t, err)
+		require.Equal(t, 42, sink.moduleID)
+
+		_, err = sink.state()
+		require.EqualError(t, err, `sink "calls_recorder_a" not found`)
+
+		require.Equal(t, []string{
+			"list modules short",
+			"unload-module 3",
+			"load-module module-null-sink sink_name=calls_recorder_a sink_properties=device.description=calls_recorder_a",
+			"list sinks short",
+		}, readPactlLog(t, logPath))
+	})
+
+	t.Run("remove failure", func(t *testing.T) {
+		setupFakePactl(t, "", "")
+
+		sink := &pulseSink{name: "calls_recorder_a", moduleID: 404}
+		err := sink.remove()
+		require.EqualError(t, err, "failed to unload sink module: pactl unload-module failed: exit status 1: Failure: No such entity")
+	})
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,11 +10,9 @@ import (
 	"net"
 	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 	"sync"
-	"syscall"
 	"time"
 
 	"golang.org/x/time/rate"
@@ -24,25 +21,26 @@ import (
 
 	"github.com/mattermost/mattermost/server/public/model"
 
+	"github.com/chromedp/cdproto/fetch"
 	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/page"
 	cruntime "github.com/chromedp/cdproto/runtime"
 	"github.com/chromedp/chromedp"
 )
 
 const (
-	pluginID                     = "com.mattermost.calls"
-	displayID                    = 45
-	readyTimeout                 = 20 * time.Second
-	stopTimeout                  = 10 * time.Second
-	connCheckInterval            = 1 * time.Second
-	initCheckInterval            = 1 * time.Second
-	initCheckTimeout             = 5 * time.Second
-	dataDir                      = "/data"
-	transcoderStartTimeout       = 5 * time.Second
-	transcoderStatsPeriod        = 100 * time.Millisecond
-	transcoderProgressSocketPath = "/tmp/progress.sock"
-	transcoderProgressBufferSize = 8192
-	transcoderProgressLogFreq    = 2 * time.Second
+	pluginID                        = "com.mattermost.calls"
+	readyTimeout                    = 20 * time.Second
+	stopTimeout                     = 10 * time.Second
+	initCheckTimeout                = 5 * time.Second
+	dataDir                         = "/data"
+	transcoderStartTimeout          = 5 * time.Second
+	transcoderStatsPeriod           = 100 * time.Millisecond
+	transcoderProgressSocketPathFmt = "/tmp/progress-%s.sock"
+	transcoderProgressBufferSize    = 8192
+	transcoderProgressLogFreq       = 2 * time.Second
+	// Finalizing the output file can take a while for long recordings.
+	transcoderStopTimeout = 5 * time.Minute
 )
 
 type Recorder struct {
@@ -56,24 +54,114 @@ type Recorder struct {
 	stopCh    chan struct{}
 	stoppedCh chan error
 
+	// closed when the recorder needs to be stopped on its own accord
+	stopRequestCh   chan struct{}
+	stopRequestOnce sync.Once
+
+	// browser context, set once the client has been initialized
+	browserCtxMut sync.RWMutex
+	browserCtx    context.Context
+
+	// events forwarded by the browser
+	browserEventsCh      chan BrowserEvent
+	browserEventHandlers []func(ev BrowserEvent)
+
+	// owns every child process (display server, transcoder, browser)
+	supervisor *supervisor
+
+	// the job scoped sink the browser plays audio to
+	audioSink *pulseSink
+
 	// display server
-	displayServer *exec.Cmd
+	displayServer *displayServer
+	// the X display the browser renders on
+	displayID int
 
 	// transcoder
-	transcoder          *exec.Cmd
+	transcoder          *supervisedProcess
 	transcoderStoppedCh chan struct{}
 
+	// screencast capture
+	screencaster       *screencaster
+	screencastStopCh   chan struct{}
+	screencastDoneCh   chan struct{}
+	screencastPipeline io.WriteCloser
+
 	client *model.Client4
 
 	outPath string
+	// additional files to be uploaded along with the recording
+	extraFiles []string
+	// where the recording gets stored
+	storage []storageBackend
+	// target -> path -> location (e.g. file ID) of the files stored so far
+	uploadedFiles map[config.StorageTarget]map[string]string
+	// path -> upload session of the files being uploaded to Mattermost. The
+	// recording can be streamed concurrently with other uploads.
+	uploadSessionsMut sync.Mutex
+	uploadSessions    map[string]uploadSessionState
+	// set once publishing has started, from which point the upload progress
+	// gets persisted
+	publishing bool
+	// closed once the recording stopped being streamed, if it was
+	streamingDoneCh chan struct{}
+
+	attendance *attendanceTracker
+
+	networkCapture *harCapture
+
+	screenshotter *screenshotter
+
+	rtcStatsInterval time.Duration
+	rtcStats         *rtcStatsCollector
+
+	audioSilenceTimeout time.Duration
+	audioMeter          *audioMeter
+
+	idleMonitor *idleMonitor
+
+	// enforces the configured maximum duration and size, if any
+	limits *recordingLimits
+	// set when the recording got stopped because of a limit
+	truncationMut    sync.RWMutex
+	truncationReason string
+
+	resourceMonitor *resourceMonitor
+
+	// user provided scripts to customize the recording page
+	customScripts []string
+
+	// Set when the configured proxies require authentication.
+	proxyAuth *proxyAuthenticator
+
+	// custom TLS material the browser needs to be provisioned with
+	tlsFiles tlsFilesConfig
+}
+
+func (rec *Recorder) setBrowserContext(ctx context.Context) {
+	rec.browserCtxMut.Lock()
+	defer rec.browserCtxMut.Unlock()
+	rec.browserCtx = ctx
+}
+
+func (rec *Recorder) getBrowserContext() context.Context {
+	rec.browserCtxMut.RLock()
+	defer rec.browserCtxMut.RUnlock()
+	return rec.browserCtx
 }
 
 func (rec *Recorder) runBrowser(recURL string) (rerr error) {
-	opts, contextOpts, err := genChromiumOptions(rec.cfg)
+	opts, contextOpts, err := genChromiumOptions(rec.cfg, rec.displayID)
 	if err != nil {
 		return fmt.Errorf("failed to generate Chromium options: %w", err)
 	}
 
+	// The browser runs in its own process group so that any helper left
+	// behind can be terminated on stop.
+	opts = append(opts, chromedp.ModifyCmdFunc(setProcessAttrs))
+	// Audio goes to the sink dedicated to this job.
+	opts = append(opts, chromedp.Env("PULSE_SINK="+pulseSinkName(rec.cfg.RecordingID)))
+
 	allocCtx, _ := chromedp.NewExecAllocator(context.Background(), opts...)
 
 	var ctx context.Context
@@ -87,6 +175,7 @@ func (rec *Recorder) runBrowser(recURL string) (rerr error) {
 	}
 
 	defer func() {
+		rec.setBrowserContext(nil)
 		cleanup()
 		rec.stoppedCh <- rerr
 	}()
@@ -102,6 +191,10 @@ func (rec *Recorder) runBrowser(recURL string) (rerr error) {
 
 		ctx, cancel = chromedp.NewContext(allocCtx, contextOpts...)
 		chromedp.ListenTarget(ctx, func(ev interface{}) {
+			if rec.networkCapture != nil {
+				rec.networkCapture.handleEvent(ev)
+			}
+
 			switch ev := ev.(type) {
 			case *cruntime.EventExceptionThrown:
 				slog.Error("chrome exception", slog.String("err", ev.ExceptionDetails.Text))
@@ -129,9 +222,27 @@ func (rec *Recorder) runBrowser(recURL string) (rerr error) {
 				str := fmt.Sprintf("chrome console %s %s", ev.Type.String(), strings.Join(args, " "))
 
 				slog.Debug(sanitizeConsoleLog(str))
+			case *cruntime.EventBindingCalled:
+				if ev.Name == browserEventBinding {
+					rec.handleBindingPayload(ev.Payload)
+				}
+			case *fetch.EventRequestPaused:
+				rec.handleRequestPaused(ctx, ev)
+			case *fetch.EventAuthRequired:
+				rec.handleAuthRequired(ctx, ev)
+			case *page.EventScreencastFrame:
+				if rec.screencaster != nil {
+					rec.screencaster.handleFrame(ctx, ev)
+				}
 			}
 		})
 
+		authTasks, err := rec.authTasks()
+		if err != nil {
+			cancel()
+			return fmt.Errorf("failed to generate auth tasks: %w", err)
+		}
+
 		// Set custom header for CSRF protection
 		headers := map[string]any{
 			"X-Calls-Recorder": "true",
@@ -139,47 +250,119 @@ func (rec *Recorder) runBrowser(recURL string) (rerr error) {
 		tasks := chromedp.Tasks{
 			network.Enable(),
 			network.SetExtraHTTPHeaders(network.Headers(headers)),
+			authTasks,
+			genEmulationTasks(rec.cfg),
+			// The binding and script need to be in place before navigating so
+			// that we don't miss any event emitted by the client.
+			cruntime.AddBinding(browserEventBinding),
+			chromedp.ActionFunc(func(ctx context.Context) error {
+				// Custom scripts go last so that they can't interfere with the
+				// ones the recorder depends on.
+				scripts := append([]string{browserEventsScript, rtcStatsScript}, rec.customScripts...)
+				for _, script := range scripts {
+					if _, err := page.AddScriptToEvaluateOnNewDocument(script).Do(ctx); err != nil {
+						return err
+					}
+				}
+				return nil
+			}),
 			chromedp.Navigate(recURL),
 		}
-		if err := chromedp.Run(ctx, tasks); err != nil {
+		err = chromedp.Run(ctx, tasks)
+		rec.trackBrowserProcess(ctx)
+		if err != nil {
 			slog.Error("failed to run chromedp", slog.String("err", err.Error()))
 			cancel()
 			// If we don't event get to navigate to the URL then there's no point in
-			// evaluating expressions. We simply wait for a second and try from
+			// waiting for events. We simply wait for a second and try from
 			// scratch.
 			time.Sleep(time.Second)
 			continue
 		}
 
-		// We poll until the client is initialized. In case of timeout we
+		// We wait until the client is initialized. In case of timeout we
 		// re-initialize the browser again.
-		if err := pollBrowserEvaluateExpr(ctx, `Boolean(window.callsClient)`, initCheckInterval, initCheckTimeout, rec.stopCh); err != nil {
-			slog.Error("failed to poll for client initialization", slog.String("err", err.Error()))
+		if _, err := rec.waitForBrowserEvent(initCheckTimeout, BrowserEventTypeInit); err != nil {
+			slog.Error("failed to wait for client initialization", slog.String("err", err.Error()))
 			cancel()
+			// Discarding any event coming from the previous page.
+			rec.drainBrowserEvents()
 		} else {
 			// Client initialized, exiting the loop.
 			break
 		}
 	}
 
+	rec.setBrowserContext(ctx)
+
+	if rec.screenshotter != nil {
+		go rec.screenshotter.run(ctx, rec.stopCh)
+	}
+
 	// Client has been initialized at this point, we move on to waiting until connected.
-	connectCheckExpr := "Boolean(window.callsClient) && Boolean(window.callsClient.connected) && Boolean(!window.callsClient.closed)"
-	if err := pollBrowserEvaluateExpr(ctx, connectCheckExpr, connCheckInterval, 0, rec.stopCh); err != nil {
+	if ev, err := rec.waitForBrowserEvent(0, BrowserEventTypeConnect, BrowserEventTypeClose); err != nil {
 		return fmt.Errorf("connectivity check failed: %w", err)
+	} else if ev.Type == BrowserEventTypeClose {
+		return fmt.Errorf("connectivity check failed: client closed before connecting: %s", ev.Error)
 	}
 
 	slog.Info("client connected to call")
+
+	if rec.attendance != nil {
+		if err := rec.seedAttendance(); err != nil {
+			slog.Warn("failed to seed attendance", slog.String("err", err.Error()))
+			// Participants that were already in the call would be missing
+			// from the count, which could make the call look empty.
+			if rec.idleMonitor != nil {
+				rec.idleMonitor.disableEmptyCallCheck()
+			}
+		}
+	}
+
+	if rec.screencaster != nil {
+		if err := rec.screencaster.start(ctx, rec.cfg.Width, rec.cfg.Height); err != nil {
+			return fmt.Errorf("failed to start screencast: %w", err)
+		}
+		slog.Info("screencast started")
+	}
+
+	if rec.rtcStats != nil {
+		go rec.rtcStats.run(ctx, rec.stopCh)
+	}
+
+	go rec.resourceMonitor.run(ctx, rec.stopCh, rec.requestReload)
+
+	if rec.idleMonitor != nil {
+		// Ending the recording goes through the same path as an external stop
+		// signal so that the client gets disconnected and the recording
+		// published.
+		go rec.idleMonitor.run(ctx, rec.stopCh, rec.requestStop)
+	}
+
 	close(rec.readyCh)
 
-	// Client connected, we poll until either we get the stop signal or client
-	// disconnects on its own.
-	disconnectCheckExpr := "Boolean(!window.callsClient) || Boolean(window.callsClient.closed)"
-	if err := pollBrowserEvaluateExpr(ctx, disconnectCheckExpr, connCheckInterval*2, 0, rec.stopCh); err != nil {
+	// Client connected, we wait until either we get the stop signal or client
+	// disconnects on its own. The page can be reloaded in the meantime if the
+	// browser is using too much memory.
+	for {
+		var ev BrowserEvent
+		ev, err = rec.waitForBrowserEvent(0, BrowserEventTypeClose, browserEventTypeReloadRequested)
+		if err != nil || ev.Type != browserEventTypeReloadRequested {
+			break
+		}
+
+		if err := rec.reloadPage(ctx); err != nil {
+			return fmt.Errorf("failed to reload page: %w", err)
+		}
+	}
+
+	if err != nil {
 		slog.Error("disconnect check failed", slog.String("err", err.Error()))
 
 		// We must have received the stop signal so we attempt a clean disconnect.
 		var disconnected bool
 		disconnectExpr := "window.callsClient.disconnect();"
+		disconnectCheckExpr := "Boolean(!window.callsClient) || Boolean(window.callsClient.closed)"
 		if err := chromedp.Run(ctx,
 			chromedp.Evaluate(disconnectExpr+disconnectCheckExpr, &disconnected),
 		); err != nil {
@@ -194,16 +377,75 @@ func (rec *Recorder) runBrowser(recURL string) (rerr error) {
 	}
 
 	// Client disconnected on its own so we self shutdown.
-	slog.Info("disconnected from call, shutting down")
-	if err := syscall.Kill(syscall.Getpid(), syscall.SIGTERM); err != nil {
-		slog.Error("failed to send SIGTERM signal", slog.String("err", err.Error()))
-	}
+	rec.requestStop("disconnected from call")
 
 	return nil
 }
 
+// requestStop signals the owner of the recorder that it should be stopped.
+// It's safe to call multiple times.
+func (rec *Recorder) requestStop(reason string) {
+	rec.stopRequestOnce.Do(func() {
+		slog.Info("recorder requested to stop", slog.String("reason", reason))
+		close(rec.stopRequestCh)
+	})
+}
+
+// handleProcessExit gets called by the supervisor whenever a child process
+// exits.
+func (rec *Recorder) handleProcessExit(exit processExit) {
+	attrs := []any{
+		slog.String("name", exit.Name),
+		slog.Int("pid", exit.PID),
+		slog.String("reason", exit.Reason),
+	}
+
+	if exit.Expected {
+		slog.Debug("process exited", attrs...)
+		return
+	}
+
+	if exit.Restarting {
+		slog.Warn("process exited unexpectedly, restarting", attrs...)
+		return
+	}
+
+	if exit.Optional {
+		slog.Warn("optional process exited unexpectedly", attrs...)
+		return
+	}
+
+	slog.Error("process exited unexpectedly", attrs...)
+
+	// Failures happening while starting are surfaced by Start itself.
+	select {
+	case <-rec.readyCh:
+		rec.requestStop(fmt.Sprintf("%s %s", exit.Name, exit.Reason))
+	default:
+	}
+}
+
+// trackBrowserProcess registers the browser process group, if any, with the
+// supervisor.
+func (rec *Recorder) trackBrowserProcess(ctx context.Context) {
+	c := chromedp.FromContext(ctx)
+	if c == nil || c.Browser == nil {
+		return
+	}
+	if proc := c.Browser.Process(); proc != nil {
+		rec.supervisor.trackGroup("chromium", proc.Pid)
+	}
+}
+
+// StopRequested returns a channel that gets closed when the recorder needs
+// to be stopped on its own accord (e.g. the call ended).
+func (rec *Recorder) StopRequested() <-chan struct{} {
+	return rec.stopRequestCh
+}
+
 func (rec *Recorder) runTranscoder(dst string) error {
-	ln, err := net.Listen("unix", transcoderProgressSocketPath)
+	// The socket is scoped by job so that concurrent recorders don't clash.
+	ln, err := net.Listen("unix", fmt.Sprintf(transcoderProgressSocketPathFmt, rec.cfg.RecordingID))
 	if err != nil {
 		return fmt.Errorf("failed to listen on progress socket: %w", err)
 	}
@@ -228,6 +470,7 @@ func (rec *Recorder) runTranscoder(dst string) error {
 		slog.Debug("accepted connection on progress socket")
 
 		var once sync.Once
+		var parser transcoderProgressParser
 		limiter := rate.NewLimiter(rate.Every(transcoderProgressLogFreq), 1)
 		buf := make([]byte, transcoderProgressBufferSize)
 		for {
@@ -246,28 +489,41 @@ func (rec *Recorder) runTranscoder(dst string) error {
 			if limiter.Allow() {
 				slog.Debug(fmt.Sprintf("ffmpeg progress:\n%s\n", string(buf[:n])))
 			}
+
+			if rec.limits != nil {
+				for _, p := range parser.write(buf[:n]) {
+					rec.limits.update(p)
+				}
+			}
 		}
 	}()
 
-	args := fmt.Sprintf(`-nostats -stats_period %0.2f -progress unix://%s -y -thread_queue_size 4096 -f alsa -i default -r %d -thread_queue_size 4096 -f x11grab -draw_mouse 0 -s %dx%d -i :%d -c:v h264 -preset %s -vf format=yuv420p -b:v %dk -b:a %dk -movflags +faststart %s`,
-		transcoderStatsPeriod.Seconds(),
-		ln.Addr(),
-		rec.cfg.FrameRate,
-		rec.cfg.Width,
-		rec.cfg.Height,
-		displayID,
-		rec.cfg.VideoPreset,
-		rec.cfg.VideoRate,
-		rec.cfg.AudioRate,
-		dst,
-	)
+	args := rec.transcoderArgs(ln.Addr().String(), dst)
 
-	cmd, err := runCmd("ffmpeg", args)
+	proc, err := rec.supervisor.start(processSpec{
+		Name:  "ffmpeg",
+		Cmd:   "ffmpeg",
+		Args:  args,
+		Stdin: rec.screencaster != nil,
+		// A restart would overwrite the output file.
+		Restart:     restartPolicyNever,
+		StopTimeout: transcoderStopTimeout,
+	})
 	if err != nil {
 		return fmt.Errorf("failed to run transcoder command: %w", err)
 	}
-
-	rec.transcoder = cmd
+	rec.transcoder = proc
+
+	if rec.screencaster != nil {
+		input := proc.input()
+		rec.screencastPipeline = input
+		go func() {
+			defer close(rec.screencastDoneCh)
+			if err := rec.screencaster.pump(input, rec.cfg.FrameRate, rec.screencastStopCh); err != nil {
+				slog.Error("failed to pump screencast frames", slog.String("err", err.Error()))
+			}
+		}()
+	}
 
 	select {
 	case <-startedCh:
@@ -278,9 +534,116 @@ func (rec *Recorder) runTranscoder(dst string) error {
 	return nil
 }
 
-func runDisplayServer(width, height int) (*exec.Cmd, error) {
-	args := fmt.Sprintf(`:%d -screen 0 %dx%dx24 -dpi 96 -nolisten tcp -nolisten unix`, displayID, width, height)
-	return runCmd("Xvfb", args)
+// runAudioMeter starts measuring the level of the audio played to the job's
+// sink.
+func (rec *Recorder) runAudioMeter() error {
+	_, err := rec.supervisor.start(processSpec{
+		Name:          "parec",
+		Cmd:           "parec",
+		Args:          rec.audioMeter.monitorArgs(pulseMonitorSource(pulseSinkName(rec.cfg.RecordingID))),
+		StdoutHandler: rec.audioMeter.consume,
+		Restart:       restartPolicyOnFailure,
+		MaxRestarts:   audioMeterRestartMax,
+		Optional:      true,
+	})
+	return err
+}
+
+// handleLimitWarning gets called when the recording is about to reach one
+// of its limits.
+func (rec *Recorder) handleLimitWarning(msg string) {
+	slog.Warn(msg)
+
+	go func() {
+		if err := rec.ReportJobWarning(msg); err != nil {
+			slog.Error("failed to report job warning", slog.String("err", err.Error()))
+		}
+	}()
+}
+
+// handleLimitReached gets called when the recording reaches one of its
+// limits. The recording is stopped the same way as if a stop signal was
+// received so that it still gets published.
+func (rec *Recorder) handleLimitReached(reason string) {
+	msg := fmt.Sprintf(limitsTruncatedTemplate, reason)
+
+	rec.truncationMut.Lock()
+	rec.truncationReason = reason
+	rec.truncationMut.Unlock()
+
+	go func() {
+		if err := rec.ReportJobTruncated(msg); err != nil {
+			slog.Error("failed to report job truncated", slog.String("err", err.Error()))
+		}
+	}()
+
+	rec.requestStop(msg)
+}
+
+// TruncationReason returns why the recording was cut short by policy, if it
+// was.
+func (rec *Recorder) TruncationReason() string {
+	rec.truncationMut.RLock()
+	defer rec.truncationMut.RUnlock()
+	return rec.truncationReason
+}
+
+// handleAudioSilence gets called when no audio has been captured for a while
+// even though participants are unmuted, which usually means audio is not
+// being routed correctly.
+func (rec *Recorder) handleAudioSilence(d time.Duration) {
+	msg := fmt.Sprintf(audioSilenceWarningTemplate, d.Round(time.Second))
+	slog.Warn(msg)
+
+	go func() {
+		if err := rec.ReportJobWarning(msg); err != nil {
+			slog.Error("failed to report job warning", slog.String("err", err.Error()))
+		}
+	}()
+}
+
+func (rec *Recorder) transcoderArgs(progressAddr, dst string) string {
+	var videoInput, videoFilter string
+	if rec.cfg.CaptureMode == config.CaptureModeScreencast {
+		// Frames are written as a sequence of JPEG images to the transcoder's
+		// standard input. They are timestamped on arrival, like the audio, so
+		// that frames written late or skipped don't make the video drift, and
+		// then resampled to a constant frame rate. Scaling guarantees the
+		// output matches the configured size in case the browser sends
+		// smaller frames.
+		videoInput = fmt.Sprintf(`-thread_queue_size 4096 -use_wallclock_as_timestamps 1 -f image2pipe -framerate %d -c:v mjpeg -i pipe:0`,
+			rec.cfg.FrameRate,
+		)
+		videoFilter = fmt.Sprintf("fps=%d,scale=%d:%d,format=yuv420p", rec.cfg.FrameRate, rec.cfg.Width, rec.cfg.Height)
+	} else {
+		videoInput = fmt.Sprintf(`-r %d -thread_queue_size 4096 -f x11grab -draw_mouse 0 -s %dx%d -i :%d`,
+			rec.cfg.FrameRate,
+			rec.cfg.Width,
+			rec.cfg.Height,
+			rec.displayID,
+		)
+		videoFilter = "format=yuv420p"
+	}
+
+	// Fragmented output can be uploaded while it's being written, whereas
+	// faststart rewrites the file once done.
+	movFlags := "+faststart"
+	if rec.cfg.StreamingUpload {
+		movFlags = transcoderStreamingMovFlags
+	}
+
+	return fmt.Sprintf(`-nostats -stats_period %0.2f -progress unix://%s -y -thread_queue_size 4096 -f pulse -i %s %s -c:v h264 -preset %s -vf %s -b:v %dk -b:a %dk -movflags %s %s`,
+		transcoderStatsPeriod.Seconds(),
+		progressAddr,
+		pulseMonitorSource(pulseSinkName(rec.cfg.RecordingID)),
+		videoInput,
+		rec.cfg.VideoPreset,
+		videoFilter,
+		rec.cfg.VideoRate,
+		rec.cfg.AudioRate,
+		movFlags,
+		dst,
+	)
 }
 
 func NewRecorder(cfg config.RecorderConfig, dataPath string) (*Recorder, error) {
@@ -295,24 +658,146 @@ func NewRecorder(cfg config.RecorderConfig, dataPath string) (*Recorder, error)
 	client := model.NewAPIv4Client(cfg.SiteURL)
 	client.SetToken(cfg.AuthToken)
 
+	if _, err := getExtraChromiumArgs(); err != nil {
+		return nil, fmt.Errorf("invalid Chromium args config: %w", err)
+	}
+
+	proxy, err := getProxyConfig()
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy config: %w", err)
+	}
+	if proxy.PACURL != "" && proxy.HTTPProxy == nil && proxy.HTTPSProxy == nil && proxy.SOCKSProxy == nil {
+		slog.Warn("proxy auto-config is only supported by the browser, API requests will not be proxied")
+	}
+
+	tlsFiles, err := getTLSFilesConfig()
+	if err != nil {
+		return nil, fmt.Errorf("invalid TLS config: %w", err)
+	}
+	tlsConfig, err := tlsFiles.clientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("invalid TLS config: %w", err)
+	}
+
+	hostMappings, err := getHostMappings()
+	if err != nil {
+		return nil, fmt.Errorf("invalid host mappings config: %w", err)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxy.transportProxy()
+	transport.TLSClientConfig = tlsConfig
+	if len(hostMappings) > 0 {
+		dialer := &net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}
+		transport.DialContext = hostMappingsDialContext(hostMappings, dialer.DialContext)
+	}
 	client.HTTPClient = &http.Client{
 		Transport: &clientTransport{
-			transport: http.DefaultTransport,
+			transport: transport,
 		},
 	}
 
-	return &Recorder{
+	rec := &Recorder{
 		cfg:                 cfg,
 		dataPath:            dataPath,
 		readyCh:             make(chan struct{}),
 		stopCh:              make(chan struct{}),
+		stopRequestCh:       make(chan struct{}),
 		stoppedCh:           make(chan error),
+		browserEventsCh:     make(chan BrowserEvent, browserEventsQueueSize),
 		transcoderStoppedCh: make(chan struct{}),
 		client:              client,
-	}, nil
+		uploadedFiles:       map[config.StorageTarget]map[string]string{},
+		uploadSessions:      map[string]uploadSessionState{},
+		tlsFiles:            tlsFiles,
+	}
+
+	storageCfg, err := getStorageConfig()
+	if err != nil {
+		return nil, fmt.Errorf("invalid storage config: %w", err)
+	}
+	// Targets have been validated along with the rest of the config.
+	storageTargets, _ := cfg.GetStorageTargets()
+	// Object storage is a separate service so it doesn't get the client
+	// certificate or the host mappings meant for the Mattermost site.
+	storageTransport := http.DefaultTransport.(*http.Transport).Clone()
+	storageTransport.Proxy = proxy.transportProxy()
+	rec.storage, err = rec.newStorageBackends(storageTargets, storageCfg, &http.Client{
+		Transport: &clientTransport{
+			transport: storageTransport,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid storage config: %w", err)
+	}
+
+	if interval, maxFiles, err := getScreenshotsConfig(); err != nil {
+		return nil, fmt.Errorf("invalid screenshots config: %w", err)
+	} else if interval > 0 {
+		rec.screenshotter = newScreenshotter(filepath.Join(dataPath, screenshotsDirName), interval, maxFiles)
+	}
+
+	rtcStatsInterval, err := getRTCStatsInterval()
+	if err != nil {
+		return nil, fmt.Errorf("invalid WebRTC stats config: %w", err)
+	}
+	rec.rtcStatsInterval = rtcStatsInterval
+
+	audioSilenceTimeout, err := getAudioSilenceTimeout()
+	if err != nil {
+		return nil, fmt.Errorf("invalid audio config: %w", err)
+	}
+	rec.audioSilenceTimeout = audioSilenceTimeout
+
+	limitsLeadTime, err := getLimitsWarningLeadTime()
+	if err != nil {
+		return nil, fmt.Errorf("invalid limits config: %w", err)
+	}
+	if cfg.MaxDuration > 0 || cfg.MaxFileSize > 0 {
+		rec.limits = newRecordingLimits(cfg, limitsLeadTime, rec.handleLimitWarning, rec.handleLimitReached)
+	}
+
+	monitorInterval, memoryLimit, err := getResourceMonitorConfig()
+	if err != nil {
+		return nil, fmt.Errorf("invalid resource monitor config: %w", err)
+	}
+	rec.resourceMonitor = newResourceMonitor(monitorInterval, memoryLimit)
+
+	customScripts, err := loadCustomPageScripts()
+	if err != nil {
+		return nil, fmt.Errorf("invalid page customization config: %w", err)
+	}
+	rec.customScripts = customScripts
+
+	if proxy.hasCredentials() {
+		rec.proxyAuth = newProxyAuthenticator(proxy)
+	}
+
+	if isNetworkCaptureEnabled() {
+		rec.networkCapture = newHARCapture()
+	}
+
+	// The idle monitor relies on the tracker to know who's in the call.
+	if cfg.AttendanceReport || cfg.IdleTimeout > 0 {
+		rec.attendance = newAttendanceTracker()
+		rec.onBrowserEvent(rec.attendance.handleEvent)
+	}
+
+	if cfg.CaptureMode == config.CaptureModeScreencast {
+		rec.screencaster = newScreencaster()
+		rec.screencastStopCh = make(chan struct{})
+		rec.screencastDoneCh = make(chan struct{})
+	}
+
+	return rec, nil
 }
 
 func (rec *Recorder) Start() error {
+	rec.supervisor = newSupervisor(rec.handleProcessExit)
+
 	if err := checkOSRequirements(); err != nil {
 		return err
 	}
@@ -323,20 +808,58 @@ func (rec *Recorder) Start() error {
 	}
 	rec.outPath = filepath.Join(rec.dataPath, filename)
 
-	rec.displayServer, err = runDisplayServer(rec.cfg.Width, rec.cfg.Height)
-	if err != nil {
-		return fmt.Errorf("failed to run display server: %s", err)
+	if rec.rtcStatsInterval > 0 {
+		rec.rtcStats = newRTCStatsCollector(strings.TrimSuffix(rec.outPath, filepath.Ext(rec.outPath))+rtcStatsFileSuffix, rec.rtcStatsInterval)
 	}
 
-	data, err := json.Marshal(map[string]string{
-		"token": rec.cfg.AuthToken,
-	})
+	rec.audioMeter = newAudioMeter(strings.TrimSuffix(rec.outPath, filepath.Ext(rec.outPath))+audioLevelsFileSuffix,
+		rec.audioSilenceTimeout, rec.handleAudioSilence)
+	rec.onBrowserEvent(rec.audioMeter.handleEvent)
+
+	if rec.cfg.IdleTimeout > 0 {
+		rec.idleMonitor = newIdleMonitor(time.Duration(rec.cfg.IdleTimeout)*time.Second,
+			rec.attendance.activeParticipants, rec.audioMeter.lastSound)
+		rec.onBrowserEvent(rec.idleMonitor.handleEvent)
+	}
+
+	// The display server is only needed when capturing the browser window.
+	// In screencast mode frames come directly from the headless browser.
+	if rec.cfg.CaptureMode != config.CaptureModeScreencast {
+		rec.displayServer, err = runDisplayServer(rec.supervisor, rec.cfg.Width, rec.cfg.Height)
+		if err != nil {
+			return fmt.Errorf("failed to run display server: %s", err)
+		}
+		rec.displayID = rec.displayServer.id
+		slog.Info("display server started", slog.Int("display", rec.displayID))
+	}
+
+	// Every job gets its own audio sink so that concurrent recordings don't
+	// capture each other's audio.
+	rec.audioSink, err = createPulseSink(pulseSinkName(rec.cfg.RecordingID))
 	if err != nil {
-		return fmt.Errorf("failed to marshal data: %s", err)
+		return fmt.Errorf("failed to create audio sink: %w", err)
 	}
+	slog.Info("audio sink created", slog.String("name", rec.audioSink.name))
 
-	recURL := fmt.Sprintf("%s/plugins/%s/standalone/recording.html?call_id=%s&job_id=%s#%s",
-		rec.cfg.SiteURL, pluginID, rec.cfg.CallID, rec.cfg.RecordingID, base64.URLEncoding.EncodeToString(data))
+	// The browser doesn't share the Go TLS config so it needs its own copy of
+	// the certificates.
+	if rec.tlsFiles.isEnabled() {
+		dir, err := getNSSDBDir()
+		if err != nil {
+			return fmt.Errorf("failed to get NSS database directory: %w", err)
+		}
+		if err := provisionNSSDB(dir, rec.tlsFiles); err != nil {
+			return fmt.Errorf("failed to provision NSS database: %w", err)
+		}
+		slog.Info("NSS database provisioned", slog.String("dir", dir))
+	}
+
+	// The auth token is delivered to the page through CDP (see authTasks) so
+	// that it never shows up in the URL.
+	if err := rec.checkPluginVersion(); err != nil {
+		return err
+	}
+	recURL := genRecordingURL(rec.cfg)
 
 	go func() {
 		if err := rec.runBrowser(recURL); err != nil {
@@ -358,6 +881,16 @@ func (rec *Recorder) Start() error {
 	}
 
 	slog.Info("transcoder started")
+
+	if rec.cfg.StreamingUpload {
+		rec.streamingDoneCh = make(chan struct{})
+		go rec.runStreamingUpload(rec.stopCh)
+	}
+
+	// Metering is best effort, the recording can go on without it.
+	if err := rec.runAudioMeter(); err != nil {
+		slog.Error("failed to run audio meter", slog.String("err", err.Error()))
+	}
 	if err := rec.ReportJobStarted(); err != nil {
 		return fmt.Errorf("failed to report job started status: %w", err)
 	}
@@ -366,13 +899,32 @@ func (rec *Recorder) Start() error {
 }
 
 func (rec *Recorder) Stop() error {
+	// A running sink tells whether the browser was still playing audio up
+	// until this point.
+	if rec.audioSink != nil {
+		if state, err := rec.audioSink.state(); err != nil {
+			slog.Error("failed to get audio sink state", slog.String("err", err.Error()))
+		} else {
+			slog.Info("audio sink state", slog.String("name", rec.audioSink.name), slog.String("state", state))
+		}
+	}
+
+	if rec.screencastPipeline != nil {
+		slog.Info("stopping screencast pipeline")
+		close(rec.screencastStopCh)
+		<-rec.screencastDoneCh
+		if err := rec.screencastPipeline.Close(); err != nil {
+			slog.Error("failed to close screencast pipeline", slog.String("err", err.Error()))
+		}
+		rec.screencastPipeline = nil
+	}
+
 	if rec.transcoder != nil {
 		slog.Info("stopping transcoder")
-		if err := rec.transcoder.Process.Signal(syscall.SIGTERM); err != nil {
-			slog.Error("failed to send signal", slog.String("err", err.Error()))
-		}
-		if err := rec.transcoder.Wait(); err != nil {
-			slog.Error("failed waiting for transcoder to exit", slog.String("err", err.Error()))
+		if err := rec.transcoder.stop(); err != nil {
+			slog.Error("failed to stop transcoder", slog.String("err", err.Error()))
+		} else if err := rec.transcoder.err(); err != nil {
+			slog.Error("transcoder exited with error", slog.String("reason", processExitReason(err)))
 		}
 		<-rec.transcoderStoppedCh
 		rec.transcoder = nil
@@ -380,6 +932,10 @@ func (rec *Recorder) Stop() error {
 
 	close(rec.stopCh)
 
+	if rec.streamingDoneCh != nil {
+		<-rec.streamingDoneCh
+	}
+
 	var exitErr error
 	select {
 	case exitErr = <-rec.stoppedCh:
@@ -387,21 +943,62 @@ func (rec *Recorder) Stop() error {
 		exitErr = fmt.Errorf("timed out waiting for stopped event")
 	}
 
-	if rec.displayServer != nil {
-		slog.Info("stopping display server")
-		if err := rec.displayServer.Process.Signal(syscall.SIGTERM); err != nil {
-			slog.Error("failed to stop display process", slog.String("err", err.Error()))
+	// Anything still running, such as browser helpers and the display
+	// server, gets terminated in reverse start order.
+	if rec.supervisor != nil {
+		slog.Info("stopping processes")
+		if err := rec.supervisor.stopAll(); err != nil {
+			slog.Error("failed to stop processes", slog.String("err", err.Error()))
 		}
-		if err := rec.displayServer.Wait(); err != nil {
-			slog.Error("failed waiting for display server to exit", slog.String("err", err.Error()))
+		if rec.displayServer != nil {
+			releaseDisplay(rec.displayServer.id)
+			rec.displayServer = nil
+		}
+	}
+
+	if rec.audioSink != nil {
+		slog.Info("removing audio sink", slog.String("name", rec.audioSink.name))
+		if err := rec.audioSink.remove(); err != nil {
+			slog.Error("failed to remove audio sink", slog.String("err", err.Error()))
+		}
+		rec.audioSink = nil
+	}
+
+	if rec.audioMeter != nil {
+		slog.Info("audio levels summary", rec.audioMeter.logAttrs()...)
+		if err := rec.audioMeter.close(); err != nil {
+			slog.Error("failed to close audio levels file", slog.String("err", err.Error()))
+		}
+	}
+
+	if rec.rtcStats != nil {
+		slog.Info("webrtc stats summary", rec.rtcStats.logAttrs()...)
+		if err := rec.rtcStats.close(); err != nil {
+			slog.Error("failed to close webrtc stats file", slog.String("err", err.Error()))
 		}
-		rec.displayServer = nil
+	}
+
+	if _, err := rec.saveNetworkCapture(); err != nil {
+		slog.Error("failed to save network capture", slog.String("err", err.Error()))
 	}
 
 	if exitErr != nil {
 		return exitErr
 	}
 
+	if rec.cfg.AttendanceReport {
+		if paths, err := rec.writeAttendanceReport(); err != nil {
+			slog.Error("failed to write attendance report", slog.String("err", err.Error()))
+		} else {
+			rec.extraFiles = append(rec.extraFiles, paths...)
+		}
+	}
+
+	return rec.publishAndCleanUp()
+}
+
+// publishAndCleanUp publishes the recording and removes its files once done.
+func (rec *Recorder) publishAndCleanUp() error {
 	if err := rec.publishRecording(); err != nil {
 		return fmt.Errorf("failed to publish recording: %w", err)
 	}
@@ -411,5 +1008,17 @@ func (rec *Recorder) Stop() error {
 		slog.Error("failed to remove recording", slog.String("err", err.Error()))
 	}
 
+	for _, path := range rec.extraFiles {
+		if err := os.Remove(path); err != nil {
+			slog.Error("failed to remove file", slog.String("path", path), slog.String("err", err.Error()))
+		}
+	}
+
+	if rec.screenshotter != nil {
+		if err := rec.screenshotter.removeAll(); err != nil {
+			slog.Error("failed to remove screenshots", slog.String("err", err.Error()))
+		}
+	}
+
 	return nil
 }
@@ -44,3 +44,47 @@ func TestNewRecorder(t *testing.T) {
 		require.NotNil(t, rec)
 	})
 }
+
+func TestTranscoderArgs(t *testing.T) {
+	cfg := config.RecorderConfig{
+		SiteURL:     "http://localhost:8065",
+		CallID:      "8w8jorhr7j83uqr6y1st894hqe",
+		PostID:      "udzdsg7dwidbzcidx5khrf8nee",
+		RecordingID: "67t5u6cmtfbb7jug739d43xa9e",
+		AuthToken:   "qj75unbsef83ik9p7ueypb6iyw",
+	}
+	cfg.SetDefaults()
+
+	t.Run("x11grab", func(t *testing.T) {
+		rec, err := NewRecorder(cfg, getDataDir(""))
+		require.NoError(t, err)
+		require.Nil(t, rec.screencaster)
+		rec.displayID = 46
+		require.Equal(t, "-nostats -stats_period 0.10 -progress unix:///tmp/progress.sock -y -thread_queue_size 4096 -f pulse -i calls_recorder_67t5u6cmtfbb7jug739d43xa9e.monitor -r 30 -thread_queue_size 4096 -f x11grab -draw_mouse 0 -s 1920x1080 -i :46 -c:v h264 -preset fast -vf format=yuv420p -b:v 1500k -b:a 64k -movflags +faststart /data/rec.mp4",
+			rec.transcoderArgs("/tmp/progress.sock", "/data/rec.mp4"))
+	})
+
+	t.Run("screencast", func(t *testing.T) {
+		cfg := cfg
+		cfg.CaptureMode = config.CaptureModeScreencast
+		rec, err := NewRecorder(cfg, getDataDir(""))
+		require.NoError(t, err)
+		require.NotNil(t, rec.screencaster)
+		require.Equal(t, "-nostats -stats_period 0.10 -progress unix:///tmp/progress.sock -y -thread_queue_size 4096 -f pulse -i calls_recorder_67t5u6cmtfbb7jug739d43xa9e.monitor -thread_queue_size 4096 -use_wallclock_as_timestamps 1 -f image2pipe -framerate 30 -c:v mjpeg -i pipe:0 -c:v h264 -preset fast -vf fps=30,scale=1920:1080,format=yuv420p -b:v 1500k -b:a 64k -movflags +faststart /data/rec.mp4",
+			rec.transcoderArgs("/tmp/progress.sock", "/data/rec.mp4"))
+	})
+	t.Run("streaming upload", func(t *testing.T) {
+		t.Setenv("STORAGE_S3_ENDPOINT", "http://minio:9000")
+		t.Setenv("STORAGE_S3_BUCKET", "recordings")
+		t.Setenv("STORAGE_S3_ACCESS_KEY_ID", "key")
+		t.Setenv("STORAGE_S3_SECRET_ACCESS_KEY", "secret")
+		cfg := cfg
+		cfg.StorageTargets = "mattermost,s3"
+		cfg.StreamingUpload = true
+		rec, err := NewRecorder(cfg, getDataDir(""))
+		require.NoError(t, err)
+		rec.displayID = 46
+		require.Equal(t, "-nostats -stats_period 0.10 -progress unix:///tmp/progress.sock -y -thread_queue_size 4096 -f pulse -i calls_recorder_67t5u6cmtfbb7jug739d43xa9e.monitor -r 30 -thread_queue_size 4096 -f x11grab -draw_mouse 0 -s 1920x1080 -i :46 -c:v h264 -preset fast -vf format=yuv420p -b:v 1500k -b:a 64k -movflags +frag_keyframe+empty_moov+default_base_moof /data/rec.mp4",
+			rec.transcoderArgs("/tmp/progress.sock", "/data/rec.mp4"))
+	})
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const (
+	redactedValue = "XXX"
+	// Secrets shorter than this are not redacted literally as they would
+	// likely cause too many false positives.
+	redactMinSecretLen = 8
+)
+
+type redactRule struct {
+	re   *regexp.Regexp
+	repl string
+}
+
+var redactRules = []redactRule{
+	// JSON fields (e.g. WebSocket auth challenges, TURN credentials).
+	{
+		re:   regexp.MustCompile(`(?i)("(?:token|auth_token|access_token|password|credential|secret)"\s*:\s*")[^"]*(")`),
+		repl: "${1}" + redactedValue + "${2}",
+	},
+	// Unquoted key/value pairs (e.g. environment variables, printed maps).
+	{
+		re:   regexp.MustCompile(`(?i)\b((?:token|auth_token|access_token|password|credential|secret)[=:])[^\s"'&,;}\]]+`),
+		repl: "${1}" + redactedValue,
+	},
+	// Authorization headers.
+	{
+		re:   regexp.MustCompile(`(?i)\b(bearer\s+)[\w\-.~+/]+=*`),
+		repl: "${1}" + redactedValue,
+	},
+	// URLs with credentials.
+	{
+		re:   regexp.MustCompile(`\b([a-zA-Z][a-zA-Z0-9+.\-]*://)[^/\s:@]*:[^/\s@]*@`),
+		repl: "${1}" + redactedValue + "@",
+	},
+	// Base64 encoded JSON objects starting with a token field, as used in URL
+	// fragments to pass the authentication data.
+	{
+		re:   regexp.MustCompile(`eyJ0b2tlbiI6[\w\-+/]*=*`),
+		repl: redactedValue,
+	},
+}
+
+// redactSecrets masks any well-known secret found in the given string.
+func redactSecrets(str string) string {
+	for _, rule := range redactRules {
+		str = rule.re.ReplaceAllString(str, rule.repl)
+	}
+	return sanitizeConsoleLog(str)
+}
+
+// redactor masks secrets in arbitrary strings. On top of the built-in rules
+// it supports literal secrets (e.g. the auth token) and user supplied
+// patterns.
+type redactor struct {
+	patterns []*regexp.Regexp
+
+	mut     sync.RWMutex
+	secrets []string
+}
+
+func newRedactor(patterns []string) (*redactor, error) {
+	r := &redactor{}
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile pattern %q: %w", p, err)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	return r, nil
+}
+
+// getLogRedactPatterns returns the extra redaction patterns from the
+// environment. They are expected as a JSON array of regular expressions.
+func getLogRedactPatterns() ([]string, error) {
+	val := os.Getenv("LOG_REDACT_PATTERNS")
+	if val == "" {
+		return nil, nil
+	}
+
+	var patterns []string
+	if err := json.Unmarshal([]byte(val), &patterns); err != nil {
+		return nil, fmt.Errorf("failed to parse LOG_REDACT_PATTERNS: %w", err)
+	}
+
+	return patterns, nil
+}
+
+func (r *redactor) addSecret(secret string) {
+	if len(secret) < redactMinSecretLen {
+		return
+	}
+
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	for _, s := range r.secrets {
+		if s == secret {
+			return
+		}
+	}
+	r.secrets = append(r.secrets, secret)
+}
+
+func (r *redactor) redact(str string) string {
+	r.mut.RLock()
+	for _, secret := range r.secrets {
+		str = strings.ReplaceAll(str, secret, redactedValue)
+	}
+	r.mut.RUnlock()
+
+	for _, re := range r.patterns {
+		str = re.ReplaceAllString(str, redactedValue)
+	}
+
+	return redactSecrets(str)
+}
+
+func (r *redactor) redactAttr(a slog.Attr) slog.Attr {
+	a.Value = a.Value.Resolve()
+
+	switch a.Value.Kind() {
+	case slog.KindString:
+		a.Value = slog.StringValue(r.redact(a.Value.String()))
+	case slog.KindGroup:
+		attrs := a.Value.Group()
+		redacted := make([]slog.Attr, 0, len(attrs))
+		for _, ga := range attrs {
+			redacted = append(redacted, r.redactAttr(ga))
+		}
+		a.Value = slog.GroupValue(redacted...)
+	case slog.KindAny:
+		// Arbitrary values (e.g. errors, maps, structs) get formatted the same
+		// way the handler would and are only replaced if they contain secrets.
+		str := fmt.Sprintf("%+v", a.Value.Any())
+		if redacted := r.redact(str); redacted != str {
+			a.Value = slog.StringValue(redacted)
+		}
+	}
+
+	return a
+}
+
+// redactHandler is a slog.Handler wrapper masking secrets from log records
+// before they reach the underlying handler.
+type redactHandler struct {
+	handler  slog.Handler
+	redactor *redactor
+}
+
+func newRedactHandler(h slog.Handler, r *redactor) *redactHandler {
+	return &redactHandler{
+		handler:  h,
+		redactor: r,
+	}
+}
+
+func (h *redactHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.handler.Enabled(ctx, level)
+}
+
+func (h *redactHandler) Handle(ctx context.Context, r slog.Record) error {
+	nr := slog.NewRecord(r.Time, r.Level, h.redactor.redact(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		nr.AddAttrs(h.redactor.redactAttr(a))
+		return true
+	})
+	return h.handler.Handle(ctx, nr)
+}
+
+func (h *redactHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, 0, len(attrs))
+	for _, a := range attrs {
+		redacted = append(redacted, h.redactor.redactAttr(a))
+	}
+	return newRedactHandler(h.handler.WithAttrs(redacted), h.redactor)
+}
+
+func (h *redactHandler) WithGroup(name string) slog.Handler {
+	return newRedactHandler(h.handler.WithGroup(name), h.redactor)
+}
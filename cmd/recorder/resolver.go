@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// hostMapping forces a host name to resolve to a given IP address. Host
+// names starting with "*." match any subdomain.
+type hostMapping struct {
+	Host string
+	IP   net.IP
+}
+
+// parseHostMappings parses a comma separated list of host=ip pairs.
+func parseHostMappings(val string) ([]hostMapping, error) {
+	var mappings []hostMapping
+	for _, entry := range strings.Split(val, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		host, ip, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid mapping %q: should be in host=ip format", entry)
+		}
+
+		host = strings.ToLower(strings.TrimSpace(host))
+		if host == "" || strings.ContainsAny(host, " :/") {
+			return nil, fmt.Errorf("invalid mapping %q: invalid host", entry)
+		}
+
+		parsedIP := net.ParseIP(strings.Trim(strings.TrimSpace(ip), "[]"))
+		if parsedIP == nil {
+			return nil, fmt.Errorf("invalid mapping %q: invalid IP address", entry)
+		}
+
+		mappings = append(mappings, hostMapping{Host: host, IP: parsedIP})
+	}
+
+	return mappings, nil
+}
+
+// getHostMappings returns the host resolution overrides from the environment.
+func getHostMappings() ([]hostMapping, error) {
+	mappings, err := parseHostMappings(os.Getenv("HOST_MAPPINGS"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse HOST_MAPPINGS: %w", err)
+	}
+	return mappings, nil
+}
+
+func lookupHostMapping(mappings []hostMapping, host string) (net.IP, bool) {
+	host = strings.ToLower(host)
+	for _, m := range mappings {
+		if m.Host == host {
+			return m.IP, true
+		}
+		if suffix, ok := strings.CutPrefix(m.Host, "*"); ok && strings.HasSuffix(host, suffix) {
+			return m.IP, true
+		}
+	}
+	return nil, false
+}
+
+// hostMappingsDialContext wraps the given dial function so that mapped hosts
+// get connected to the configured IP address.
+func hostMappingsDialContext(mappings []hostMapping, dialFn func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return dialFn(ctx, network, addr)
+		}
+		if ip, ok := lookupHostMapping(mappings, host); ok {
+			addr = net.JoinHostPort(ip.String(), port)
+		}
+		return dialFn(ctx, network, addr)
+	}
+}
+
+// chromiumHostResolverRules returns the value for Chromium's
+// host-resolver-rules flag.
+func chromiumHostResolverRules(mappings []hostMapping) string {
+	rules := make([]string, 0, len(mappings))
+	for _, m := range mappings {
+		ip := m.IP.String()
+		if m.IP.To4() == nil {
+			ip = "[" + ip + "]"
+		}
+		rules = append(rules, fmt.Sprintf("MAP %s %s", m.Host, ip))
+	}
+	return strings.Join(rules, ",")
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseHostMappings(t *testing.T) {
+	tcs := []struct {
+		name     string
+		input    string
+		expected []hostMapping
+		err      string
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name:  "valid",
+			input: "MM-Server=172.17.0.1, *.example.com = 10.0.0.5,,ipv6.local=[::1]",
+			expected: []hostMapping{
+				{Host: "mm-server", IP: net.ParseIP("172.17.0.1")},
+				{Host: "*.example.com", IP: net.ParseIP("10.0.0.5")},
+				{Host: "ipv6.local", IP: net.ParseIP("::1")},
+			},
+		},
+		{
+			name:  "missing separator",
+			input: "mm-server 172.17.0.1",
+			err:   `invalid mapping "mm-server 172.17.0.1": should be in host=ip format`,
+		},
+		{
+			name:  "invalid host",
+			input: "mm-server:8065=172.17.0.1",
+			err:   `invalid mapping "mm-server:8065=172.17.0.1": invalid host`,
+		},
+		{
+			name:  "invalid ip",
+			input: "mm-server=mm.internal",
+			err:   `invalid mapping "mm-server=mm.internal": invalid IP address`,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			mappings, err := parseHostMappings(tc.input)
+			if tc.err != "" {
+				require.EqualError(t, err, tc.err)
+				require.Empty(t, mappings)
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, tc.expected, mappings)
+			}
+		})
+	}
+}
+
+func TestHostMappings(t *testing.T) {
+	mappings, err := parseHostMappings("mm-server=172.17.0.1,*.example.com=10.0.0.5,ipv6.local=::1")
+	require.NoError(t, err)
+
+	t.Run("lookup", func(t *testing.T) {
+		ip, ok := lookupHostMapping(mappings, "MM-SERVER")
+		require.True(t, ok)
+		require.Equal(t, "172.17.0.1", ip.String())
+
+		ip, ok = lookupHostMapping(mappings, "mm.example.com")
+		require.True(t, ok)
+		require.Equal(t, "10.0.0.5", ip.String())
+
+		_, ok = lookupHostMapping(mappings, "example.com")
+		require.False(t, ok)
+	})
+
+	t.Run("dial", func(t *testing.T) {
+		var dialed []string
+		dialFn := hostMappingsDialContext(mappings, func(_ context.Context, _, addr string) (net.Conn, error) {
+			dialed = append(dialed, addr)
+			return nil, nil
+		})
+
+		for _, addr := range []string{"mm-server:8065", "mm.example.com:443", "ipv6.local:80", "other.com:443", "invalid"} {
+			_, err := dialFn(context.Background(), "tcp", addr)
+			require.NoError(t, err)
+		}
+
+		require.Equal(t, []string{"172.17.0.1:8065", "10.0.0.5:443", "[::1]:80", "other.com:443", "invalid"}, dialed)
+	})
+
+	t.Run("chromium rules", func(t *testing.T) {
+		require.Equal(t, "MAP mm-server 172.17.0.1,MAP *.example.com 10.0.0.5,MAP ipv6.local [::1]", chromiumHostResolverRules(mappings))
+	})
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chromedp/cdproto/performance"
+	"github.com/chromedp/chromedp"
+)
+
+const (
+	resourceMonitorIntervalDefault = 30 * time.Second
+	resourceMonitorReloadCooldown  = 5 * time.Minute
+)
+
+var procRootPath = "/proc"
+
+type resourceSample struct {
+	At time.Time
+	// Resident set size of the whole browser process tree, in bytes.
+	RSS         int64
+	JSHeapUsed  int64
+	JSHeapTotal int64
+	Nodes       int64
+	Listeners   int64
+}
+
+// resourceMonitor keeps track of the resources used by the browser and
+// decides when the page should be reloaded to release memory.
+type resourceMonitor struct {
+	interval time.Duration
+	// memoryLimit is the RSS (in bytes) above which the page gets reloaded.
+	// Zero means no limit.
+	memoryLimit int64
+
+	first        resourceSample
+	lastReloadAt time.Time
+}
+
+func newResourceMonitor(interval time.Duration, memoryLimit int64) *resourceMonitor {
+	return &resourceMonitor{
+		interval:    interval,
+		memoryLimit: memoryLimit,
+	}
+}
+
+// getResourceMonitorConfig returns the resource monitoring settings from the
+// environment.
+func getResourceMonitorConfig() (time.Duration, int64, error) {
+	interval := resourceMonitorIntervalDefault
+	if val := os.Getenv("CHROMIUM_MONITOR_INTERVAL"); val != "" {
+		var err error
+		interval, err = time.ParseDuration(val)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to parse CHROMIUM_MONITOR_INTERVAL: %w", err)
+		} else if interval < time.Second {
+			return 0, 0, fmt.Errorf("CHROMIUM_MONITOR_INTERVAL should be at least 1s")
+		}
+	}
+
+	var memoryLimit int64
+	if val := os.Getenv("CHROMIUM_MEMORY_LIMIT_MB"); val != "" {
+		limit, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to parse CHROMIUM_MEMORY_LIMIT_MB: %w", err)
+		} else if limit < 0 {
+			return 0, 0, fmt.Errorf("CHROMIUM_MEMORY_LIMIT_MB should not be negative")
+		}
+		memoryLimit = limit * 1024 * 1024
+	}
+
+	return interval, memoryLimit, nil
+}
+
+// getProcessTreeRSS returns the sum of the resident set size of the given
+// process and all of its descendants.
+func getProcessTreeRSS(rootPID int) (int64, error) {
+	entries, err := os.ReadDir(procRootPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read proc dir: %w", err)
+	}
+
+	children := map[int][]int{}
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(procRootPath, entry.Name(), "stat"))
+		if err != nil {
+			// The process could have exited in the meantime.
+			continue
+		}
+		// The command name is in parentheses and can contain spaces so we
+		// parse what comes after it: state, ppid, ...
+		stat := string(data)
+		idx := strings.LastIndexByte(stat, ')')
+		if idx < 0 {
+			continue
+		}
+		fields := strings.Fields(stat[idx+1:])
+		if len(fields) < 2 {
+			continue
+		}
+		ppid, err := strconv.Atoi(fields[1])
+		if err != nil {
+			continue
+		}
+		children[ppid] = append(children[ppid], pid)
+	}
+
+	var rss int64
+	pageSize := int64(os.Getpagesize())
+	queue := []int{rootPID}
+	for len(queue) > 0 {
+		pid := queue[0]
+		queue = queue[1:]
+		queue = append(queue, children[pid]...)
+
+		data, err := os.ReadFile(filepath.Join(procRootPath, strconv.Itoa(pid), "statm"))
+		if err != nil {
+			if pid == rootPID {
+				return 0, fmt.Errorf("failed to read process memory: %w", err)
+			}
+			continue
+		}
+		fields := strings.Fields(string(data))
+		if len(fields) < 2 {
+			continue
+		}
+		pages, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		rss += pages * pageSize
+	}
+
+	return rss, nil
+}
+
+func (m *resourceMonitor) sample(ctx context.Context) (resourceSample, error) {
+	s := resourceSample{
+		At: time.Now(),
+	}
+
+	var metrics []*performance.Metric
+	if err := chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+		var err error
+		metrics, err = performance.GetMetrics().Do(ctx)
+		return err
+	})); err != nil {
+		return s, fmt.Errorf("failed to get performance metrics: %w", err)
+	}
+
+	for _, metric := range metrics {
+		switch metric.Name {
+		case "JSHeapUsedSize":
+			s.JSHeapUsed = int64(metric.Value)
+		case "JSHeapTotalSize":
+			s.JSHeapTotal = int64(metric.Value)
+		case "Nodes":
+			s.Nodes = int64(metric.Value)
+		case "JSEventListeners":
+			s.Listeners = int64(metric.Value)
+		}
+	}
+
+	if c := chromedp.FromContext(ctx); c != nil && c.Browser != nil && c.Browser.Process() != nil {
+		rss, err := getProcessTreeRSS(c.Browser.Process().Pid)
+		if err != nil {
+			return s, fmt.Errorf("failed to get browser memory usage: %w", err)
+		}
+		s.RSS = rss
+	}
+
+	return s, nil
+}
+
+// check logs the given sample and returns whether the page should be
+// reloaded.
+func (m *resourceMonitor) check(s resourceSample) bool {
+	if m.first.At.IsZero() {
+		m.first = s
+	}
+
+	// Trends are relative to the first sample so that slow leaks are
+	// visible.
+	elapsed := s.At.Sub(m.first.At)
+	var rssRate float64
+	if elapsed >= time.Minute {
+		rssRate = float64(s.RSS-m.first.RSS) / 1024 / 1024 / elapsed.Minutes()
+	}
+
+	slog.Debug("browser resources",
+		slog.String("rss", fmt.Sprintf("%.1fMB", float64(s.RSS)/1024/1024)),
+		slog.String("rssTrend", fmt.Sprintf("%+.2fMB/min", rssRate)),
+		slog.String("jsHeapUsed", fmt.Sprintf("%.1fMB", float64(s.JSHeapUsed)/1024/1024)),
+		slog.String("jsHeapTotal", fmt.Sprintf("%.1fMB", float64(s.JSHeapTotal)/1024/1024)),
+		slog.Int64("nodes", s.Nodes),
+		slog.Int64("listeners", s.Listeners),
+	)
+
+	if m.memoryLimit == 0 || s.RSS < m.memoryLimit {
+		return false
+	}
+
+	if !m.lastReloadAt.IsZero() && s.At.Sub(m.lastReloadAt) < resourceMonitorReloadCooldown {
+		slog.Warn("browser memory above limit but page was recently reloaded",
+			slog.Int64("rss", s.RSS),
+			slog.Int64("limit", m.memoryLimit),
+		)
+		return false
+	}
+
+	slog.Warn("browser memory above limit, reloading page",
+		slog.Int64("rss", s.RSS),
+		slog.Int64("limit", m.memoryLimit),
+	)
+	m.lastReloadAt = s.At
+
+	return true
+}
+
+// run samples the browser resources until stopCh gets closed, requesting a
+// page reload whenever the memory limit is crossed.
+func (m *resourceMonitor) run(ctx context.Context, stopCh <-chan struct{}, reloadFn func()) {
+	if err := chromedp.Run(ctx, performance.Enable()); err != nil {
+		slog.Error("failed to enable performance metrics", slog.String("err", err.Error()))
+		return
+	}
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s, err := m.sample(ctx)
+			if err != nil {
+				slog.Error("failed to sample browser resources", slog.String("err", err.Error()))
+				continue
+			}
+			if m.check(s) {
+				reloadFn()
+			}
+		}
+	}
+}
+
+// requestReload asks the browser routine to reload the page.
+func (rec *Recorder) requestReload() {
+	select {
+	case rec.browserEventsCh <- BrowserEvent{Type: browserEventTypeReloadRequested, Timestamp: time.Now().UnixMilli()}:
+	default:
+		slog.Error("browser events queue is full, dropping reload request")
+	}
+}
+
+// reloadPage reloads the recording page and waits for the client to connect
+// again.
+func (rec *Recorder) reloadPage(ctx context.Context) error {
+	if err := chromedp.Run(ctx, chromedp.Reload()); err != nil {
+		return fmt.Errorf("failed to reload page: %w", err)
+	}
+
+	// Any close event from the previous client is expected and gets skipped
+	// while waiting for the new one.
+	if _, err := rec.waitForBrowserEvent(readyTimeout, BrowserEventTypeInit); err != nil {
+		return fmt.Errorf("failed to wait for client initialization: %w", err)
+	}
+
+	if ev, err := rec.waitForBrowserEvent(readyTimeout, BrowserEventTypeConnect, BrowserEventTypeClose); err != nil {
+		return fmt.Errorf("failed to wait for client to connect: %w", err)
+	} else if ev.Type == BrowserEventTypeClose {
+		return fmt.Errorf("client closed before connecting: %s", ev.Error)
+	}
+
+	slog.Info("page reloaded, client connected to call")
+
+	return nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetResourceMonitorConfig(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		t.Setenv("CHROMIUM_MONITOR_INTERVAL", "")
+		t.Setenv("CHROMIUM_MEMORY_LIMIT_MB", "")
+		interval, limit, err := getResourceMonitorConfig()
+		require.NoError(t, err)
+		require.Equal(t, resourceMonitorIntervalDefault, interval)
+		require.Zero(t, limit)
+	})
+
+	t.Run("custom", func(t *testing.T) {
+		t.Setenv("CHROMIUM_MONITOR_INTERVAL", "10s")
+		t.Setenv("CHROMIUM_MEMORY_LIMIT_MB", "2048")
+		interval, limit, err := getResourceMonitorConfig()
+		require.NoError(t, err)
+		require.Equal(t, 10*time.Second, interval)
+		require.Equal(t, int64(2048*1024*1024), limit)
+	})
+
+	t.Run("invalid interval", func(t *testing.T) {
+		t.Setenv("CHROMIUM_MONITOR_INTERVAL", "1ms")
+		_, _, err := getResourceMonitorConfig()
+		require.EqualError(t, err, "CHROMIUM_MONITOR_INTERVAL should be at least 1s")
+	})
+
+	t.Run("invalid limit", func(t *testing.T) {
+		t.Setenv("CHROMIUM_MONITOR_INTERVAL", "")
+		t.Setenv("CHROMIUM_MEMORY_LIMIT_MB", "-1")
+		_, _, err := getResourceMonitorConfig()
+		require.EqualError(t, err, "CHROMIUM_MEMORY_LIMIT_MB should not be negative")
+	})
+}
+
+func TestGetProcessTreeRSS(t *testing.T) {
+	defer func(path string) {
+		procRootPath = path
+	}(procRootPath)
+	procRootPath = t.TempDir()
+
+	writeProc := func(pid, ppid int, name string, rssPages int) {
+		dir := filepath.Join(procRootPath, strconv.Itoa(pid))
+		require.NoError(t, os.MkdirAll(dir, 0700))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "stat"),
+			[]byte(strconv.Itoa(pid)+" ("+name+") S "+strconv.Itoa(ppid)+" 1 1 0"), 0600))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "statm"),
+			[]byte("1000 "+strconv.Itoa(rssPages)+" 10 1 0 100 0"), 0600))
+	}
+
+	writeProc(1, 0, "init", 100)
+	writeProc(10, 1, "chromium", 10)
+	writeProc(11, 10, "chromium renderer", 20)
+	writeProc(12, 11, "chromium (gpu)", 30)
+	writeProc(20, 1, "ffmpeg", 1000)
+	require.NoError(t, os.MkdirAll(filepath.Join(procRootPath, "self"), 0700))
+
+	pageSize := int64(os.Getpagesize())
+
+	rss, err := getProcessTreeRSS(10)
+	require.NoError(t, err)
+	require.Equal(t, 60*pageSize, rss)
+
+	rss, err = getProcessTreeRSS(12)
+	require.NoError(t, err)
+	require.Equal(t, 30*pageSize, rss)
+
+	_, err = getProcessTreeRSS(30)
+	require.Error(t, err)
+}
+
+func TestResourceMonitorCheck(t *testing.T) {
+	m := newResourceMonitor(time.Second, 1000)
+
+	now := time.Now()
+	require.False(t, m.check(resourceSample{At: now, RSS: 500}))
+	require.True(t, m.check(resourceSample{At: now.Add(time.Minute), RSS: 1000}))
+	// cooldown
+	require.False(t, m.check(resourceSample{At: now.Add(2 * time.Minute), RSS: 2000}))
+	require.True(t, m.check(resourceSample{At: now.Add(time.Minute + resourceMonitorReloadCooldown), RSS: 2000}))
+
+	t.Run("no limit", func(t *testing.T) {
+		m := newResourceMonitor(time.Second, 0)
+		require.False(t, m.check(resourceSample{At: now, RSS: 1 << 40}))
+	})
+}
+
+func TestRequestReload(t *testing.T) {
+	rec := &Recorder{
+		stopCh:          make(chan struct{}),
+		browserEventsCh: make(chan BrowserEvent, browserEventsQueueSize),
+	}
+
+	rec.requestReload()
+	ev, err := rec.waitForBrowserEvent(time.Second, BrowserEventTypeClose, browserEventTypeReloadRequested)
+	require.NoError(t, err)
+	require.Equal(t, browserEventTypeReloadRequested, ev.Type)
+}
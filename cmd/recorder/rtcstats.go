@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	cruntime "github.com/chromedp/cdproto/runtime"
+	"github.com/chromedp/chromedp"
+)
+
+const (
+	rtcStatsIntervalDefault = 5 * time.Second
+	rtcStatsLogFreq         = time.Minute
+	rtcStatsFileSuffix      = "-webrtc-stats.jsonl"
+	rtcStatsGetExpr         = "window.callsRecorderGetRTCStats()"
+)
+
+//go:embed scripts/rtcstats.js
+var rtcStatsScript string
+
+// rtcInboundCounters are the cumulative inbound RTP statistics as returned
+// by the browser.
+type rtcInboundCounters struct {
+	BytesReceived   int64   `json:"bytes_received"`
+	PacketsReceived int64   `json:"packets_received"`
+	PacketsLost     int64   `json:"packets_lost"`
+	Jitter          float64 `json:"jitter"`
+	FramesDecoded   int64   `json:"frames_decoded"`
+	FramesDropped   int64   `json:"frames_dropped"`
+	AudioLevel      float64 `json:"audio_level"`
+}
+
+type rtcStatsReport struct {
+	Audio rtcInboundCounters `json:"audio"`
+	Video rtcInboundCounters `json:"video"`
+}
+
+// rtcInboundSample holds the statistics computed over a sampling interval.
+type rtcInboundSample struct {
+	// Bitrate in kbps.
+	Bitrate float64 `json:"bitrate"`
+	// Fraction of packets lost over the interval.
+	PacketLoss float64 `json:"packet_loss"`
+	// Jitter in seconds.
+	Jitter        float64 `json:"jitter"`
+	FramesDecoded int64   `json:"frames_decoded,omitempty"`
+	FramesDropped int64   `json:"frames_dropped,omitempty"`
+	AudioLevel    float64 `json:"audio_level,omitempty"`
+}
+
+type rtcStatsSample struct {
+	Timestamp int64            `json:"ts"`
+	Audio     rtcInboundSample `json:"audio"`
+	Video     rtcInboundSample `json:"video"`
+}
+
+func computeRTCInboundSample(prev, curr rtcInboundCounters, elapsed time.Duration) rtcInboundSample {
+	sample := rtcInboundSample{
+		Jitter:     curr.Jitter,
+		AudioLevel: curr.AudioLevel,
+	}
+
+	// Counters can go backwards if a track (or peer connection) goes away.
+	if curr.BytesReceived >= prev.BytesReceived && elapsed > 0 {
+		sample.Bitrate = float64(curr.BytesReceived-prev.BytesReceived) * 8 / 1000 / elapsed.Seconds()
+	}
+	if curr.FramesDecoded >= prev.FramesDecoded {
+		sample.FramesDecoded = curr.FramesDecoded - prev.FramesDecoded
+	}
+	if curr.FramesDropped >= prev.FramesDropped {
+		sample.FramesDropped = curr.FramesDropped - prev.FramesDropped
+	}
+
+	received := curr.PacketsReceived - prev.PacketsReceived
+	lost := curr.PacketsLost - prev.PacketsLost
+	if received >= 0 && lost > 0 {
+		sample.PacketLoss = float64(lost) / float64(received+lost)
+	}
+
+	return sample
+}
+
+// rtcStatsCollector periodically samples the WebRTC statistics of the call
+// connection and stores them as a time series (one JSON object per line).
+type rtcStatsCollector struct {
+	interval  time.Duration
+	path      string
+	lastLogAt time.Time
+
+	mut     sync.Mutex
+	file    *os.File
+	prev    rtcStatsReport
+	prevAt  time.Time
+	summary rtcStatsSummary
+}
+
+type rtcStatsSummary struct {
+	Samples          int
+	AudioBitrateSum  float64
+	VideoBitrateSum  float64
+	AudioPacketsLost int64
+	VideoPacketsLost int64
+	AudioMaxJitter   float64
+	VideoMaxJitter   float64
+	FramesDecoded    int64
+	FramesDropped    int64
+}
+
+func newRTCStatsCollector(path string, interval time.Duration) *rtcStatsCollector {
+	return &rtcStatsCollector{
+		interval: interval,
+		path:     path,
+	}
+}
+
+// getRTCStatsInterval returns the WebRTC statistics sampling interval from
+// the environment. A zero interval means collection is disabled.
+func getRTCStatsInterval() (time.Duration, error) {
+	val := os.Getenv("WEBRTC_STATS_INTERVAL")
+	if val == "" {
+		return rtcStatsIntervalDefault, nil
+	}
+
+	interval, err := time.ParseDuration(val)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse WEBRTC_STATS_INTERVAL: %w", err)
+	} else if interval < 0 || (interval > 0 && interval < time.Second) {
+		return 0, fmt.Errorf("WEBRTC_STATS_INTERVAL should be at least 1s")
+	}
+
+	return interval, nil
+}
+
+func (c *rtcStatsCollector) addReport(report rtcStatsReport, at time.Time) (rtcStatsSample, error) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	if c.prevAt.IsZero() {
+		// We need two reports to compute rates.
+		c.prev = report
+		c.prevAt = at
+		return rtcStatsSample{}, nil
+	}
+
+	elapsed := at.Sub(c.prevAt)
+	sample := rtcStatsSample{
+		Timestamp: at.UnixMilli(),
+		Audio:     computeRTCInboundSample(c.prev.Audio, report.Audio, elapsed),
+		Video:     computeRTCInboundSample(c.prev.Video, report.Video, elapsed),
+	}
+
+	c.summary.Samples++
+	c.summary.AudioBitrateSum += sample.Audio.Bitrate
+	c.summary.VideoBitrateSum += sample.Video.Bitrate
+	if lost := report.Audio.PacketsLost - c.prev.Audio.PacketsLost; lost > 0 {
+		c.summary.AudioPacketsLost += lost
+	}
+	if lost := report.Video.PacketsLost - c.prev.Video.PacketsLost; lost > 0 {
+		c.summary.VideoPacketsLost += lost
+	}
+	c.summary.AudioMaxJitter = max(c.summary.AudioMaxJitter, sample.Audio.Jitter)
+	c.summary.VideoMaxJitter = max(c.summary.VideoMaxJitter, sample.Video.Jitter)
+	c.summary.FramesDecoded += sample.Video.FramesDecoded
+	c.summary.FramesDropped += sample.Video.FramesDropped
+
+	c.prev = report
+	c.prevAt = at
+
+	if c.file == nil {
+		file, err := os.OpenFile(c.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			return sample, fmt.Errorf("failed to open stats file: %w", err)
+		}
+		c.file = file
+	}
+
+	data, err := json.Marshal(sample)
+	if err != nil {
+		return sample, fmt.Errorf("failed to marshal sample: %w", err)
+	}
+	if _, err := c.file.Write(append(data, '\n')); err != nil {
+		return sample, fmt.Errorf("failed to write sample: %w", err)
+	}
+
+	return sample, nil
+}
+
+func (c *rtcStatsCollector) logAttrs() []any {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	s := c.summary
+	if s.Samples == 0 {
+		return []any{slog.Int("samples", 0)}
+	}
+
+	return []any{
+		slog.Int("samples", s.Samples),
+		slog.String("audioAvgBitrate", fmt.Sprintf("%.1fkbps", s.AudioBitrateSum/float64(s.Samples))),
+		slog.String("videoAvgBitrate", fmt.Sprintf("%.1fkbps", s.VideoBitrateSum/float64(s.Samples))),
+		slog.Int64("audioPacketsLost", s.AudioPacketsLost),
+		slog.Int64("videoPacketsLost", s.VideoPacketsLost),
+		slog.String("audioMaxJitter", fmt.Sprintf("%.3fs", s.AudioMaxJitter)),
+		slog.String("videoMaxJitter", fmt.Sprintf("%.3fs", s.VideoMaxJitter)),
+		slog.Int64("framesDecoded", s.FramesDecoded),
+		slog.Int64("framesDropped", s.FramesDropped),
+	}
+}
+
+func (c *rtcStatsCollector) collect(ctx context.Context) error {
+	var res string
+	if err := chromedp.Run(ctx, chromedp.Evaluate(rtcStatsGetExpr, &res, func(p *cruntime.EvaluateParams) *cruntime.EvaluateParams {
+		return p.WithAwaitPromise(true)
+	})); err != nil {
+		return fmt.Errorf("failed to get stats: %w", err)
+	}
+
+	var report rtcStatsReport
+	if err := json.Unmarshal([]byte(res), &report); err != nil {
+		return fmt.Errorf("failed to unmarshal stats: %w", err)
+	}
+
+	now := time.Now()
+	sample, err := c.addReport(report, now)
+	if err != nil {
+		return err
+	}
+
+	if sample.Timestamp > 0 && now.Sub(c.lastLogAt) >= rtcStatsLogFreq {
+		c.lastLogAt = now
+		slog.Debug("webrtc stats",
+			slog.String("audioBitrate", fmt.Sprintf("%.1fkbps", sample.Audio.Bitrate)),
+			slog.String("videoBitrate", fmt.Sprintf("%.1fkbps", sample.Video.Bitrate)),
+			slog.Float64("audioPacketLoss", sample.Audio.PacketLoss),
+			slog.Float64("videoPacketLoss", sample.Video.PacketLoss),
+			slog.Float64("audioLevel", sample.Audio.AudioLevel),
+			slog.Int64("framesDropped", sample.Video.FramesDropped),
+		)
+	}
+
+	return nil
+}
+
+// run samples statistics until stopCh gets closed.
+func (c *rtcStatsCollector) run(ctx context.Context, stopCh <-chan struct{}) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.collect(ctx); err != nil {
+				slog.Error("failed to collect webrtc stats", slog.String("err", err.Error()))
+			}
+		}
+	}
+}
+
+func (c *rtcStatsCollector) close() error {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	if c.file == nil {
+		return nil
+	}
+
+	err := c.file.Close()
+	c.file = nil
+	return err
+}
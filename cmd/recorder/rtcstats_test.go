@@ -0,0 +1,109 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetRTCStatsInterval(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		t.Setenv("WEBRTC_STATS_INTERVAL", "")
+		interval, err := getRTCStatsInterval()
+		require.NoError(t, err)
+		require.Equal(t, rtcStatsIntervalDefault, interval)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		t.Setenv("WEBRTC_STATS_INTERVAL", "0")
+		interval, err := getRTCStatsInterval()
+		require.NoError(t, err)
+		require.Zero(t, interval)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		t.Setenv("WEBRTC_STATS_INTERVAL", "10ms")
+		_, err := getRTCStatsInterval()
+		require.EqualError(t, err, "WEBRTC_STATS_INTERVAL should be at least 1s")
+	})
+}
+
+func TestComputeRTCInboundSample(t *testing.T) {
+	prev := rtcInboundCounters{
+		BytesReceived:   1000,
+		PacketsReceived: 100,
+		PacketsLost:     0,
+		FramesDecoded:   30,
+		FramesDropped:   1,
+	}
+	curr := rtcInboundCounters{
+		BytesReceived:   126000,
+		PacketsReceived: 190,
+		PacketsLost:     10,
+		Jitter:          0.02,
+		FramesDecoded:   60,
+		FramesDropped:   3,
+		AudioLevel:      0.5,
+	}
+
+	require.Equal(t, rtcInboundSample{
+		Bitrate:       1000,
+		PacketLoss:    0.1,
+		Jitter:        0.02,
+		FramesDecoded: 30,
+		FramesDropped: 2,
+		AudioLevel:    0.5,
+	}, computeRTCInboundSample(prev, curr, time.Second))
+
+	t.Run("counters reset", func(t *testing.T) {
+		require.Equal(t, rtcInboundSample{}, computeRTCInboundSample(curr, rtcInboundCounters{}, time.Second))
+	})
+}
+
+func TestRTCStatsCollector(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "recording"+rtcStatsFileSuffix)
+	c := newRTCStatsCollector(path, time.Second)
+
+	now := time.UnixMilli(10000)
+	sample, err := c.addReport(rtcStatsReport{}, now)
+	require.NoError(t, err)
+	require.Zero(t, sample.Timestamp)
+	require.NoFileExists(t, path)
+
+	sample, err = c.addReport(rtcStatsReport{
+		Audio: rtcInboundCounters{BytesReceived: 8000, PacketsReceived: 50},
+		Video: rtcInboundCounters{BytesReceived: 125000, PacketsReceived: 90, PacketsLost: 10, FramesDecoded: 30},
+	}, now.Add(time.Second))
+	require.NoError(t, err)
+	require.Equal(t, int64(11000), sample.Timestamp)
+	require.Equal(t, float64(64), sample.Audio.Bitrate)
+	require.Equal(t, float64(1000), sample.Video.Bitrate)
+
+	_, err = c.addReport(rtcStatsReport{
+		Audio: rtcInboundCounters{BytesReceived: 16000, PacketsReceived: 100},
+		Video: rtcInboundCounters{BytesReceived: 250000, PacketsReceived: 180, PacketsLost: 10, FramesDecoded: 60, FramesDropped: 1},
+	}, now.Add(2*time.Second))
+	require.NoError(t, err)
+
+	require.NoError(t, c.close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 2)
+	require.Equal(t, `{"ts":11000,"audio":{"bitrate":64,"packet_loss":0,"jitter":0},"video":{"bitrate":1000,"packet_loss":0.1,"jitter":0,"frames_decoded":30}}`, lines[0])
+
+	require.Equal(t, rtcStatsSummary{
+		Samples:          2,
+		AudioBitrateSum:  128,
+		VideoBitrateSum:  2000,
+		VideoPacketsLost: 10,
+		FramesDecoded:    60,
+		FramesDropped:    1,
+	}, c.summary)
+	require.Len(t, c.logAttrs(), 9)
+}
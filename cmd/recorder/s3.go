@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	s3SignAlgorithm = "AWS4-HMAC-SHA256"
+	s3Service       = "s3"
+	s3RegionDefault = "us-east-1"
+	s3DateFormat    = "20060102T150405Z"
+	s3MaxErrorBody  = 64 * 1024
+)
+
+// Files larger than this are uploaded in parts of this size. S3 requires
+// parts (except the last one) to be at least 5MiB.
+var s3PartSize int64 = 64 * 1024 * 1024
+
+type s3Config struct {
+	// Endpoint is the base URL of the service (e.g. https://s3.amazonaws.com).
+	Endpoint        string
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	// PathStyle addresses the bucket as part of the path rather than as a
+	// subdomain, which is what most self-hosted services expect.
+	PathStyle bool
+}
+
+func (cfg s3Config) isValid() error {
+	if cfg.Endpoint == "" {
+		return fmt.Errorf("endpoint should not be empty")
+	}
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return fmt.Errorf("failed to parse endpoint: %w", err)
+	} else if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid endpoint %q", cfg.Endpoint)
+	}
+	if cfg.Bucket == "" {
+		return fmt.Errorf("bucket should not be empty")
+	}
+	if cfg.AccessKeyID == "" || cfg.SecretAccessKey == "" {
+		return fmt.Errorf("credentials should not be empty")
+	}
+	return nil
+}
+
+// s3Client is a minimal client for S3-compatible object storage, signing
+// requests with AWS Signature Version 4.
+type s3Client struct {
+	cfg        s3Config
+	endpoint   *url.URL
+	httpClient *http.Client
+	// overridable for testing
+	now func() time.Time
+}
+
+func newS3Client(cfg s3Config, httpClient *http.Client) (*s3Client, error) {
+	if cfg.Region == "" {
+		cfg.Region = s3RegionDefault
+	}
+	if err := cfg.isValid(); err != nil {
+		return nil, err
+	}
+
+	endpoint, _ := url.Parse(strings.TrimSuffix(cfg.Endpoint, "/"))
+
+	return &s3Client{
+		cfg:        cfg,
+		endpoint:   endpoint,
+		httpClient: httpClient,
+		now:        time.Now,
+	}, nil
+}
+
+// objectURL returns the URL of the object with the given key.
+func (c *s3Client) objectURL(key string) *url.URL {
+	u := *c.endpoint
+	if c.cfg.PathStyle {
+		u.Path = u.Path + "/" + c.cfg.Bucket + "/" + key
+	} else {
+		u.Host = c.cfg.Bucket + "." + u.Host
+		u.Path = u.Path + "/" + key
+	}
+	// Making sure the path gets sent escaped the same way it's signed.
+	u.RawPath = s3EncodePath(u.Path)
+	return &u
+}
+
+// s3EncodePath escapes the given path as required by the canonical request,
+// leaving the separators untouched.
+func s3EncodePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, s := range segments {
+		segments[i] = s3Escape(s)
+	}
+	return strings.Join(segments, "/")
+}
+
+// s3Escape escapes everything but unreserved characters.
+func s3Escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func s3CanonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		vals := append([]string{}, q[k]...)
+		sort.Strings(vals)
+		for _, v := range vals {
+			parts = append(parts, s3Escape(k)+"="+s3Escape(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// sign adds the authentication headers to the given request. All the headers
+// set on the request at this point are signed.
+func (c *s3Client) sign(req *http.Request, payloadHash string) {
+	now := c.now().UTC()
+	amzDate := now.Format(s3DateFormat)
+	date := amzDate[:8]
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{
+		"host": req.URL.Host,
+	}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3EncodePath(req.URL.Path),
+		s3CanonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := strings.Join([]string{date, c.cfg.Region, s3Service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{
+		s3SignAlgorithm,
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+c.cfg.SecretAccessKey), date)
+	key = hmacSHA256(key, c.cfg.Region)
+	key = hmacSHA256(key, s3Service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3SignAlgorithm, c.cfg.AccessKeyID, scope, signedHeaders, signature))
+}
+
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func parseS3Error(resp *http.Response) error {
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, s3MaxErrorBody))
+	var e s3Error
+	if err := xml.Unmarshal(data, &e); err != nil || e.Code == "" {
+		return fmt.Errorf("request failed with status code %d", resp.StatusCode)
+	}
+	return fmt.Errorf("request failed with status code %d: %s: %s", resp.StatusCode, e.Code, e.Message)
+}
+
+// do signs and sends a request for the given object. The body, if any, must
+// match the given hash.
+func (c *s3Client) do(ctx context.Context, method, key string, query url.Values, body io.Reader, size int64, payloadHash string) (*http.Response, error) {
+	u := c.objectURL(key)
+	u.RawQuery = s3CanonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.ContentLength = size
+	c.sign(req, payloadHash)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		return nil, parseS3Error(resp)
+	}
+
+	return resp, nil
+}
+
+// hashSection returns the SHA256 hash of the given section of the file.
+func hashSection(file *os.File, offset, size int64) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(file, offset, size)); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// putObject uploads the first size bytes of the file as a single object.
+func (c *s3Client) putObject(ctx context.Context, key string, file *os.File, size int64) error {
+	hash, err := hashSection(file, 0, size)
+	if err != nil {
+		return fmt.Errorf("failed to hash file: %w", err)
+	}
+
+	resp, err := c.do(ctx, http.MethodPut, key, nil, io.NewSectionReader(file, 0, size), size, hash)
+	if err != nil {
+		return fmt.Errorf("failed to put object: %w", err)
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+type s3CompletedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type s3CompleteMultipartUpload struct {
+	XMLName xml.Name          `xml:"CompleteMultipartUpload"`
+	Parts   []s3CompletedPart `xml:"Part"`
+}
+
+func (c *s3Client) createMultipartUpload(ctx context.Context, key string) (string, error) {
+	resp, err := c.do(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, nil, 0, sha256Hex(nil))
+	if err != nil {
+		return "", fmt.Errorf("failed to create multipart upload: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var res struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return "", fmt.Errorf("failed to decode response body: %w", err)
+	} else if res.UploadID == "" {
+		return "", fmt.Errorf("invalid empty upload ID")
+	}
+
+	return res.UploadID, nil
+}
+
+func (c *s3Client) uploadPart(ctx context.Context, key, uploadID string, partNumber int, file *os.File, offset, size int64) (string, error) {
+	hash, err := hashSection(file, offset, size)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash part: %w", err)
+	}
+
+	query := url.Values{
+		"partNumber": {strconv.Itoa(partNumber)},
+		"uploadId":   {uploadID},
+	}
+	resp, err := c.do(ctx, http.MethodPut, key, query, io.NewSectionReader(file, offset, size), size, hash)
+	if err != nil {
+		return "", fmt.Errorf("failed to upload part %d: %w", partNumber, err)
+	}
+	resp.Body.Close()
+
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		return "", fmt.Errorf("missing ETag for part %d", partNumber)
+	}
+
+	return etag, nil
+}
+
+func (c *s3Client) completeMultipartUpload(ctx context.Context, key, uploadID string, parts []s3CompletedPart) error {
+	payload, err := xml.Marshal(s3CompleteMultipartUpload{Parts: parts})
+	if err != nil {
+		return fmt.Errorf("failed to encode payload: %w", err)
+	}
+
+	resp, err := c.do(ctx, http.MethodPost, key, url.Values{"uploadId": {uploadID}},
+		bytes.NewReader(payload), int64(len(payload)), sha256Hex(payload))
+	if err != nil {
+		return fmt.Errorf("failed to complete multipart upload: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// The request can fail after the response status has been sent, in which
+	// case the error is found in the body.
+	data, err := io.ReadAll(io.LimitReader(resp.Body, s3MaxErrorBody))
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %w", err)
+	}
+	var e s3Error
+	if xml.Unmarshal(data, &e) == nil && e.Code != "" {
+		return fmt.Errorf("failed to complete multipart upload: %s: %s", e.Code, e.Message)
+	}
+
+	return nil
+}
+
+func (c *s3Client) abortMultipartUpload(ctx context.Context, key, uploadID string) error {
+	resp, err := c.do(ctx, http.MethodDelete, key, url.Values{"uploadId": {uploadID}}, nil, 0, sha256Hex(nil))
+	if err != nil {
+		return fmt.Errorf("failed to abort multipart upload: %w", err)
+	}
+	resp.Body.Close()
+	return nil
+}
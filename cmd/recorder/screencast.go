@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/chromedp"
+)
+
+const (
+	screencastQuality = 90
+)
+
+// screencaster receives the frames rendered by a headless browser through
+// CDP screencast events and feeds them to the transcoder at a constant
+// frame rate. Chromium only emits a new frame when the page changes, so the
+// latest frame is repeated as needed.
+type screencaster struct {
+	mut   sync.RWMutex
+	frame []byte
+}
+
+func newScreencaster() *screencaster {
+	return &screencaster{}
+}
+
+func (s *screencaster) start(ctx context.Context, width, height int) error {
+	return chromedp.Run(ctx, page.StartScreencast().
+		WithFormat(page.ScreencastFormatJpeg).
+		WithQuality(screencastQuality).
+		WithMaxWidth(int64(width)).
+		WithMaxHeight(int64(height)).
+		WithEveryNthFrame(1))
+}
+
+func (s *screencaster) handleFrame(ctx context.Context, ev *page.EventScreencastFrame) {
+	// Frames need to be acknowledged for the browser to keep sending them.
+	// This can't happen synchronously as we are being called from the
+	// event listener.
+	go func() {
+		if err := chromedp.Run(ctx, page.ScreencastFrameAck(ev.SessionID)); err != nil {
+			slog.Error("failed to ack screencast frame", slog.String("err", err.Error()))
+		}
+	}()
+
+	data, err := base64.StdEncoding.DecodeString(ev.Data)
+	if err != nil {
+		slog.Error("failed to decode screencast frame", slog.String("err", err.Error()))
+		return
+	}
+
+	s.mut.Lock()
+	s.frame = data
+	s.mut.Unlock()
+}
+
+func (s *screencaster) getFrame() []byte {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+	return s.frame
+}
+
+// pump writes the latest received frame to w at the given frame rate until
+// stopCh is closed or a write fails.
+func (s *screencaster) pump(w io.Writer, frameRate int, stopCh <-chan struct{}) error {
+	if frameRate <= 0 {
+		return fmt.Errorf("invalid frame rate %d", frameRate)
+	}
+
+	ticker := time.NewTicker(time.Second / time.Duration(frameRate))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopCh:
+			return nil
+		case <-ticker.C:
+			frame := s.getFrame()
+			if len(frame) == 0 {
+				continue
+			}
+			if _, err := w.Write(frame); err != nil {
+				return fmt.Errorf("failed to write frame: %w", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chromedp/cdproto/page"
+
+	"github.com/stretchr/testify/require"
+)
+
+type syncBuffer struct {
+	mut sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) Len() int {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	return b.buf.Len()
+}
+
+func TestScreencasterHandleFrame(t *testing.T) {
+	s := newScreencaster()
+
+	t.Run("invalid data", func(t *testing.T) {
+		s.handleFrame(context.Background(), &page.EventScreencastFrame{
+			Data: "invalid data",
+		})
+		require.Empty(t, s.getFrame())
+	})
+
+	t.Run("valid data", func(t *testing.T) {
+		s.handleFrame(context.Background(), &page.EventScreencastFrame{
+			Data:      base64.StdEncoding.EncodeToString([]byte("frame")),
+			SessionID: 1,
+		})
+		require.Equal(t, []byte("frame"), s.getFrame())
+	})
+}
+
+func TestScreencasterPump(t *testing.T) {
+	t.Run("invalid frame rate", func(t *testing.T) {
+		s := newScreencaster()
+		err := s.pump(&syncBuffer{}, 0, nil)
+		require.EqualError(t, err, "invalid frame rate 0")
+	})
+
+	t.Run("no frames", func(t *testing.T) {
+		s := newScreencaster()
+		var buf syncBuffer
+		stopCh := make(chan struct{})
+		time.AfterFunc(100*time.Millisecond, func() { close(stopCh) })
+		require.NoError(t, s.pump(&buf, 30, stopCh))
+		require.Zero(t, buf.Len())
+	})
+
+	t.Run("repeats latest frame", func(t *testing.T) {
+		s := newScreencaster()
+		s.frame = []byte("frame")
+		var buf syncBuffer
+		stopCh := make(chan struct{})
+		doneCh := make(chan error)
+		go func() {
+			doneCh <- s.pump(&buf, 30, stopCh)
+		}()
+		require.Eventually(t, func() bool {
+			return buf.Len() >= 3*len("frame")
+		}, time.Second, 10*time.Millisecond)
+		close(stopCh)
+		require.NoError(t, <-doneCh)
+	})
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/chromedp"
+)
+
+const (
+	screenshotsDirName         = "screenshots"
+	screenshotsMaxFilesDefault = 10
+	screenshotTimeout          = 5 * time.Second
+)
+
+// screenshotter periodically captures the rendered call page for debugging
+// purposes. Only the most recent maxFiles screenshots are kept on disk,
+// including any left over by a previous run of the same job. They are removed
+// once the recording is published and kept otherwise, so a failed job can
+// still be inspected.
+type screenshotter struct {
+	dir      string
+	interval time.Duration
+	maxFiles int
+
+	mut    sync.Mutex
+	files  []string
+	loaded bool
+}
+
+func newScreenshotter(dir string, interval time.Duration, maxFiles int) *screenshotter {
+	return &screenshotter{
+		dir:      dir,
+		interval: interval,
+		maxFiles: maxFiles,
+	}
+}
+
+// getScreenshotsConfig returns the screenshots settings from the environment.
+// A zero interval means screenshots are disabled.
+func getScreenshotsConfig() (time.Duration, int, error) {
+	val := os.Getenv("SCREENSHOTS_INTERVAL")
+	if val == "" {
+		return 0, 0, nil
+	}
+
+	interval, err := time.ParseDuration(val)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to parse SCREENSHOTS_INTERVAL: %w", err)
+	} else if interval < time.Second {
+		return 0, 0, fmt.Errorf("SCREENSHOTS_INTERVAL should be at least 1s")
+	}
+
+	maxFiles := screenshotsMaxFilesDefault
+	if val := os.Getenv("SCREENSHOTS_MAX"); val != "" {
+		maxFiles, err = strconv.Atoi(val)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to parse SCREENSHOTS_MAX: %w", err)
+		} else if maxFiles <= 0 {
+			return 0, 0, fmt.Errorf("SCREENSHOTS_MAX should be positive")
+		}
+	}
+
+	return interval, maxFiles, nil
+}
+
+// save writes the given image data to disk, removing the oldest
+// screenshots in excess.
+func (s *screenshotter) save(data []byte, label string, at time.Time) (string, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if !s.loaded {
+		if err := os.MkdirAll(s.dir, 0700); err != nil {
+			return "", fmt.Errorf("failed to create screenshots directory: %w", err)
+		}
+		if err := s.loadFiles(); err != nil {
+			return "", err
+		}
+		s.loaded = true
+	}
+
+	path := filepath.Join(s.dir, fmt.Sprintf("%d-%s.png", at.UnixMilli(), label))
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return "", fmt.Errorf("failed to write screenshot: %w", err)
+	}
+	s.files = append(s.files, path)
+
+	for len(s.files) > s.maxFiles {
+		if err := os.Remove(s.files[0]); err != nil {
+			slog.Error("failed to remove screenshot", slog.String("err", err.Error()))
+		}
+		s.files = s.files[1:]
+	}
+
+	return path, nil
+}
+
+// loadFiles picks up the screenshots already in the directory so that they
+// are rotated out along with the new ones. File names start with the capture
+// time, hence sorting them by name gives the oldest first.
+func (s *screenshotter) loadFiles() error {
+	matches, err := filepath.Glob(filepath.Join(s.dir, "*.png"))
+	if err != nil {
+		return fmt.Errorf("failed to list screenshots: %w", err)
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return screenshotTime(matches[i]) < screenshotTime(matches[j])
+	})
+	s.files = matches
+	return nil
+}
+
+func screenshotTime(path string) int64 {
+	prefix, _, _ := strings.Cut(filepath.Base(path), "-")
+	ms, _ := strconv.ParseInt(prefix, 10, 64)
+	return ms
+}
+
+// removeAll deletes the screenshots directory.
+func (s *screenshotter) removeAll() error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if err := os.RemoveAll(s.dir); err != nil {
+		return fmt.Errorf("failed to remove screenshots: %w", err)
+	}
+	s.files = nil
+	s.loaded = false
+
+	return nil
+}
+
+func (s *screenshotter) capture(ctx context.Context, label string) error {
+	if ctx == nil {
+		return fmt.Errorf("browser is not running")
+	}
+
+	tctx, cancel := context.WithTimeout(ctx, screenshotTimeout)
+	defer cancel()
+
+	var data []byte
+	if err := chromedp.Run(tctx, chromedp.ActionFunc(func(ctx context.Context) error {
+		var err error
+		data, err = page.CaptureScreenshot().WithFormat(page.CaptureScreenshotFormatPng).Do(ctx)
+		return err
+	})); err != nil {
+		return fmt.Errorf("failed to capture screenshot: %w", err)
+	}
+
+	path, err := s.save(data, label, time.Now())
+	if err != nil {
+		return err
+	}
+
+	slog.Debug("screenshot saved", slog.String("path", path))
+
+	return nil
+}
+
+// run takes a screenshot right away and then periodically until stopCh gets
+// closed.
+func (s *screenshotter) run(ctx context.Context, stopCh <-chan struct{}) {
+	if err := s.capture(ctx, "start"); err != nil {
+		slog.Error("failed to take screenshot", slog.String("err", err.Error()))
+	}
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.capture(ctx, "periodic"); err != nil {
+				slog.Error("failed to take screenshot", slog.String("err", err.Error()))
+			}
+		}
+	}
+}
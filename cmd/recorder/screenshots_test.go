@@ -0,0 +1,118 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetScreenshotsConfig(t *testing.T) {
+	tcs := []struct {
+		name     string
+		interval string
+		max      string
+		expected time.Duration
+		maxFiles int
+		err      string
+	}{
+		{
+			name: "disabled",
+		},
+		{
+			name:     "invalid interval",
+			interval: "invalid",
+			err:      `failed to parse SCREENSHOTS_INTERVAL: time: invalid duration "invalid"`,
+		},
+		{
+			name:     "interval too short",
+			interval: "100ms",
+			err:      "SCREENSHOTS_INTERVAL should be at least 1s",
+		},
+		{
+			name:     "invalid max",
+			interval: "30s",
+			max:      "0",
+			err:      "SCREENSHOTS_MAX should be positive",
+		},
+		{
+			name:     "defaults",
+			interval: "30s",
+			expected: 30 * time.Second,
+			maxFiles: screenshotsMaxFilesDefault,
+		},
+		{
+			name:     "custom max",
+			interval: "1m",
+			max:      "5",
+			expected: time.Minute,
+			maxFiles: 5,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("SCREENSHOTS_INTERVAL", tc.interval)
+			t.Setenv("SCREENSHOTS_MAX", tc.max)
+			interval, maxFiles, err := getScreenshotsConfig()
+			if tc.err != "" {
+				require.EqualError(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, interval)
+			require.Equal(t, tc.maxFiles, maxFiles)
+		})
+	}
+}
+
+func TestScreenshotterSave(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), screenshotsDirName)
+	s := newScreenshotter(dir, time.Second, 2)
+
+	now := time.UnixMilli(1000)
+	var paths []string
+	for i := 0; i < 3; i++ {
+		path, err := s.save([]byte("data"), "periodic", now.Add(time.Duration(i)*time.Second))
+		require.NoError(t, err)
+		paths = append(paths, path)
+	}
+
+	require.Equal(t, filepath.Join(dir, "1000-periodic.png"), paths[0])
+	require.NoFileExists(t, paths[0])
+	require.FileExists(t, paths[1])
+	require.FileExists(t, paths[2])
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+}
+
+func TestScreenshotterSaveExisting(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), screenshotsDirName)
+	require.NoError(t, os.MkdirAll(dir, 0700))
+
+	// Left over by a previous run of the same job.
+	for _, name := range []string{"9000-periodic.png", "10000-periodic.png"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("data"), 0600))
+	}
+
+	s := newScreenshotter(dir, time.Second, 2)
+	path, err := s.save([]byte("data"), "start", time.UnixMilli(11000))
+	require.NoError(t, err)
+
+	require.NoFileExists(t, filepath.Join(dir, "9000-periodic.png"))
+	require.FileExists(t, filepath.Join(dir, "10000-periodic.png"))
+	require.FileExists(t, path)
+
+	require.NoError(t, s.removeAll())
+	require.NoDirExists(t, dir)
+}
+
+func TestScreenshotterCapture(t *testing.T) {
+	s := newScreenshotter(t.TempDir(), time.Second, 2)
+	err := s.capture(nil, "failure")
+	require.EqualError(t, err, "browser is not running")
+}
@@ -0,0 +1,82 @@
+// This script is injected in the recording page before any other script runs.
+// It forwards the relevant window.callsClient events to the recorder through
+// the CDP binding exposed as window.callsRecorderEvent.
+(() => {
+    const binding = 'callsRecorderEvent';
+    const wsEventPrefix = 'custom_com.mattermost.calls_';
+    const wsEvents = {
+        user_joined: 'user_joined',
+        user_left: 'user_left',
+        user_screen_on: 'screen_on',
+        user_screen_off: 'screen_off',
+        user_voice_on: 'voice_on',
+        user_voice_off: 'voice_off',
+    };
+
+    const emit = (type, data = {}) => {
+        if (typeof window[binding] !== 'function') {
+            return;
+        }
+        try {
+            window[binding](JSON.stringify({...data, type, ts: Date.now()}));
+        } catch (err) {
+            console.error('failed to emit recorder event', err);
+        }
+    };
+
+    const errorToString = (err) => {
+        if (!err) {
+            return '';
+        }
+        return err.message || String(err);
+    };
+
+    const subscribe = (client) => {
+        client.on('connect', () => emit('connect'));
+        client.on('close', (err) => emit('close', {error: errorToString(err)}));
+        client.on('error', (err) => emit('error', {error: errorToString(err)}));
+
+        if (client.ws && typeof client.ws.on === 'function') {
+            client.ws.on('event', (msg) => {
+                if (!msg || typeof msg.event !== 'string' || !msg.event.startsWith(wsEventPrefix)) {
+                    return;
+                }
+                const type = wsEvents[msg.event.slice(wsEventPrefix.length)];
+                if (!type) {
+                    return;
+                }
+                const data = msg.data || {};
+                emit(type, {
+                    user_id: data.user_id || data.userID || '',
+                    session_id: data.session_id || data.sessionID || '',
+                });
+            });
+        }
+
+        emit('init');
+
+        // The client could have connected (or closed) before we subscribed.
+        if (client.closed) {
+            emit('close');
+        } else if (client.connected) {
+            emit('connect');
+        }
+    };
+
+    // Rather than polling for the client to be created we intercept the
+    // assignment to the global variable.
+    let callsClient;
+    Object.defineProperty(window, 'callsClient', {
+        configurable: true,
+        enumerable: true,
+        get() {
+            return callsClient;
+        },
+        set(client) {
+            callsClient = client;
+            if (client && typeof client.on === 'function') {
+                subscribe(client);
+            }
+        },
+    });
+})();
@@ -0,0 +1,55 @@
+// This script is injected in the recording page before any other script runs.
+// It keeps track of the peer connections created by the page so that the
+// recorder can periodically sample their statistics.
+(() => {
+    const peers = [];
+    const NativeRTCPeerConnection = window.RTCPeerConnection;
+    if (!NativeRTCPeerConnection) {
+        return;
+    }
+
+    class TrackedRTCPeerConnection extends NativeRTCPeerConnection {
+        constructor(...args) {
+            super(...args);
+            peers.push(this);
+        }
+    }
+    window.RTCPeerConnection = TrackedRTCPeerConnection;
+
+    const emptyStats = () => ({
+        bytes_received: 0,
+        packets_received: 0,
+        packets_lost: 0,
+        jitter: 0,
+        frames_decoded: 0,
+        frames_dropped: 0,
+        audio_level: 0,
+    });
+
+    // Returns the aggregated inbound RTP statistics, by kind, of all the
+    // peer connections that are still open.
+    window.callsRecorderGetRTCStats = async () => {
+        const res = {audio: emptyStats(), video: emptyStats()};
+        for (const pc of peers) {
+            if (pc.connectionState === 'closed') {
+                continue;
+            }
+            // eslint-disable-next-line no-await-in-loop
+            const stats = await pc.getStats();
+            stats.forEach((report) => {
+                if (report.type !== 'inbound-rtp' || !res[report.kind]) {
+                    return;
+                }
+                const s = res[report.kind];
+                s.bytes_received += report.bytesReceived || 0;
+                s.packets_received += report.packetsReceived || 0;
+                s.packets_lost += report.packetsLost || 0;
+                s.jitter = Math.max(s.jitter, report.jitter || 0);
+                s.frames_decoded += report.framesDecoded || 0;
+                s.frames_dropped += report.framesDropped || 0;
+                s.audio_level = Math.max(s.audio_level, report.audioLevel || 0);
+            });
+        }
+        return JSON.stringify(res);
+    };
+})();
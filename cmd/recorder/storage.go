@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/mattermost/calls-recorder/cmd/recorder/config"
+)
+
+const (
+	storageLocationsFileSuffix = "-locations.json"
+)
+
+// storageBackend is a place recording files get stored to.
+type storageBackend interface {
+	// target returns the name the backend is selected by.
+	target() config.StorageTarget
+	// store saves the file at the given path and returns where it can be
+	// found (e.g. a file ID or URL).
+	store(path string) (string, error)
+}
+
+// storageConfig holds the deployment wide settings of the storage backends.
+// Which ones are used is decided per job.
+type storageConfig struct {
+	S3        s3Config
+	S3Prefix  string
+	LocalPath string
+}
+
+// getStorageConfig returns the storage backends settings from the
+// environment.
+func getStorageConfig() (storageConfig, error) {
+	cfg := storageConfig{
+		S3: s3Config{
+			Endpoint:        os.Getenv("STORAGE_S3_ENDPOINT"),
+			Region:          os.Getenv("STORAGE_S3_REGION"),
+			Bucket:          os.Getenv("STORAGE_S3_BUCKET"),
+			AccessKeyID:     os.Getenv("STORAGE_S3_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("STORAGE_S3_SECRET_ACCESS_KEY"),
+		},
+		S3Prefix:  strings.Trim(os.Getenv("STORAGE_S3_PREFIX"), "/"),
+		LocalPath: os.Getenv("STORAGE_LOCAL_PATH"),
+	}
+
+	if val := os.Getenv("STORAGE_S3_PATH_STYLE"); val != "" {
+		pathStyle, err := strconv.ParseBool(val)
+		if err != nil {
+			return cfg, fmt.Errorf("failed to parse STORAGE_S3_PATH_STYLE: %w", err)
+		}
+		cfg.S3.PathStyle = pathStyle
+	}
+
+	if cfg.LocalPath != "" && !filepath.IsAbs(cfg.LocalPath) {
+		return cfg, fmt.Errorf("STORAGE_LOCAL_PATH should be an absolute path")
+	}
+
+	return cfg, nil
+}
+
+// newStorageBackends returns the backends for the given targets.
+func (rec *Recorder) newStorageBackends(targets []config.StorageTarget, cfg storageConfig, httpClient *http.Client) ([]storageBackend, error) {
+	backends := make([]storageBackend, 0, len(targets))
+	for _, t := range targets {
+		switch t {
+		case config.StorageTargetMattermost:
+			backends = append(backends, &mattermostStorage{rec: rec})
+		case config.StorageTargetS3:
+			client, err := newS3Client(cfg.S3, httpClient)
+			if err != nil {
+				return nil, fmt.Errorf("invalid s3 storage config: %w", err)
+			}
+			backends = append(backends, &s3Storage{
+				client: client,
+				prefix: path.Join(cfg.S3Prefix, rec.cfg.RecordingID),
+			})
+		case config.StorageTargetLocal:
+			if cfg.LocalPath == "" {
+				return nil, fmt.Errorf("local storage is not configured")
+			}
+			backends = append(backends, &localStorage{
+				dir: filepath.Join(cfg.LocalPath, rec.cfg.RecordingID),
+			})
+		default:
+			return nil, fmt.Errorf("unsupported storage target %q", t)
+		}
+	}
+	return backends, nil
+}
+
+// mattermostStorage uploads files to the channel of the call through the
+// plugin.
+type mattermostStorage struct {
+	rec *Recorder
+}
+
+func (s *mattermostStorage) target() config.StorageTarget {
+	return config.StorageTargetMattermost
+}
+
+func (s *mattermostStorage) store(path string) (string, error) {
+	return s.rec.uploadFile(path)
+}
+
+// s3Storage uploads files to an S3-compatible bucket.
+type s3Storage struct {
+	client *s3Client
+	// objects are stored under this prefix
+	prefix string
+
+	streamsMut sync.Mutex
+	// path -> upload of the files being streamed
+	streams map[string]*s3Stream
+}
+
+// s3Stream is a multipart upload of a file, possibly still being written.
+type s3Stream struct {
+	key      string
+	uploadID string
+	parts    []s3CompletedPart
+	// amount of data uploaded so far
+	offset int64
+}
+
+func (s *s3Storage) target() config.StorageTarget {
+	return config.StorageTargetS3
+}
+
+func (s *s3Storage) store(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return "", fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	key := path.Join(s.prefix, filepath.Base(filePath))
+
+	s.streamsMut.Lock()
+	stream := s.streams[filePath]
+	delete(s.streams, filePath)
+	s.streamsMut.Unlock()
+
+	if stream != nil && stream.offset > info.Size() {
+		slog.Warn("file shrunk since streaming started, starting over", slog.String("path", filePath))
+		s.abort(stream.key, stream.uploadID)
+		stream = nil
+	}
+
+	ctx, cancelCtx := context.WithTimeout(context.Background(), httpUploadTimeout)
+	defer cancelCtx()
+
+	if stream == nil {
+		if info.Size() <= s3PartSize {
+			if err := s.client.putObject(ctx, key, file, info.Size()); err != nil {
+				return "", err
+			}
+			return s.client.objectURL(key).String(), nil
+		}
+
+		uploadID, err := s.client.createMultipartUpload(ctx, key)
+		if err != nil {
+			return "", err
+		}
+		stream = &s3Stream{key: key, uploadID: uploadID}
+	} else {
+		slog.Debug("completing streamed upload",
+			slog.String("path", filePath),
+			slog.Int64("offset", stream.offset),
+			slog.Int64("size", info.Size()))
+	}
+
+	if err := s.uploadParts(file, stream, info.Size(), true); err != nil {
+		s.abort(stream.key, stream.uploadID)
+		return "", err
+	}
+
+	ctx, cancelCtx = context.WithTimeout(context.Background(), httpUploadTimeout)
+	defer cancelCtx()
+	if err := s.client.completeMultipartUpload(ctx, stream.key, stream.uploadID, stream.parts); err != nil {
+		s.abort(stream.key, stream.uploadID)
+		return "", err
+	}
+
+	return s.client.objectURL(stream.key).String(), nil
+}
+
+func (s *s3Storage) storePartial(filePath string, size int64) error {
+	s.streamsMut.Lock()
+	defer s.streamsMut.Unlock()
+
+	stream := s.streams[filePath]
+	if stream == nil {
+		// Small files are better off uploaded in a single request at the end.
+		if size < s3PartSize {
+			return nil
+		}
+
+		ctx, cancelCtx := context.WithTimeout(context.Background(), httpRequestTimeout)
+		defer cancelCtx()
+		key := path.Join(s.prefix, filepath.Base(filePath))
+		uploadID, err := s.client.createMultipartUpload(ctx, key)
+		if err != nil {
+			return err
+		}
+
+		stream = &s3Stream{key: key, uploadID: uploadID}
+		if s.streams == nil {
+			s.streams = map[string]*s3Stream{}
+		}
+		s.streams[filePath] = stream
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	return s.uploadParts(file, stream, size, false)
+}
+
+// uploadParts uploads the file data between what the stream has uploaded so
+// far and size. Unless last is set, only full parts are uploaded as S3
+// requires all but the last part to have a minimum size.
+func (s *s3Storage) uploadParts(file *os.File, stream *s3Stream, size int64, last bool) error {
+	for stream.offset < size {
+		partSize := min(s3PartSize, size-stream.offset)
+		if partSize < s3PartSize && !last {
+			break
+		}
+
+		// Large files can take a while so each part gets its own timeout.
+		ctx, cancelCtx := context.WithTimeout(context.Background(), httpUploadTimeout)
+		etag, err := s.client.uploadPart(ctx, stream.key, stream.uploadID, len(stream.parts)+1, file, stream.offset, partSize)
+		cancelCtx()
+		if err != nil {
+			return err
+		}
+
+		stream.parts = append(stream.parts, s3CompletedPart{PartNumber: len(stream.parts) + 1, ETag: etag})
+		stream.offset += partSize
+	}
+
+	return nil
+}
+
+// abort cancels the given multipart upload so that its parts don't linger
+// in the bucket.
+func (s *s3Storage) abort(key, uploadID string) {
+	ctx, cancelCtx := context.WithTimeout(context.Background(), httpRequestTimeout)
+	defer cancelCtx()
+	if err := s.client.abortMultipartUpload(ctx, key, uploadID); err != nil {
+		slog.Error("failed to abort multipart upload", slog.String("err", err.Error()))
+	}
+}
+
+// localStorage copies files to a local directory, usually a mounted volume.
+type localStorage struct {
+	dir string
+}
+
+func (s *localStorage) target() config.StorageTarget {
+	return config.StorageTargetLocal
+}
+
+func (s *localStorage) store(path string) (string, error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %w", err)
+	}
+	defer src.Close()
+
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	// Copying to a temporary file first so that a partial copy is never
+	// mistaken for a complete one.
+	dst, err := os.CreateTemp(s.dir, ".tmp-"+filepath.Base(path))
+	if err != nil {
+		return "", fmt.Errorf("failed to create file: %w", err)
+	}
+	defer os.Remove(dst.Name())
+	defer dst.Close()
+
+	if _, err := io.Copy(dst, src); err != nil {
+		return "", fmt.Errorf("failed to copy file: %w", err)
+	}
+	if err := dst.Sync(); err != nil {
+		return "", fmt.Errorf("failed to sync file: %w", err)
+	}
+	if err := dst.Close(); err != nil {
+		return "", fmt.Errorf("failed to close file: %w", err)
+	}
+
+	dstPath := filepath.Join(s.dir, filepath.Base(path))
+	if err := os.Rename(dst.Name(), dstPath); err != nil {
+		return "", fmt.Errorf("failed to rename file: %w", err)
+	}
+
+	return dstPath, nil
+}
+
+// storageLocation is where a copy of a file was stored.
+type storageLocation struct {
+	Target   config.StorageTarget `json:"target"`
+	Location string               `json:"location"`
+}
+
+type storageLocationsFile struct {
+	Name      string            `json:"name"`
+	Locations []storageLocation `json:"locations"`
+}
+
+// storageLocations lists where the files of a recording were stored outside
+// of Mattermost. It gets attached to the recording post.
+type storageLocations struct {
+	CallID      string                 `json:"call_id"`
+	RecordingID string                 `json:"recording_id"`
+	Files       []storageLocationsFile `json:"files"`
+}
+
+func (l storageLocations) writeJSON(path string) error {
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal locations: %w", err)
+	}
+	return os.WriteFile(path, data, 0600)
+}
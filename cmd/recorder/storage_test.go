@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mattermost/calls-recorder/cmd/recorder/config"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetStorageConfig(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		cfg, err := getStorageConfig()
+		require.NoError(t, err)
+		require.Equal(t, storageConfig{}, cfg)
+	})
+
+	t.Run("s3", func(t *testing.T) {
+		t.Setenv("STORAGE_S3_ENDPOINT", "http://minio:9000")
+		t.Setenv("STORAGE_S3_REGION", "eu-west-1")
+		t.Setenv("STORAGE_S3_BUCKET", "recordings")
+		t.Setenv("STORAGE_S3_ACCESS_KEY_ID", "key")
+		t.Setenv("STORAGE_S3_SECRET_ACCESS_KEY", "secret")
+		t.Setenv("STORAGE_S3_PREFIX", "/calls/")
+		t.Setenv("STORAGE_S3_PATH_STYLE", "true")
+		cfg, err := getStorageConfig()
+		require.NoError(t, err)
+		require.Equal(t, storageConfig{
+			S3: s3Config{
+				Endpoint:        "http://minio:9000",
+				Region:          "eu-west-1",
+				Bucket:          "recordings",
+				AccessKeyID:     "key",
+				SecretAccessKey: "secret",
+				PathStyle:       true,
+			},
+			S3Prefix: "calls",
+		}, cfg)
+	})
+
+	t.Run("invalid path style", func(t *testing.T) {
+		t.Setenv("STORAGE_S3_PATH_STYLE", "invalid")
+		_, err := getStorageConfig()
+		require.EqualError(t, err, `failed to parse STORAGE_S3_PATH_STYLE: strconv.ParseBool: parsing "invalid": invalid syntax`)
+	})
+
+	t.Run("relative local path", func(t *testing.T) {
+		t.Setenv("STORAGE_LOCAL_PATH", "recordings")
+		_, err := getStorageConfig()
+		require.EqualError(t, err, "STORAGE_LOCAL_PATH should be an absolute path")
+	})
+}
+
+func TestNewStorageBackends(t *testing.T) {
+	rec := &Recorder{
+		cfg: config.RecorderConfig{
+			RecordingID: "67t5u6cmtfbb7jug739d43xa9e",
+		},
+	}
+
+	t.Run("unconfigured s3", func(t *testing.T) {
+		_, err := rec.newStorageBackends([]config.StorageTarget{config.StorageTargetS3}, storageConfig{}, http.DefaultClient)
+		require.EqualError(t, err, "invalid s3 storage config: endpoint should not be empty")
+	})
+
+	t.Run("unconfigured local", func(t *testing.T) {
+		_, err := rec.newStorageBackends([]config.StorageTarget{config.StorageTargetLocal}, storageConfig{}, http.DefaultClient)
+		require.EqualError(t, err, "local storage is not configured")
+	})
+
+	t.Run("valid", func(t *testing.T) {
+		backends, err := rec.newStorageBackends([]config.StorageTarget{
+			config.StorageTargetMattermost,
+			config.StorageTargetS3,
+			config.StorageTargetLocal,
+		}, storageConfig{
+			S3: s3Config{
+				Endpoint:        "https://s3.amazonaws.com",
+				Bucket:          "recordings",
+				AccessKeyID:     "key",
+				SecretAccessKey: "secret",
+			},
+			S3Prefix:  "calls",
+			LocalPath: "/recordings",
+		}, http.DefaultClient)
+		require.NoError(t, err)
+		require.Len(t, backends, 3)
+		require.Equal(t, config.StorageTargetMattermost, backends[0].target())
+		require.Equal(t, "calls/67t5u6cmtfbb7jug739d43xa9e", backends[1].(*s3Storage).prefix)
+		require.Equal(t, "/recordings/67t5u6cmtfbb7jug739d43xa9e", backends[2].(*localStorage).dir)
+	})
+}
+
+func TestStorageHTTPClient(t *testing.T) {
+	t.Setenv("STORAGE_S3_ENDPOINT", "http://minio:9000")
+	t.Setenv("STORAGE_S3_BUCKET", "recordings")
+	t.Setenv("STORAGE_S3_ACCESS_KEY_ID", "key")
+	t.Setenv("STORAGE_S3_SECRET_ACCESS_KEY", "secret")
+
+	cfg := config.RecorderConfig{
+		SiteURL:        "http://localhost:8065",
+		CallID:         "8w8jorhr7j83uqr6y1st894hqe",
+		PostID:         "udzdsg7dwidbzcidx5khrf8nee",
+		RecordingID:    "67t5u6cmtfbb7jug739d43xa9e",
+		AuthToken:      "qj75unbsef83ik9p7ueypb6iyw",
+		StorageTargets: string(config.StorageTargetS3),
+	}
+	cfg.SetDefaults()
+	rec, err := NewRecorder(cfg, getDataDir(""))
+	require.NoError(t, err)
+	require.Len(t, rec.storage, 1)
+
+	// The Mattermost client's transport carries the site specific TLS
+	// and dialing settings, which must not apply to object storage.
+	siteTransport := rec.client.HTTPClient.Transport.(*clientTransport).transport
+	s3Transport := rec.storage[0].(*s3Storage).client.httpClient.Transport.(*clientTransport).transport
+	require.NotSame(t, siteTransport, s3Transport)
+}
+
+func TestLocalStorage(t *testing.T) {
+	srcPath := filepath.Join(t.TempDir(), "Call_2024-01-01.mp4")
+	require.NoError(t, os.WriteFile(srcPath, []byte("some recording content"), 0600))
+
+	storage := &localStorage{
+		dir: filepath.Join(t.TempDir(), "67t5u6cmtfbb7jug739d43xa9e"),
+	}
+
+	location, err := storage.store(srcPath)
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(storage.dir, "Call_2024-01-01.mp4"), location)
+
+	data, err := os.ReadFile(location)
+	require.NoError(t, err)
+	require.Equal(t, "some recording content", string(data))
+
+	// No temporary files should be left behind.
+	entries, err := os.ReadDir(storage.dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	t.Run("missing file", func(t *testing.T) {
+		_, err := storage.store(filepath.Join(t.TempDir(), "missing.mp4"))
+		require.ErrorContains(t, err, "failed to open file")
+	})
+}
+
+func TestStorageLocationsWriteJSON(t *testing.T) {
+	locations := storageLocations{
+		CallID:      "8w8jorhr7j83uqr6y1st894hqe",
+		RecordingID: "67t5u6cmtfbb7jug739d43xa9e",
+		Files: []storageLocationsFile{
+			{
+				Name: "Call_2024-01-01.mp4",
+				Locations: []storageLocation{
+					{Target: config.StorageTargetLocal, Location: "/recordings/67t5u6cmtfbb7jug739d43xa9e/Call_2024-01-01.mp4"},
+				},
+			},
+		},
+	}
+
+	path := filepath.Join(t.TempDir(), "locations.json")
+	require.NoError(t, locations.writeJSON(path))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var decoded storageLocations
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	require.Equal(t, locations, decoded)
+}
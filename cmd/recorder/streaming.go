@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+)
+
+const (
+	streamingUploadInterval = 10 * time.Second
+	// Fragments start at keyframes so that data, once written, never gets
+	// rewritten: the file only grows until the transcoder exits.
+	transcoderStreamingMovFlags = "+frag_keyframe+empty_moov+default_base_moof"
+)
+
+// streamingBackend is implemented by storage backends that can store a file
+// while it's still being written.
+type streamingBackend interface {
+	storageBackend
+	// storePartial stores the first size bytes of the file at the given
+	// path, which won't change anymore. The file gets completed by store
+	// once final.
+	storePartial(path string, size int64) error
+}
+
+// mp4CompleteSize returns the offset following the last complete top-level
+// box of the MP4 data of the given size. Scanning starts from offset, which
+// needs to be at a box boundary.
+func mp4CompleteSize(r io.ReaderAt, offset, size int64) (int64, error) {
+	hdr := make([]byte, 16)
+	for offset+8 <= size {
+		if n, err := r.ReadAt(hdr[:8], offset); n < 8 {
+			return offset, fmt.Errorf("failed to read box header: %w", err)
+		}
+
+		boxSize := int64(binary.BigEndian.Uint32(hdr[:4]))
+		switch {
+		case boxSize == 0:
+			// The box extends to the end of the file, which is only known
+			// once it's closed.
+			return offset, nil
+		case boxSize == 1:
+			if offset+16 > size {
+				return offset, nil
+			}
+			if n, err := r.ReadAt(hdr[8:16], offset+8); n < 8 {
+				return offset, fmt.Errorf("failed to read box header: %w", err)
+			}
+			boxSize = int64(binary.BigEndian.Uint64(hdr[8:16]))
+			if boxSize < 16 {
+				return offset, fmt.Errorf("invalid box size %d at offset %d", boxSize, offset)
+			}
+		case boxSize < 8:
+			return offset, fmt.Errorf("invalid box size %d at offset %d", boxSize, offset)
+		}
+
+		if offset+boxSize > size {
+			break
+		}
+		offset += boxSize
+	}
+
+	return offset, nil
+}
+
+// runStreamingUpload periodically stores the complete fragments of the
+// recording with the backends supporting it until stopCh gets closed.
+func (rec *Recorder) runStreamingUpload(stopCh <-chan struct{}) {
+	defer close(rec.streamingDoneCh)
+
+	ticker := time.NewTicker(streamingUploadInterval)
+	defer ticker.Stop()
+
+	var complete int64
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		}
+
+		var err error
+		complete, err = rec.streamRecording(complete)
+		if err != nil {
+			// Whatever is left gets uploaded once the recording ends.
+			slog.Warn("failed to stream recording", slog.String("err", err.Error()))
+		}
+	}
+}
+
+// streamRecording stores the data of the recording file up to its last
+// complete box, scanning from the given offset. It returns where the next
+// scan should start.
+func (rec *Recorder) streamRecording(offset int64) (int64, error) {
+	file, err := os.Open(rec.outPath)
+	if errors.Is(err, os.ErrNotExist) {
+		// Not written yet.
+		return offset, nil
+	} else if err != nil {
+		return offset, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return offset, fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	complete, err := mp4CompleteSize(file, offset, info.Size())
+	if err != nil {
+		return offset, fmt.Errorf("failed to parse file: %w", err)
+	}
+
+	for _, backend := range rec.storage {
+		sb, ok := backend.(streamingBackend)
+		if !ok {
+			continue
+		}
+		if err := sb.storePartial(rec.outPath, complete); err != nil {
+			return complete, fmt.Errorf("failed to store to %s: %w", sb.target(), err)
+		}
+	}
+
+	return complete, nil
+}
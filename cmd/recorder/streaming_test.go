@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func mp4Box(boxType string, payloadSize int) []byte {
+	box := make([]byte, 8+payloadSize)
+	binary.BigEndian.PutUint32(box, uint32(len(box)))
+	copy(box[4:], boxType)
+	return box
+}
+
+func mp4LargeBox(boxType string, payloadSize int) []byte {
+	box := make([]byte, 16+payloadSize)
+	binary.BigEndian.PutUint32(box, 1)
+	copy(box[4:], boxType)
+	binary.BigEndian.PutUint64(box[8:], uint64(len(box)))
+	return box
+}
+
+func TestMP4CompleteSize(t *testing.T) {
+	ftyp := mp4Box("ftyp", 24)
+	moov := mp4Box("moov", 100)
+	moof := mp4Box("moof", 50)
+	mdat := mp4LargeBox("mdat", 200)
+	data := bytes.Join([][]byte{ftyp, moov, moof, mdat}, nil)
+
+	tcs := []struct {
+		name     string
+		offset   int64
+		size     int64
+		expected int64
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name:     "partial header",
+			size:     4,
+			expected: 0,
+		},
+		{
+			name:     "partial box",
+			size:     int64(len(ftyp) + 10),
+			expected: int64(len(ftyp)),
+		},
+		{
+			name:     "partial large box",
+			size:     int64(len(data) - 1),
+			expected: int64(len(ftyp) + len(moov) + len(moof)),
+		},
+		{
+			name:     "partial large box header",
+			size:     int64(len(ftyp) + len(moov) + len(moof) + 12),
+			expected: int64(len(ftyp) + len(moov) + len(moof)),
+		},
+		{
+			name:     "complete",
+			size:     int64(len(data)),
+			expected: int64(len(data)),
+		},
+		{
+			name:     "from offset",
+			offset:   int64(len(ftyp) + len(moov)),
+			size:     int64(len(data)),
+			expected: int64(len(data)),
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			size, err := mp4CompleteSize(bytes.NewReader(data[:tc.size]), tc.offset, tc.size)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, size)
+		})
+	}
+
+	t.Run("box extending to the end", func(t *testing.T) {
+		box := mp4Box("mdat", 10)
+		binary.BigEndian.PutUint32(box, 0)
+		data := append(append([]byte{}, ftyp...), box...)
+		size, err := mp4CompleteSize(bytes.NewReader(data), 0, int64(len(data)))
+		require.NoError(t, err)
+		require.Equal(t, int64(len(ftyp)), size)
+	})
+
+	t.Run("invalid box size", func(t *testing.T) {
+		box := mp4Box("mdat", 10)
+		binary.BigEndian.PutUint32(box, 4)
+		_, err := mp4CompleteSize(bytes.NewReader(box), 0, int64(len(box)))
+		require.EqualError(t, err, "invalid box size 4 at offset 0")
+	})
+}
+
+func TestStreamRecording(t *testing.T) {
+	srv := newTestS3Server(t)
+	storage := &s3Storage{
+		client: srv.client,
+		prefix: "67t5u6cmtfbb7jug739d43xa9e",
+	}
+
+	defaultPartSize := s3PartSize
+	s3PartSize = 64
+	defer func() { s3PartSize = defaultPartSize }()
+
+	rec := &Recorder{
+		outPath: filepath.Join(t.TempDir(), "recording.mp4"),
+		storage: []storageBackend{&mattermostStorage{}, storage},
+	}
+
+	t.Run("missing file", func(t *testing.T) {
+		offset, err := rec.streamRecording(0)
+		require.NoError(t, err)
+		require.Zero(t, offset)
+		require.Empty(t, srv.uploads)
+	})
+
+	file, err := os.Create(rec.outPath)
+	require.NoError(t, err)
+	defer file.Close()
+
+	var data []byte
+	write := func(b []byte) {
+		t.Helper()
+		_, err := file.Write(b)
+		require.NoError(t, err)
+		data = append(data, b...)
+	}
+
+	t.Run("below part size", func(t *testing.T) {
+		write(mp4Box("ftyp", 24))
+		offset, err := rec.streamRecording(0)
+		require.NoError(t, err)
+		require.Equal(t, int64(32), offset)
+		require.Empty(t, srv.uploads)
+	})
+
+	t.Run("complete fragments", func(t *testing.T) {
+		write(mp4Box("moov", 100))
+		write(mp4Box("moof", 50))
+		// Partially written box.
+		mdat := mp4Box("mdat", 100)
+		write(mdat[:50])
+
+		offset, err := rec.streamRecording(32)
+		require.NoError(t, err)
+		require.Equal(t, int64(32+108+58), offset)
+
+		// Only full parts of the complete data get uploaded.
+		require.Len(t, srv.uploads, 1)
+		for _, parts := range srv.uploads {
+			require.Len(t, parts, 3)
+		}
+		require.Equal(t, int64(3*64), storage.streams[rec.outPath].offset)
+
+		write(mdat[50:])
+	})
+
+	t.Run("tail", func(t *testing.T) {
+		location, err := storage.store(rec.outPath)
+		require.NoError(t, err)
+		require.Equal(t, srv.URL+"/recordings/67t5u6cmtfbb7jug739d43xa9e/recording.mp4", location)
+		require.Equal(t, data, srv.objects["67t5u6cmtfbb7jug739d43xa9e/recording.mp4"])
+		require.Empty(t, srv.uploads)
+		require.Empty(t, storage.streams)
+	})
+}
@@ -0,0 +1,617 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	processStopTimeoutDefault       = 5 * time.Second
+	processKillTimeout              = 2 * time.Second
+	processRestartDelayDefault      = time.Second
+	processLivenessThresholdDefault = 3
+	processGroupPollInterval        = 50 * time.Millisecond
+	supervisorReapInterval          = 2 * time.Second
+)
+
+type restartPolicy string
+
+const (
+	restartPolicyNever     restartPolicy = "never"
+	restartPolicyOnFailure restartPolicy = "on-failure"
+	restartPolicyAlways    restartPolicy = "always"
+)
+
+// processSpec describes how a child process should be run and supervised.
+type processSpec struct {
+	Name string
+	Cmd  string
+	Args string
+	// Stdin makes the standard input of the process available for writing.
+	// Processes with a writable input cannot be restarted.
+	Stdin bool
+	// StdoutHandler, if set, gets passed the standard output of the process
+	// (on every run) instead of it being logged.
+	StdoutHandler func(r io.Reader)
+	// Optional processes are not required for the recording to work, so
+	// their failures are not fatal.
+	Optional bool
+
+	Restart     restartPolicy
+	MaxRestarts int
+	// RestartDelay is how long to wait before restarting a process.
+	RestartDelay time.Duration
+
+	// StopSignal is sent to the process group on stop. Defaults to SIGTERM.
+	StopSignal syscall.Signal
+	// StopTimeout is how long to wait after StopSignal before escalating to
+	// SIGKILL.
+	StopTimeout time.Duration
+
+	// LivenessProbe, if set, gets called every LivenessInterval. A process
+	// failing LivenessThreshold consecutive probes gets killed.
+	LivenessProbe     func() error
+	LivenessInterval  time.Duration
+	LivenessThreshold int
+}
+
+func (s *processSpec) setDefaults() {
+	if s.Restart == "" {
+		s.Restart = restartPolicyNever
+	}
+	if s.RestartDelay == 0 {
+		s.RestartDelay = processRestartDelayDefault
+	}
+	if s.StopSignal == 0 {
+		s.StopSignal = syscall.SIGTERM
+	}
+	if s.StopTimeout == 0 {
+		s.StopTimeout = processStopTimeoutDefault
+	}
+	if s.LivenessThreshold == 0 {
+		s.LivenessThreshold = processLivenessThresholdDefault
+	}
+}
+
+func (s processSpec) isValid() error {
+	if s.Name == "" {
+		return fmt.Errorf("invalid empty name")
+	}
+	switch s.Restart {
+	case restartPolicyNever, restartPolicyOnFailure, restartPolicyAlways:
+	default:
+		return fmt.Errorf("invalid restart policy %q", s.Restart)
+	}
+	if s.Stdin && s.Restart != restartPolicyNever {
+		return fmt.Errorf("processes with stdin cannot be restarted")
+	}
+	if s.LivenessProbe != nil && s.LivenessInterval <= 0 {
+		return fmt.Errorf("liveness interval should be positive")
+	}
+	return nil
+}
+
+// processExit describes the termination of a supervised process.
+type processExit struct {
+	Name string
+	PID  int
+	// Err is nil when the process exited successfully.
+	Err      error
+	Reason   string
+	Optional bool
+	// Expected is true when the process exited as a result of being stopped.
+	Expected bool
+	// Restarting is true when the process is going to be restarted.
+	Restarting bool
+}
+
+// processExitReason returns a human readable description of how a process
+// terminated given the error returned by Wait.
+func processExitReason(err error) string {
+	if err == nil {
+		return "exited successfully"
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return fmt.Sprintf("killed by signal %s", status.Signal())
+		}
+		return fmt.Sprintf("exited with code %d", exitErr.ExitCode())
+	}
+
+	return err.Error()
+}
+
+// killProcessGroup sends sig to every process in the given group. Missing
+// groups are not considered an error.
+func killProcessGroup(pgid int, sig syscall.Signal) error {
+	if pgid <= 0 {
+		return fmt.Errorf("invalid process group %d", pgid)
+	}
+	if err := syscall.Kill(-pgid, sig); err != nil && !errors.Is(err, syscall.ESRCH) {
+		return err
+	}
+	return nil
+}
+
+func isProcessGroupAlive(pgid int) bool {
+	err := syscall.Kill(-pgid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+type supervisedProcess struct {
+	spec processSpec
+	sup  *supervisor
+
+	mut      sync.Mutex
+	cmd      *exec.Cmd
+	stdin    io.WriteCloser
+	restarts int
+	stopping bool
+	// set when the process was killed because of failing liveness probes
+	unhealthyErr error
+	exitErr      error
+
+	stopOnce sync.Once
+	stopCh   chan struct{}
+	// closed when the process has exited for good
+	doneCh chan struct{}
+}
+
+func (p *supervisedProcess) pid() int {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	if p.cmd == nil || p.cmd.Process == nil {
+		return 0
+	}
+	return p.cmd.Process.Pid
+}
+
+// input returns the writer connected to the standard input of the process.
+func (p *supervisedProcess) input() io.WriteCloser {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	return p.stdin
+}
+
+// done returns a channel that gets closed when the process has exited and
+// won't be restarted.
+func (p *supervisedProcess) done() <-chan struct{} {
+	return p.doneCh
+}
+
+// err returns the error the process last exited with. It should only be
+// called after done() is closed.
+func (p *supervisedProcess) err() error {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	return p.exitErr
+}
+
+func (p *supervisedProcess) startCmd() error {
+	slog.Debug("running cmd", slog.String("cmd", p.spec.Cmd), slog.String("args", p.spec.Args))
+	cmd := exec.Command(p.spec.Cmd, strings.Split(p.spec.Args, " ")...)
+	setProcessAttrs(cmd)
+
+	var stdin io.WriteCloser
+	if p.spec.Stdin {
+		var err error
+		stdin, err = cmd.StdinPipe()
+		if err != nil {
+			return err
+		}
+	}
+
+	// Not using cmd.StdoutPipe() since Wait closes it, possibly before the
+	// handler is done reading.
+	var stdoutR, stdoutW *os.File
+	if p.spec.StdoutHandler != nil {
+		var err error
+		stdoutR, stdoutW, err = os.Pipe()
+		if err != nil {
+			return fmt.Errorf("failed to create stdout pipe: %w", err)
+		}
+		cmd.Stdout = stdoutW
+	}
+
+	if err := startCmd(cmd); err != nil {
+		if stdoutR != nil {
+			stdoutR.Close()
+			stdoutW.Close()
+		}
+		return err
+	}
+
+	if stdoutR != nil {
+		// The child holds its own copy of the write end.
+		stdoutW.Close()
+		go func() {
+			defer stdoutR.Close()
+			p.spec.StdoutHandler(stdoutR)
+		}()
+	}
+
+	p.mut.Lock()
+	p.cmd = cmd
+	p.stdin = stdin
+	p.unhealthyErr = nil
+	p.mut.Unlock()
+
+	return nil
+}
+
+func (p *supervisedProcess) shouldRestart(err error) bool {
+	switch p.spec.Restart {
+	case restartPolicyAlways:
+	case restartPolicyOnFailure:
+		if err == nil {
+			return false
+		}
+	default:
+		return false
+	}
+	return p.spec.MaxRestarts <= 0 || p.restarts < p.spec.MaxRestarts
+}
+
+// monitor runs the liveness probe until the given process exits.
+func (p *supervisedProcess) monitor(cmd *exec.Cmd, exitedCh <-chan struct{}) {
+	ticker := time.NewTicker(p.spec.LivenessInterval)
+	defer ticker.Stop()
+
+	var failures int
+	for {
+		select {
+		case <-exitedCh:
+			return
+		case <-ticker.C:
+		}
+
+		err := p.spec.LivenessProbe()
+		if err == nil {
+			failures = 0
+			continue
+		}
+
+		failures++
+		slog.Warn("liveness probe failed",
+			slog.String("name", p.spec.Name),
+			slog.Int("failures", failures),
+			slog.String("err", err.Error()),
+		)
+		if failures < p.spec.LivenessThreshold {
+			continue
+		}
+
+		p.mut.Lock()
+		p.unhealthyErr = fmt.Errorf("liveness probe failed: %w", err)
+		p.mut.Unlock()
+		if err := killProcessGroup(cmd.Process.Pid, syscall.SIGKILL); err != nil {
+			slog.Error("failed to kill process group", slog.String("name", p.spec.Name), slog.String("err", err.Error()))
+		}
+		return
+	}
+}
+
+// run waits for the process to exit, reporting it and restarting it if
+// needed.
+func (p *supervisedProcess) run() {
+	defer close(p.doneCh)
+
+	for {
+		p.mut.Lock()
+		cmd := p.cmd
+		p.mut.Unlock()
+
+		exitedCh := make(chan struct{})
+		if p.spec.LivenessProbe != nil {
+			go p.monitor(cmd, exitedCh)
+		}
+
+		err := cmd.Wait()
+		close(exitedCh)
+
+		// Helpers left behind by the process are not needed anymore.
+		if killErr := killProcessGroup(cmd.Process.Pid, syscall.SIGKILL); killErr != nil {
+			slog.Error("failed to kill process group", slog.String("name", p.spec.Name), slog.String("err", killErr.Error()))
+		}
+
+		p.mut.Lock()
+		exit := processExit{
+			Name:     p.spec.Name,
+			PID:      cmd.Process.Pid,
+			Err:      err,
+			Reason:   processExitReason(err),
+			Optional: p.spec.Optional,
+			Expected: p.stopping,
+		}
+		if p.unhealthyErr != nil {
+			exit.Reason = p.unhealthyErr.Error()
+			if exit.Err == nil {
+				exit.Err = p.unhealthyErr
+			}
+		}
+		exit.Restarting = !p.stopping && p.shouldRestart(exit.Err)
+		if exit.Restarting {
+			p.restarts++
+		}
+		p.exitErr = err
+		p.mut.Unlock()
+
+		p.sup.reportExit(exit)
+
+		if !exit.Restarting {
+			return
+		}
+
+		select {
+		case <-p.stopCh:
+			return
+		case <-time.After(p.spec.RestartDelay):
+		}
+
+		if err := p.startCmd(); err != nil {
+			slog.Error("failed to restart process", slog.String("name", p.spec.Name), slog.String("err", err.Error()))
+			p.mut.Lock()
+			p.exitErr = err
+			p.mut.Unlock()
+			p.sup.reportExit(processExit{
+				Name:     p.spec.Name,
+				Err:      err,
+				Reason:   fmt.Sprintf("failed to restart: %s", err.Error()),
+				Optional: p.spec.Optional,
+			})
+			return
+		}
+	}
+}
+
+// stop gracefully terminates the process group, escalating to SIGKILL if it
+// doesn't exit in time. The error the process exited with can be retrieved
+// through err().
+func (p *supervisedProcess) stop() error {
+	p.mut.Lock()
+	p.stopping = true
+	cmd := p.cmd
+	p.mut.Unlock()
+
+	p.stopOnce.Do(func() {
+		close(p.stopCh)
+	})
+
+	select {
+	case <-p.doneCh:
+		return nil
+	default:
+	}
+
+	pgid := cmd.Process.Pid
+	if err := killProcessGroup(pgid, p.spec.StopSignal); err != nil {
+		return fmt.Errorf("failed to signal process group: %w", err)
+	}
+
+	select {
+	case <-p.doneCh:
+		return nil
+	case <-time.After(p.spec.StopTimeout):
+	}
+
+	slog.Warn("process did not exit in time, killing it", slog.String("name", p.spec.Name))
+	if err := killProcessGroup(pgid, syscall.SIGKILL); err != nil {
+		return fmt.Errorf("failed to kill process group: %w", err)
+	}
+
+	select {
+	case <-p.doneCh:
+		return nil
+	case <-time.After(processKillTimeout):
+		return fmt.Errorf("timed out waiting for process to exit")
+	}
+}
+
+// externalGroup is a process group started outside of the supervisor (e.g.
+// by chromedp) which still needs to be cleaned up on stop.
+type externalGroup struct {
+	name string
+	pgid int
+}
+
+func (g externalGroup) stop(timeout time.Duration, reap func()) error {
+	pgid := g.pgid
+
+	wait := func(timeout time.Duration) bool {
+		deadline := time.Now().Add(timeout)
+		for time.Now().Before(deadline) {
+			reap()
+			if !isProcessGroupAlive(pgid) {
+				return true
+			}
+			time.Sleep(processGroupPollInterval)
+		}
+		return false
+	}
+
+	if !isProcessGroupAlive(pgid) {
+		return nil
+	}
+
+	if err := killProcessGroup(pgid, syscall.SIGTERM); err != nil {
+		return fmt.Errorf("failed to signal process group: %w", err)
+	}
+	if wait(timeout) {
+		return nil
+	}
+
+	slog.Warn("process group did not exit in time, killing it", slog.String("name", g.name))
+	if err := killProcessGroup(pgid, syscall.SIGKILL); err != nil {
+		return fmt.Errorf("failed to kill process group: %w", err)
+	}
+	if !wait(processKillTimeout) {
+		return fmt.Errorf("timed out waiting for process group to exit")
+	}
+
+	return nil
+}
+
+// supervisor owns the child processes of a recorder. Processes are started in
+// their own group so that any helper they spawn can be terminated along with
+// them.
+type supervisor struct {
+	onExit func(processExit)
+
+	mut sync.Mutex
+	// processes in start order
+	procs  []*supervisedProcess
+	groups []externalGroup
+
+	reaperStopCh chan struct{}
+	reaperDoneCh chan struct{}
+	stopOnce     sync.Once
+}
+
+func newSupervisor(onExit func(processExit)) *supervisor {
+	s := &supervisor{
+		onExit:       onExit,
+		reaperStopCh: make(chan struct{}),
+		reaperDoneCh: make(chan struct{}),
+	}
+
+	go s.reaper()
+
+	return s
+}
+
+func (s *supervisor) reportExit(exit processExit) {
+	if s.onExit != nil {
+		s.onExit(exit)
+	}
+}
+
+// start runs a new process according to the given spec.
+func (s *supervisor) start(spec processSpec) (*supervisedProcess, error) {
+	spec.setDefaults()
+	if err := spec.isValid(); err != nil {
+		return nil, fmt.Errorf("invalid process spec: %w", err)
+	}
+
+	p := &supervisedProcess{
+		spec:   spec,
+		sup:    s,
+		stopCh: make(chan struct{}),
+		doneCh: make(chan struct{}),
+	}
+
+	if err := p.startCmd(); err != nil {
+		return nil, err
+	}
+
+	s.mut.Lock()
+	s.procs = append(s.procs, p)
+	s.mut.Unlock()
+
+	go p.run()
+
+	return p, nil
+}
+
+// trackGroup registers a process group started elsewhere so that it gets
+// terminated on stop. The process should have been started with
+// setProcessAttrs.
+func (s *supervisor) trackGroup(name string, pgid int) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	for _, g := range s.groups {
+		if g.pgid == pgid {
+			return
+		}
+	}
+	s.groups = append(s.groups, externalGroup{name: name, pgid: pgid})
+}
+
+// trackedGroups returns the IDs of all the process groups owned by the
+// supervisor.
+func (s *supervisor) trackedGroups() map[int]bool {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	groups := make(map[int]bool, len(s.procs)+len(s.groups))
+	for _, p := range s.procs {
+		if pid := p.pid(); pid > 0 {
+			groups[pid] = true
+		}
+	}
+	for _, g := range s.groups {
+		groups[g.pgid] = true
+	}
+
+	return groups
+}
+
+func (s *supervisor) reap() {
+	for _, pid := range reapOrphans(s.trackedGroups()) {
+		slog.Debug("reaped orphaned process", slog.Int("pid", pid))
+	}
+}
+
+// reaper periodically collects the orphaned processes belonging to the
+// supervised groups which were reparented to us.
+func (s *supervisor) reaper() {
+	defer close(s.reaperDoneCh)
+
+	ticker := time.NewTicker(supervisorReapInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.reaperStopCh:
+			return
+		case <-ticker.C:
+			s.reap()
+		}
+	}
+}
+
+// stopAll terminates every process, in reverse start order, and stops the
+// reaper. External groups go first as they depend on the managed processes
+// (e.g. the browser needs the display).
+func (s *supervisor) stopAll() error {
+	s.mut.Lock()
+	procs := append([]*supervisedProcess(nil), s.procs...)
+	groups := append([]externalGroup(nil), s.groups...)
+	s.mut.Unlock()
+
+	var errs []error
+	for i := len(groups) - 1; i >= 0; i-- {
+		if err := groups[i].stop(processStopTimeoutDefault, s.reap); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", groups[i].name, err))
+		}
+	}
+
+	for i := len(procs) - 1; i >= 0; i-- {
+		select {
+		case <-procs[i].done():
+			continue
+		default:
+		}
+		slog.Debug("stopping process", slog.String("name", procs[i].spec.Name))
+		if err := procs[i].stop(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", procs[i].spec.Name, err))
+		}
+	}
+
+	s.stopOnce.Do(func() {
+		close(s.reaperStopCh)
+		<-s.reaperDoneCh
+		s.reap()
+	})
+
+	return errors.Join(errs...)
+}
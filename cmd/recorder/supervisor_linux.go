@@ -0,0 +1,91 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// setProcessAttrs makes the command run in its own process group and get
+// killed if we die.
+func setProcessAttrs(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = new(syscall.SysProcAttr)
+	}
+	cmd.SysProcAttr.Setpgid = true
+	cmd.SysProcAttr.Pdeathsig = syscall.SIGKILL
+}
+
+// setChildSubreaper makes orphaned descendants get reparented to us instead
+// of the init process so that they can be reaped.
+func setChildSubreaper() error {
+	return unix.Prctl(unix.PR_SET_CHILD_SUBREAPER, 1, 0, 0, 0)
+}
+
+// parseProcStat returns the state, parent pid and process group from the
+// content of /proc/<pid>/stat.
+func parseProcStat(data string) (byte, int, int, bool) {
+	// The command name can contain spaces and parentheses so we look for the
+	// last closing one.
+	idx := strings.LastIndexByte(data, ')')
+	if idx < 0 {
+		return 0, 0, 0, false
+	}
+	fields := strings.Fields(data[idx+1:])
+	if len(fields) < 3 || len(fields[0]) != 1 {
+		return 0, 0, 0, false
+	}
+	ppid, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return 0, 0, 0, false
+	}
+	pgrp, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return 0, 0, 0, false
+	}
+	return fields[0][0], ppid, pgrp, true
+}
+
+// reapOrphans waits on the zombie children of ours which belong to one of the
+// given process groups. Group leaders are skipped as they are waited on by
+// their owners.
+func reapOrphans(groups map[int]bool) []int {
+	if len(groups) == 0 {
+		return nil
+	}
+
+	matches, err := filepath.Glob("/proc/[0-9]*/stat")
+	if err != nil {
+		return nil
+	}
+
+	selfPID := os.Getpid()
+	var reaped []int
+	for _, path := range matches {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		state, ppid, pgrp, ok := parseProcStat(string(data))
+		if !ok || state != 'Z' || ppid != selfPID || !groups[pgrp] {
+			continue
+		}
+		pid, err := strconv.Atoi(filepath.Base(filepath.Dir(path)))
+		if err != nil || pid == pgrp {
+			continue
+		}
+		var ws syscall.WaitStatus
+		if wpid, err := syscall.Wait4(pid, &ws, syscall.WNOHANG, nil); err == nil && wpid == pid {
+			reaped = append(reaped, pid)
+		}
+	}
+
+	return reaped
+}
@@ -0,0 +1,60 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseProcStat(t *testing.T) {
+	state, ppid, pgrp, ok := parseProcStat("1234 (chrome) S 1000 1200 1200 0 -1 4194560")
+	require.True(t, ok)
+	require.Equal(t, byte('S'), state)
+	require.Equal(t, 1000, ppid)
+	require.Equal(t, 1200, pgrp)
+
+	state, ppid, pgrp, ok = parseProcStat("1234 (Web Content (x)) Z 1000 1200 1200 0")
+	require.True(t, ok)
+	require.Equal(t, byte('Z'), state)
+	require.Equal(t, 1000, ppid)
+	require.Equal(t, 1200, pgrp)
+
+	_, _, _, ok = parseProcStat("1234 chrome S 1000")
+	require.False(t, ok)
+	_, _, _, ok = parseProcStat("1234 (chrome) S")
+	require.False(t, ok)
+}
+
+func TestReapOrphans(t *testing.T) {
+	require.NoError(t, setChildSubreaper())
+
+	pidPath := filepath.Join(t.TempDir(), "child.pid")
+	script := writeTestScript(t, fmt.Sprintf("sleep 0.1 &\necho $! > %s\n", pidPath))
+
+	// The shell exits right away leaving the child orphaned.
+	cmd := exec.Command("sh", script)
+	setProcessAttrs(cmd)
+	require.NoError(t, cmd.Run())
+
+	data, err := os.ReadFile(pidPath)
+	require.NoError(t, err)
+	childPID, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		reaped := reapOrphans(map[int]bool{cmd.Process.Pid: true})
+		return len(reaped) == 1 && reaped[0] == childPID
+	}, 2*time.Second, 20*time.Millisecond)
+
+	_, err = os.Stat(fmt.Sprintf("/proc/%d", childPID))
+	require.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,23 @@
+//go:build !linux
+
+package main
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+func setProcessAttrs(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = new(syscall.SysProcAttr)
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+func setChildSubreaper() error {
+	return nil
+}
+
+func reapOrphans(_ map[int]bool) []int {
+	return nil
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeTestScript(t *testing.T, script string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "script.sh")
+	require.NoError(t, os.WriteFile(path, []byte(script), 0700))
+	return path
+}
+
+func newTestSupervisor(t *testing.T) (*supervisor, chan processExit) {
+	t.Helper()
+	exitCh := make(chan processExit, 10)
+	sup := newSupervisor(func(exit processExit) {
+		exitCh <- exit
+	})
+	t.Cleanup(func() {
+		require.NoError(t, sup.stopAll())
+	})
+	return sup, exitCh
+}
+
+func waitProcessExit(t *testing.T, exitCh chan processExit) processExit {
+	t.Helper()
+	select {
+	case exit := <-exitCh:
+		return exit
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "timed out waiting for process to exit")
+	}
+	return processExit{}
+}
+
+func TestProcessExitReason(t *testing.T) {
+	require.Equal(t, "exited successfully", processExitReason(nil))
+
+	err := exec.Command("sh", "-c", "exit 3").Run()
+	require.Equal(t, "exited with code 3", processExitReason(err))
+
+	err = exec.Command("sh", "-c", "kill -KILL $$").Run()
+	require.Equal(t, "killed by signal killed", processExitReason(err))
+
+	require.Equal(t, "some error", processExitReason(fmt.Errorf("some error")))
+}
+
+func TestProcessSpecIsValid(t *testing.T) {
+	tcs := []struct {
+		name string
+		spec processSpec
+		err  string
+	}{
+		{
+			name: "empty name",
+			spec: processSpec{Cmd: "true"},
+			err:  "invalid empty name",
+		},
+		{
+			name: "invalid restart policy",
+			spec: processSpec{Name: "test", Cmd: "true", Restart: "sometimes"},
+			err:  `invalid restart policy "sometimes"`,
+		},
+		{
+			name: "restart with stdin",
+			spec: processSpec{Name: "test", Cmd: "true", Restart: restartPolicyAlways, Stdin: true},
+			err:  "processes with stdin cannot be restarted",
+		},
+		{
+			name: "liveness probe without interval",
+			spec: processSpec{Name: "test", Cmd: "true", LivenessProbe: func() error { return nil }},
+			err:  "liveness interval should be positive",
+		},
+		{
+			name: "valid",
+			spec: processSpec{Name: "test", Cmd: "true"},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.spec.setDefaults()
+			err := tc.spec.isValid()
+			if tc.err == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tc.err)
+			}
+		})
+	}
+}
+
+func TestSupervisorStop(t *testing.T) {
+	t.Run("graceful", func(t *testing.T) {
+		sup, exitCh := newTestSupervisor(t)
+
+		proc, err := sup.start(processSpec{Name: "sleep", Cmd: "sleep", Args: "10"})
+		require.NoError(t, err)
+		require.NotZero(t, proc.pid())
+
+		require.NoError(t, proc.stop())
+		require.EqualError(t, proc.err(), "signal: terminated")
+
+		exit := waitProcessExit(t, exitCh)
+		require.Equal(t, "sleep", exit.Name)
+		require.Equal(t, "killed by signal terminated", exit.Reason)
+		require.True(t, exit.Expected)
+		require.False(t, exit.Restarting)
+	})
+
+	t.Run("escalation", func(t *testing.T) {
+		sup, exitCh := newTestSupervisor(t)
+
+		dir := t.TempDir()
+		readyPath := filepath.Join(dir, "ready")
+		script := writeTestScript(t, fmt.Sprintf("trap '' TERM\ntouch %s\nwhile true; do sleep 0.1; done\n", readyPath))
+		proc, err := sup.start(processSpec{
+			Name:        "stubborn",
+			Cmd:         "sh",
+			Args:        script,
+			StopTimeout: 200 * time.Millisecond,
+		})
+		require.NoError(t, err)
+
+		require.Eventually(t, func() bool {
+			_, err := os.Stat(readyPath)
+			return err == nil
+		}, 2*time.Second, 10*time.Millisecond)
+
+		require.NoError(t, proc.stop())
+		require.EqualError(t, proc.err(), "signal: killed")
+		require.True(t, waitProcessExit(t, exitCh).Expected)
+	})
+
+	t.Run("process group", func(t *testing.T) {
+		sup, _ := newTestSupervisor(t)
+
+		pidPath := filepath.Join(t.TempDir(), "child.pid")
+		script := writeTestScript(t, fmt.Sprintf("sleep 10 &\necho $! > %s\nwait\n", pidPath))
+		proc, err := sup.start(processSpec{Name: "parent", Cmd: "sh", Args: script})
+		require.NoError(t, err)
+
+		var childPID int
+		require.Eventually(t, func() bool {
+			data, err := os.ReadFile(pidPath)
+			if err != nil {
+				return false
+			}
+			childPID, err = strconv.Atoi(strings.TrimSpace(string(data)))
+			return err == nil
+		}, 2*time.Second, 10*time.Millisecond)
+
+		require.NoError(t, proc.stop())
+
+		// Depending on whether we are a subreaper the orphaned child gets
+		// reaped by us or by init.
+		require.Eventually(t, func() bool {
+			sup.reap()
+			return !isProcessAlive(childPID)
+		}, 2*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("stop all in reverse order", func(t *testing.T) {
+		sup, exitCh := newTestSupervisor(t)
+
+		_, err := sup.start(processSpec{Name: "first", Cmd: "sleep", Args: "10"})
+		require.NoError(t, err)
+		_, err = sup.start(processSpec{Name: "second", Cmd: "sleep", Args: "10"})
+		require.NoError(t, err)
+
+		require.NoError(t, sup.stopAll())
+		require.Equal(t, "second", waitProcessExit(t, exitCh).Name)
+		require.Equal(t, "first", waitProcessExit(t, exitCh).Name)
+	})
+
+	t.Run("external group", func(t *testing.T) {
+		sup, _ := newTestSupervisor(t)
+
+		cmd := exec.Command("sleep", "10")
+		setProcessAttrs(cmd)
+		require.NoError(t, cmd.Start())
+		waitErrCh := make(chan error, 1)
+		go func() {
+			waitErrCh <- cmd.Wait()
+		}()
+
+		sup.trackGroup("external", cmd.Process.Pid)
+		sup.trackGroup("external", cmd.Process.Pid)
+		require.Len(t, sup.groups, 1)
+
+		require.NoError(t, sup.stopAll())
+		require.EqualError(t, <-waitErrCh, "signal: terminated")
+	})
+}
+
+func TestSupervisorRestart(t *testing.T) {
+	t.Run("on failure", func(t *testing.T) {
+		sup, exitCh := newTestSupervisor(t)
+
+		proc, err := sup.start(processSpec{
+			Name:         "failing",
+			Cmd:          "false",
+			Restart:      restartPolicyOnFailure,
+			MaxRestarts:  2,
+			RestartDelay: 10 * time.Millisecond,
+		})
+		require.NoError(t, err)
+
+		for i := 0; i < 2; i++ {
+			exit := waitProcessExit(t, exitCh)
+			require.Equal(t, "exited with code 1", exit.Reason)
+			require.False(t, exit.Expected)
+			require.True(t, exit.Restarting)
+		}
+
+		exit := waitProcessExit(t, exitCh)
+		require.False(t, exit.Restarting)
+
+		<-proc.done()
+		require.EqualError(t, proc.err(), "exit status 1")
+	})
+
+	t.Run("on failure with success", func(t *testing.T) {
+		sup, exitCh := newTestSupervisor(t)
+
+		proc, err := sup.start(processSpec{
+			Name:    "succeeding",
+			Cmd:     "true",
+			Restart: restartPolicyOnFailure,
+		})
+		require.NoError(t, err)
+
+		exit := waitProcessExit(t, exitCh)
+		require.Equal(t, "exited successfully", exit.Reason)
+		require.False(t, exit.Restarting)
+		<-proc.done()
+		require.NoError(t, proc.err())
+	})
+
+	t.Run("always", func(t *testing.T) {
+		sup, exitCh := newTestSupervisor(t)
+
+		proc, err := sup.start(processSpec{
+			Name:         "always",
+			Cmd:          "true",
+			Restart:      restartPolicyAlways,
+			RestartDelay: 10 * time.Millisecond,
+		})
+		require.NoError(t, err)
+
+		require.True(t, waitProcessExit(t, exitCh).Restarting)
+		require.True(t, waitProcessExit(t, exitCh).Restarting)
+
+		require.NoError(t, proc.stop())
+		<-proc.done()
+	})
+}
+
+func TestSupervisorStdoutHandler(t *testing.T) {
+	sup, exitCh := newTestSupervisor(t)
+
+	outCh := make(chan string, 1)
+	_, err := sup.start(processSpec{
+		Name: "echo",
+		Cmd:  "echo",
+		Args: "hello",
+		StdoutHandler: func(r io.Reader) {
+			data, _ := io.ReadAll(r)
+			outCh <- string(data)
+		},
+		Optional: true,
+	})
+	require.NoError(t, err)
+
+	select {
+	case out := <-outCh:
+		require.Equal(t, "hello\n", out)
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "timed out waiting for output")
+	}
+
+	exit := waitProcessExit(t, exitCh)
+	require.True(t, exit.Optional)
+}
+
+func TestSupervisorLiveness(t *testing.T) {
+	sup, exitCh := newTestSupervisor(t)
+
+	_, err := sup.start(processSpec{
+		Name:              "unhealthy",
+		Cmd:               "sleep",
+		Args:              "10",
+		LivenessProbe:     func() error { return fmt.Errorf("not healthy") },
+		LivenessInterval:  10 * time.Millisecond,
+		LivenessThreshold: 2,
+	})
+	require.NoError(t, err)
+
+	exit := waitProcessExit(t, exitCh)
+	require.Equal(t, "liveness probe failed: not healthy", exit.Reason)
+	require.False(t, exit.Expected)
+	require.Error(t, exit.Err)
+}
+
+func TestKillProcessGroup(t *testing.T) {
+	require.EqualError(t, killProcessGroup(0, syscall.SIGTERM), "invalid process group 0")
+
+	cmd := exec.Command("true")
+	setProcessAttrs(cmd)
+	require.NoError(t, cmd.Run())
+	// Missing groups are ignored.
+	require.NoError(t, killProcessGroup(cmd.Process.Pid, syscall.SIGTERM))
+	require.False(t, isProcessGroupAlive(cmd.Process.Pid))
+}
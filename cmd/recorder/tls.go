@@ -0,0 +1,205 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	nssDBCANickname     = "calls-recorder-ca"
+	nssDBClientNickname = "calls-recorder-client"
+)
+
+// The database is shared by all the browsers running under the same user so
+// concurrent jobs need to take turns provisioning it.
+var nssDBMut sync.Mutex
+
+// tlsFilesConfig holds the paths to the custom TLS material used to connect
+// to the Mattermost instance.
+type tlsFilesConfig struct {
+	// CACertFile is a PEM bundle of certificate authorities to trust on top
+	// of the system ones.
+	CACertFile string
+	// ClientCertFile and ClientKeyFile are the PEM encoded certificate and key
+	// to present to servers requesting client authentication (mTLS).
+	ClientCertFile string
+	ClientKeyFile  string
+}
+
+// getTLSFilesConfig returns the TLS settings from the environment.
+func getTLSFilesConfig() (tlsFilesConfig, error) {
+	cfg := tlsFilesConfig{
+		CACertFile:     os.Getenv("TLS_CA_CERT_FILE"),
+		ClientCertFile: os.Getenv("TLS_CLIENT_CERT_FILE"),
+		ClientKeyFile:  os.Getenv("TLS_CLIENT_KEY_FILE"),
+	}
+
+	if (cfg.ClientCertFile == "") != (cfg.ClientKeyFile == "") {
+		return cfg, fmt.Errorf("TLS_CLIENT_CERT_FILE and TLS_CLIENT_KEY_FILE should be set together")
+	}
+
+	return cfg, nil
+}
+
+func (c tlsFilesConfig) isEnabled() bool {
+	return c.CACertFile != "" || c.ClientCertFile != ""
+}
+
+// parsePEMCertificates returns the certificates contained in the given PEM
+// bundle.
+func parsePEMCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate: %w", err)
+		}
+		certs = append(certs, cert)
+	}
+
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificates found")
+	}
+
+	return certs, nil
+}
+
+// clientConfig returns the TLS configuration for the HTTP client. A nil value
+// means the defaults should be used.
+func (c tlsFilesConfig) clientConfig() (*tls.Config, error) {
+	if !c.isEnabled() {
+		return nil, nil
+	}
+
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if c.CACertFile != "" {
+		data, err := os.ReadFile(c.CACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		certs, err := parsePEMCertificates(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load CA file: %w", err)
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			slog.Warn("failed to load system cert pool", slog.String("err", err.Error()))
+			pool = x509.NewCertPool()
+		}
+		for _, cert := range certs {
+			pool.AddCert(cert)
+		}
+		cfg.RootCAs = pool
+	}
+
+	if c.ClientCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.ClientCertFile, c.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
+
+func runNSSCmd(name string, args ...string) error {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s failed: %w: %s", name, err, out)
+	}
+	return nil
+}
+
+// provisionNSSDB creates (if needed) the NSS database at dir, which is where
+// Chromium looks for user certificates, and imports the configured CA
+// certificates and client certificate into it.
+func provisionNSSDB(dir string, c tlsFilesConfig) error {
+	nssDBMut.Lock()
+	defer nssDBMut.Unlock()
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	dbPath := "sql:" + dir
+	if _, err := os.Stat(filepath.Join(dir, "cert9.db")); os.IsNotExist(err) {
+		if err := runNSSCmd("certutil", "-d", dbPath, "-N", "--empty-password"); err != nil {
+			return fmt.Errorf("failed to create database: %w", err)
+		}
+	}
+
+	tmpDir, err := os.MkdirTemp("", "nssdb")
+	if err != nil {
+		return fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if c.CACertFile != "" {
+		data, err := os.ReadFile(c.CACertFile)
+		if err != nil {
+			return fmt.Errorf("failed to read CA file: %w", err)
+		}
+		certs, err := parsePEMCertificates(data)
+		if err != nil {
+			return fmt.Errorf("failed to load CA file: %w", err)
+		}
+
+		// certutil only imports the first certificate of a file so we need to
+		// import them one by one.
+		for i, cert := range certs {
+			certPath := filepath.Join(tmpDir, fmt.Sprintf("ca-%d.der", i))
+			if err := os.WriteFile(certPath, cert.Raw, 0600); err != nil {
+				return fmt.Errorf("failed to write CA certificate: %w", err)
+			}
+			nickname := fmt.Sprintf("%s-%d", nssDBCANickname, i)
+			if err := runNSSCmd("certutil", "-d", dbPath, "-A", "-t", "C,,", "-n", nickname, "-i", certPath); err != nil {
+				return fmt.Errorf("failed to import CA certificate: %w", err)
+			}
+		}
+	}
+
+	if c.ClientCertFile != "" {
+		// Certificate and key need to be bundled together as PKCS#12 to be
+		// imported.
+		p12Path := filepath.Join(tmpDir, "client.p12")
+		if err := runNSSCmd("openssl", "pkcs12", "-export",
+			"-in", c.ClientCertFile, "-inkey", c.ClientKeyFile,
+			"-name", nssDBClientNickname, "-out", p12Path, "-passout", "pass:"); err != nil {
+			return fmt.Errorf("failed to bundle client certificate: %w", err)
+		}
+		if err := runNSSCmd("pk12util", "-d", dbPath, "-i", p12Path, "-W", ""); err != nil {
+			return fmt.Errorf("failed to import client certificate: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// getNSSDBDir returns the path to the NSS database used by Chromium.
+func getNSSDBDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".pki", "nssdb"), nil
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+func genTestCert(t *testing.T, tmpl *x509.Certificate, parent *testCert) *testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	parentCert, parentKey := tmpl, key
+	if parent != nil {
+		parentCert, parentKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parentCert, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func genTestPKI(t *testing.T) (ca, server, client *testCert) {
+	t.Helper()
+
+	ca = genTestCert(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+
+	server = genTestCert(t, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca)
+
+	client = genTestCert(t, &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "calls-recorder"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca)
+
+	return ca, server, client
+}
+
+func TestGetTLSFilesConfig(t *testing.T) {
+	t.Run("empty", func(t *testing.T) {
+		t.Setenv("TLS_CA_CERT_FILE", "")
+		t.Setenv("TLS_CLIENT_CERT_FILE", "")
+		t.Setenv("TLS_CLIENT_KEY_FILE", "")
+		cfg, err := getTLSFilesConfig()
+		require.NoError(t, err)
+		require.False(t, cfg.isEnabled())
+
+		tlsConfig, err := cfg.clientConfig()
+		require.NoError(t, err)
+		require.Nil(t, tlsConfig)
+	})
+
+	t.Run("missing key", func(t *testing.T) {
+		t.Setenv("TLS_CA_CERT_FILE", "")
+		t.Setenv("TLS_CLIENT_CERT_FILE", "/tls/client.crt")
+		t.Setenv("TLS_CLIENT_KEY_FILE", "")
+		_, err := getTLSFilesConfig()
+		require.EqualError(t, err, "TLS_CLIENT_CERT_FILE and TLS_CLIENT_KEY_FILE should be set together")
+	})
+}
+
+func TestParsePEMCertificates(t *testing.T) {
+	ca, server, client := genTestPKI(t)
+
+	t.Run("empty", func(t *testing.T) {
+		_, err := parsePEMCertificates(nil)
+		require.EqualError(t, err, "no certificates found")
+	})
+
+	t.Run("key only", func(t *testing.T) {
+		_, err := parsePEMCertificates(ca.keyPEM)
+		require.EqualError(t, err, "no certificates found")
+	})
+
+	t.Run("bundle", func(t *testing.T) {
+		data := append(append(append([]byte{}, ca.certPEM...), server.keyPEM...), client.certPEM...)
+		certs, err := parsePEMCertificates(data)
+		require.NoError(t, err)
+		require.Len(t, certs, 2)
+		require.Equal(t, "Test CA", certs[0].Subject.CommonName)
+		require.Equal(t, "calls-recorder", certs[1].Subject.CommonName)
+	})
+}
+
+func TestTLSFilesConfigClientConfig(t *testing.T) {
+	ca, server, client := genTestPKI(t)
+
+	dir := t.TempDir()
+	caPath := filepath.Join(dir, "ca.crt")
+	certPath := filepath.Join(dir, "client.crt")
+	keyPath := filepath.Join(dir, "client.key")
+	require.NoError(t, os.WriteFile(caPath, ca.certPEM, 0600))
+	require.NoError(t, os.WriteFile(certPath, client.certPEM, 0600))
+	require.NoError(t, os.WriteFile(keyPath, client.keyPEM, 0600))
+
+	serverCert, err := tls.X509KeyPair(server.certPEM, server.keyPEM)
+	require.NoError(t, err)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	ts.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	ts.StartTLS()
+	defer ts.Close()
+
+	doRequest := func(cfg tlsFilesConfig) error {
+		tlsConfig, err := cfg.clientConfig()
+		require.NoError(t, err)
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		resp, err := (&http.Client{Transport: transport}).Get(ts.URL)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+
+	t.Run("untrusted", func(t *testing.T) {
+		require.Error(t, doRequest(tlsFilesConfig{}))
+	})
+
+	t.Run("missing client certificate", func(t *testing.T) {
+		require.Error(t, doRequest(tlsFilesConfig{CACertFile: caPath}))
+	})
+
+	t.Run("mtls", func(t *testing.T) {
+		require.NoError(t, doRequest(tlsFilesConfig{
+			CACertFile:     caPath,
+			ClientCertFile: certPath,
+			ClientKeyFile:  keyPath,
+		}))
+	})
+
+	t.Run("invalid CA file", func(t *testing.T) {
+		_, err := tlsFilesConfig{CACertFile: keyPath}.clientConfig()
+		require.EqualError(t, err, "failed to load CA file: no certificates found")
+	})
+
+	t.Run("mismatched key", func(t *testing.T) {
+		serverKeyPath := filepath.Join(dir, "server.key")
+		require.NoError(t, os.WriteFile(serverKeyPath, server.keyPEM, 0600))
+		_, err := tlsFilesConfig{ClientCertFile: certPath, ClientKeyFile: serverKeyPath}.clientConfig()
+		require.ErrorContains(t, err, "failed to load client certificate")
+	})
+}
+
+func TestProvisionNSSDB(t *testing.T) {
+	for _, name := range []string{"certutil", "pk12util", "openssl"} {
+		if _, err := exec.LookPath(name); err != nil {
+			t.Skipf("%s is not available", name)
+		}
+	}
+
+	ca, _, client := genTestPKI(t)
+
+	dir := t.TempDir()
+	caPath := filepath.Join(dir, "ca.crt")
+	certPath := filepath.Join(dir, "client.crt")
+	keyPath := filepath.Join(dir, "client.key")
+	require.NoError(t, os.WriteFile(caPath, ca.certPEM, 0600))
+	require.NoError(t, os.WriteFile(certPath, client.certPEM, 0600))
+	require.NoError(t, os.WriteFile(keyPath, client.keyPEM, 0600))
+
+	dbDir := filepath.Join(dir, "nssdb")
+	err := provisionNSSDB(dbDir, tlsFilesConfig{
+		CACertFile:     caPath,
+		ClientCertFile: certPath,
+		ClientKeyFile:  keyPath,
+	})
+	require.NoError(t, err)
+
+	out, err := exec.Command("certutil", "-d", "sql:"+dbDir, "-L").CombinedOutput()
+	require.NoError(t, err)
+	require.Contains(t, string(out), nssDBCANickname+"-0")
+	require.Contains(t, string(out), nssDBClientNickname)
+}
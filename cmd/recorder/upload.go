@@ -9,8 +9,11 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
+	"github.com/mattermost/calls-recorder/cmd/recorder/config"
+
 	"github.com/mattermost/mattermost-plugin-calls/server/public"
 
 	"github.com/mattermost/mattermost/server/public/model"
@@ -27,6 +30,11 @@ var (
 )
 
 func (rec *Recorder) publishRecording() error {
+	// Recording where things are at so that a restarted process knows there's
+	// an upload to resume.
+	rec.publishing = true
+	rec.saveUploadState()
+
 	var attempt int
 	for {
 		err := rec.uploadRecording()
@@ -51,113 +59,326 @@ func (rec *Recorder) publishRecording() error {
 }
 
 func (rec *Recorder) uploadRecording() error {
-	file, err := os.Open(rec.outPath)
-	if err != nil {
-		return fmt.Errorf("failed to open file: %w", err)
+	// Files that were stored successfully during a previous attempt don't
+	// need to be stored again.
+	paths := append([]string{rec.outPath}, rec.extraFiles...)
+	for _, backend := range rec.storage {
+		stored := rec.uploadedFiles[backend.target()]
+		if stored == nil {
+			stored = map[string]string{}
+			rec.uploadedFiles[backend.target()] = stored
+		}
+		for _, path := range paths {
+			if _, ok := stored[path]; ok {
+				continue
+			}
+			location, err := backend.store(path)
+			if err != nil {
+				return err
+			}
+			slog.Debug("file stored",
+				slog.String("target", string(backend.target())),
+				slog.String("path", path),
+				slog.String("location", location))
+			stored[path] = location
+			rec.saveUploadState()
+		}
 	}
-	defer file.Close()
 
-	info, err := file.Stat()
-	if err != nil {
-		return fmt.Errorf("failed to stat file: %w", err)
+	var fileIDs []string
+	if rec.hasStorageTarget(config.StorageTargetMattermost) {
+		for _, path := range paths {
+			fileIDs = append(fileIDs, rec.uploadedFiles[config.StorageTargetMattermost][path])
+		}
 	}
 
-	apiURL := fmt.Sprintf("%s/plugins/%s/bot", rec.client.URL, pluginID)
-
-	us := &model.UploadSession{
-		ChannelId: rec.cfg.CallID,
-		Filename:  filepath.Base(rec.outPath),
-		FileSize:  info.Size(),
+	// The post always gets created in Mattermost, linking to the copies
+	// stored elsewhere.
+	locationsFileID, err := rec.uploadStorageLocations(paths)
+	if err != nil {
+		return fmt.Errorf("failed to upload storage locations: %w", err)
+	} else if locationsFileID != "" {
+		fileIDs = append(fileIDs, locationsFileID)
 	}
 
-	payload, err := json.Marshal(us)
+	payload, err := json.Marshal(public.JobInfo{
+		JobID:   rec.cfg.RecordingID,
+		FileIDs: fileIDs,
+		PostID:  rec.cfg.PostID,
+	})
 	if err != nil {
 		return fmt.Errorf("failed to encode payload: %w", err)
 	}
 
+	apiURL := fmt.Sprintf("%s/plugins/%s/bot", rec.client.URL, pluginID)
+	url := fmt.Sprintf("%s/calls/%s/recordings", apiURL, rec.cfg.CallID)
 	ctx, cancelCtx := context.WithTimeout(context.Background(), httpRequestTimeout)
 	defer cancelCtx()
-	resp, err := rec.client.DoAPIRequestBytes(ctx, http.MethodPost, apiURL+"/uploads", payload, "")
+	resp, err := rec.client.DoAPIRequestBytes(ctx, http.MethodPost, url, payload, "")
 	if err != nil {
-		return fmt.Errorf("failed to create upload: %w", err)
+		return fmt.Errorf("failed to save recording: %w", err)
 	}
 	defer resp.Body.Close()
 
-	if err := json.NewDecoder(resp.Body).Decode(&us); err != nil {
-		return fmt.Errorf("failed to decode response body: %w", err)
+	rec.uploadedFiles = map[config.StorageTarget]map[string]string{}
+	rec.uploadSessionsMut.Lock()
+	rec.uploadSessions = map[string]uploadSessionState{}
+	rec.uploadSessionsMut.Unlock()
+	rec.removeUploadState()
+
+	return nil
+}
+
+func (rec *Recorder) hasStorageTarget(target config.StorageTarget) bool {
+	for _, backend := range rec.storage {
+		if backend.target() == target {
+			return true
+		}
+	}
+	return false
+}
+
+// uploadStorageLocations uploads to Mattermost the list of the locations the
+// given files were stored to, other than Mattermost itself, and returns the
+// resulting file ID. Nothing is uploaded if there are no such locations.
+func (rec *Recorder) uploadStorageLocations(paths []string) (string, error) {
+	locations := storageLocations{
+		CallID:      rec.cfg.CallID,
+		RecordingID: rec.cfg.RecordingID,
+	}
+	for _, path := range paths {
+		f := storageLocationsFile{
+			Name: filepath.Base(path),
+		}
+		for _, backend := range rec.storage {
+			if backend.target() == config.StorageTargetMattermost {
+				continue
+			}
+			f.Locations = append(f.Locations, storageLocation{
+				Target:   backend.target(),
+				Location: rec.uploadedFiles[backend.target()][path],
+			})
+		}
+		if len(f.Locations) > 0 {
+			locations.Files = append(locations.Files, f)
+		}
+	}
+
+	if len(locations.Files) == 0 {
+		return "", nil
+	}
+
+	path := strings.TrimSuffix(rec.outPath, filepath.Ext(rec.outPath)) + storageLocationsFileSuffix
+
+	uploaded := rec.uploadedFiles[config.StorageTargetMattermost]
+	if uploaded == nil {
+		uploaded = map[string]string{}
+		rec.uploadedFiles[config.StorageTargetMattermost] = uploaded
+	}
+	if fileID, ok := uploaded[path]; ok {
+		return fileID, nil
+	}
+
+	if err := locations.writeJSON(path); err != nil {
+		return "", err
+	}
+	defer os.Remove(path)
+
+	fileID, err := rec.uploadFile(path)
+	if err != nil {
+		return "", err
+	}
+	uploaded[path] = fileID
+	rec.saveUploadState()
+
+	return fileID, nil
+}
+
+// uploadFile uploads the file at the given path to the channel of the call
+// and returns the resulting file ID. An upload previously interrupted, even
+// by a process restart, is resumed from where it was left.
+func (rec *Recorder) uploadFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return "", fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	checksum, err := rec.fileChecksum(path, file, info)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash file: %w", err)
+	}
+
+	apiURL := fmt.Sprintf("%s/plugins/%s/bot", rec.client.URL, pluginID)
+
+	us := rec.getResumableUploadSession(apiURL, path, checksum, info.Size())
+	if us == nil {
+		us, err = rec.createUpload(apiURL, filepath.Base(path), info.Size())
+		if err != nil {
+			return "", err
+		}
+		rec.setUploadSession(path, uploadSessionState{
+			UploadID: us.Id,
+			Checksum: checksum,
+			FileSize: info.Size(),
+			ModTime:  info.ModTime(),
+		})
+	} else {
+		slog.Info("resuming upload",
+			slog.String("upload_id", us.Id),
+			slog.Int64("offset", us.FileOffset),
+			slog.Int64("size", us.FileSize))
 	}
-	cancelCtx()
 
-	var fi model.FileInfo
 	for {
-		ctx, cancelCtx = context.WithTimeout(context.Background(), httpUploadTimeout)
-		defer cancelCtx()
-		resp, err = rec.client.DoAPIRequestReader(ctx, http.MethodPost, apiURL+"/uploads/"+us.Id, file, nil)
+		fi, err := rec.uploadData(apiURL, us.Id, io.NewSectionReader(file, us.FileOffset, info.Size()-us.FileOffset))
 		if err != nil {
-			return fmt.Errorf("failed to upload data: %w", err)
+			return "", err
+		} else if fi != nil {
+			rec.clearUploadSession(path)
+			return fi.Id, nil
 		}
-		defer resp.Body.Close()
 
 		// Check whether we need to resume the upload. This can happen in case the
 		// FileSettings.MaxFileSize server config value is less than the recording file size.
 		// In such cases we'll be uploading in chunks of at most FileSettings.MaxFileSize.
-		if resp.StatusCode == http.StatusNoContent {
-			ctx, cancelCtx := context.WithTimeout(context.Background(), httpRequestTimeout)
-			defer cancelCtx()
-			resp, err := rec.client.DoAPIRequest(ctx, http.MethodGet, apiURL+"/uploads/"+us.Id, "", "")
-			if err != nil {
-				return fmt.Errorf("failed to get upload: %w", err)
-			}
-			defer resp.Body.Close()
+		us, err = rec.getUpload(apiURL, us.Id)
+		if err != nil {
+			return "", err
+		}
 
-			if err := json.NewDecoder(resp.Body).Decode(&us); err != nil {
-				return fmt.Errorf("failed to decode response body: %w", err)
-			}
-			cancelCtx()
+		rec.setUploadSession(path, uploadSessionState{
+			UploadID: us.Id,
+			Checksum: checksum,
+			Offset:   us.FileOffset,
+			FileSize: info.Size(),
+			ModTime:  info.ModTime(),
+		})
 
-			slog.Info("resuming upload",
-				slog.String("upload_id", us.Id),
-				slog.Int64("offset", us.FileOffset),
-				slog.Int64("size", us.FileSize))
+		slog.Info("resuming upload",
+			slog.String("upload_id", us.Id),
+			slog.Int64("offset", us.FileOffset),
+			slog.Int64("size", us.FileSize))
+	}
+}
 
-			file, err = os.Open(rec.outPath)
-			if err != nil {
-				return fmt.Errorf("failed to open file: %w", err)
-			}
-			defer file.Close()
+// createUpload creates an upload session for a file of the given size in the
+// channel of the call.
+func (rec *Recorder) createUpload(apiURL, filename string, size int64) (*model.UploadSession, error) {
+	payload, err := json.Marshal(&model.UploadSession{
+		ChannelId: rec.cfg.CallID,
+		Filename:  filename,
+		FileSize:  size,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode payload: %w", err)
+	}
 
-			if _, err := file.Seek(us.FileOffset, io.SeekStart); err != nil {
-				return fmt.Errorf("failed to seek file at offset: %w", err)
-			}
+	ctx, cancelCtx := context.WithTimeout(context.Background(), httpRequestTimeout)
+	defer cancelCtx()
+	resp, err := rec.client.DoAPIRequestBytes(ctx, http.MethodPost, apiURL+"/uploads", payload, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create upload: %w", err)
+	}
+	defer resp.Body.Close()
 
-			continue
-		}
+	var us model.UploadSession
+	if err := json.NewDecoder(resp.Body).Decode(&us); err != nil {
+		return nil, fmt.Errorf("failed to decode response body: %w", err)
+	}
 
-		if err := json.NewDecoder(resp.Body).Decode(&fi); err != nil {
-			return fmt.Errorf("failed to decode response body: %w", err)
-		}
-		cancelCtx()
+	return &us, nil
+}
 
-		break
+func (rec *Recorder) getUpload(apiURL, uploadID string) (*model.UploadSession, error) {
+	ctx, cancelCtx := context.WithTimeout(context.Background(), httpRequestTimeout)
+	defer cancelCtx()
+	resp, err := rec.client.DoAPIRequest(ctx, http.MethodGet, apiURL+"/uploads/"+uploadID, "", "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get upload: %w", err)
 	}
+	defer resp.Body.Close()
 
-	payload, err = json.Marshal(public.JobInfo{
-		JobID:   rec.cfg.RecordingID,
-		FileIDs: []string{fi.Id},
-		PostID:  rec.cfg.PostID,
-	})
-	if err != nil {
-		return fmt.Errorf("failed to encode payload: %w", err)
+	var us model.UploadSession
+	if err := json.NewDecoder(resp.Body).Decode(&us); err != nil {
+		return nil, fmt.Errorf("failed to decode response body: %w", err)
 	}
 
-	url := fmt.Sprintf("%s/calls/%s/recordings", apiURL, rec.cfg.CallID)
-	ctx, cancelCtx = context.WithTimeout(context.Background(), httpRequestTimeout)
+	return &us, nil
+}
+
+// uploadData sends the given data to the upload session. The info of the
+// resulting file is returned once the upload is complete, nil otherwise.
+func (rec *Recorder) uploadData(apiURL, uploadID string, data io.Reader) (*model.FileInfo, error) {
+	ctx, cancelCtx := context.WithTimeout(context.Background(), httpUploadTimeout)
 	defer cancelCtx()
-	resp, err = rec.client.DoAPIRequestBytes(ctx, http.MethodPost, url, payload, "")
+	resp, err := rec.client.DoAPIRequestReader(ctx, http.MethodPost, apiURL+"/uploads/"+uploadID, data, nil)
 	if err != nil {
-		return fmt.Errorf("failed to save recording: %w", err)
+		return nil, fmt.Errorf("failed to upload data: %w", err)
 	}
 	defer resp.Body.Close()
 
-	return nil
+	if resp.StatusCode == http.StatusNoContent {
+		return nil, nil
+	}
+
+	var fi model.FileInfo
+	if err := json.NewDecoder(resp.Body).Decode(&fi); err != nil {
+		return nil, fmt.Errorf("failed to decode response body: %w", err)
+	}
+
+	return &fi, nil
+}
+
+// fileChecksum returns the checksum of the given file. Hashing a recording
+// can take a while so the checksum of the current upload session is reused
+// if the file's size and modification time haven't changed since.
+func (rec *Recorder) fileChecksum(path string, file *os.File, info os.FileInfo) (string, error) {
+	if state, ok := rec.getUploadSession(path); ok && state.Checksum != "" &&
+		state.FileSize == info.Size() && state.ModTime.Equal(info.ModTime()) {
+		return state.Checksum, nil
+	}
+
+	return hashSection(file, 0, info.Size())
+}
+
+// getResumableUploadSession returns the upload session previously created
+// for the file at the given path, if any and it can still be resumed.
+func (rec *Recorder) getResumableUploadSession(apiURL, path, checksum string, size int64) *model.UploadSession {
+	state, ok := rec.getUploadSession(path)
+	if !ok {
+		return nil
+	}
+
+	if state.Checksum != checksum {
+		slog.Warn("file changed since upload was created, starting over",
+			slog.String("path", path),
+			slog.String("upload_id", state.UploadID))
+		return nil
+	}
+
+	us, err := rec.getUpload(apiURL, state.UploadID)
+	if err != nil {
+		slog.Warn("failed to get upload, starting over",
+			slog.String("upload_id", state.UploadID),
+			slog.String("err", err.Error()))
+		return nil
+	}
+
+	// A complete upload can't be resumed as the resulting file ID is unknown
+	// at this point.
+	if us.Id != state.UploadID || us.FileSize != size || us.FileOffset < 0 || us.FileOffset >= size {
+		slog.Warn("upload can't be resumed, starting over",
+			slog.String("upload_id", state.UploadID),
+			slog.Int64("offset", us.FileOffset),
+			slog.Int64("size", us.FileSize))
+		return nil
+	}
+
+	return us
 }
@@ -2,16 +2,21 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/mattermost/calls-recorder/cmd/recorder/config"
 
+	"github.com/mattermost/mattermost-plugin-calls/server/public"
+
 	"github.com/stretchr/testify/require"
 )
 
@@ -209,6 +214,119 @@ func TestUploadRecording(t *testing.T) {
 		require.NoError(t, err)
 		require.Equal(t, fileContent, uploadedData.String())
 	})
+
+	t.Run("extra files", func(t *testing.T) {
+		extraFile, err := os.CreateTemp("", "recording-attendance.json")
+		require.NoError(t, err)
+		defer os.Remove(extraFile.Name())
+		rec.extraFiles = []string{extraFile.Name()}
+		defer func() { rec.extraFiles = nil }()
+
+		var uploads int
+		var saveFailed bool
+		var jobInfo public.JobInfo
+		middlewares = []middleware{
+			func(w http.ResponseWriter, r *http.Request) bool {
+				if r.URL.Path == "/plugins/com.mattermost.calls/bot/uploads" && r.Method == http.MethodPost {
+					uploads++
+					fmt.Fprintf(w, `{"id": "uploadID%d"}`, uploads)
+					return true
+				}
+
+				return false
+			},
+			func(w http.ResponseWriter, r *http.Request) bool {
+				if strings.HasPrefix(r.URL.Path, "/plugins/com.mattermost.calls/bot/uploads/uploadID") && r.Method == http.MethodPost {
+					fmt.Fprintf(w, `{"id": "fileID%s"}`, strings.TrimPrefix(r.URL.Path, "/plugins/com.mattermost.calls/bot/uploads/uploadID"))
+					return true
+				}
+
+				return false
+			},
+			func(w http.ResponseWriter, r *http.Request) bool {
+				if r.URL.Path == "/plugins/com.mattermost.calls/bot/calls/8w8jorhr7j83uqr6y1st894hqe/recordings" && r.Method == http.MethodPost {
+					if !saveFailed {
+						saveFailed = true
+						w.WriteHeader(400)
+						fmt.Fprintln(w, `{"message": "server error"}`)
+						return true
+					}
+					_ = json.NewDecoder(r.Body).Decode(&jobInfo)
+					w.WriteHeader(200)
+					return true
+				}
+
+				return false
+			},
+		}
+
+		err = rec.uploadRecording()
+		require.EqualError(t, err, "failed to save recording: server error")
+		require.Equal(t, 2, uploads)
+
+		// Files should not be uploaded again on retry.
+		err = rec.uploadRecording()
+		require.NoError(t, err)
+		require.Equal(t, 2, uploads)
+		require.Equal(t, []string{"fileID1", "fileID2"}, jobInfo.FileIDs)
+		require.Empty(t, rec.uploadedFiles)
+	})
+
+	t.Run("external storage", func(t *testing.T) {
+		localDir := t.TempDir()
+		defaultStorage := rec.storage
+		rec.storage = []storageBackend{&localStorage{dir: localDir}}
+		defer func() { rec.storage = defaultStorage }()
+
+		var locations storageLocations
+		var jobInfo public.JobInfo
+		middlewares = []middleware{
+			func(w http.ResponseWriter, r *http.Request) bool {
+				if r.URL.Path == "/plugins/com.mattermost.calls/bot/uploads" && r.Method == http.MethodPost {
+					fmt.Fprintln(w, `{"id": "uploadID"}`)
+					return true
+				}
+
+				return false
+			},
+			func(w http.ResponseWriter, r *http.Request) bool {
+				if r.URL.Path == "/plugins/com.mattermost.calls/bot/uploads/uploadID" && r.Method == http.MethodPost {
+					_ = json.NewDecoder(r.Body).Decode(&locations)
+					fmt.Fprintln(w, `{"id": "locationsFileID"}`)
+					return true
+				}
+
+				return false
+			},
+			func(w http.ResponseWriter, r *http.Request) bool {
+				if r.URL.Path == "/plugins/com.mattermost.calls/bot/calls/8w8jorhr7j83uqr6y1st894hqe/recordings" && r.Method == http.MethodPost {
+					_ = json.NewDecoder(r.Body).Decode(&jobInfo)
+					w.WriteHeader(200)
+					return true
+				}
+
+				return false
+			},
+		}
+
+		err := rec.uploadRecording()
+		require.NoError(t, err)
+		require.Equal(t, []string{"locationsFileID"}, jobInfo.FileIDs)
+		require.Equal(t, storageLocations{
+			CallID:      "8w8jorhr7j83uqr6y1st894hqe",
+			RecordingID: "67t5u6cmtfbb7jug739d43xa9e",
+			Files: []storageLocationsFile{
+				{
+					Name: filepath.Base(recFile.Name()),
+					Locations: []storageLocation{
+						{Target: config.StorageTargetLocal, Location: filepath.Join(localDir, filepath.Base(recFile.Name()))},
+					},
+				},
+			},
+		}, locations)
+		require.FileExists(t, filepath.Join(localDir, filepath.Base(recFile.Name())))
+		require.Empty(t, rec.uploadedFiles)
+	})
 }
 
 func TestPublishRecording(t *testing.T) {
@@ -325,3 +443,149 @@ func TestPublishRecording(t *testing.T) {
 		require.NoError(t, err)
 	})
 }
+
+func TestResumeUpload(t *testing.T) {
+	middlewares := []middleware{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, mw := range middlewares {
+			if mw(w, r) {
+				return
+			}
+		}
+		http.NotFound(w, r)
+	}))
+	defer ts.Close()
+
+	cfg := config.RecorderConfig{
+		SiteURL:     ts.URL,
+		CallID:      "8w8jorhr7j83uqr6y1st894hqe",
+		PostID:      "udzdsg7dwidbzcidx5khrf8nee",
+		RecordingID: "67t5u6cmtfbb7jug739d43xa9e",
+		AuthToken:   "qj75unbsef83ik9p7ueypb6iyw",
+	}
+	cfg.SetDefaults()
+	dataPath := t.TempDir()
+	rec, err := NewRecorder(cfg, dataPath)
+	require.NoError(t, err)
+	require.NotNil(t, rec)
+
+	t.Run("nothing to resume", func(t *testing.T) {
+		resumed, err := rec.ResumeUpload()
+		require.NoError(t, err)
+		require.False(t, resumed)
+	})
+
+	fileContent := "some resumable content"
+	outPath := filepath.Join(dataPath, "recording.mp4")
+
+	var creations int
+	var uploadedData bytes.Buffer
+	var jobInfo public.JobInfo
+	middlewares = []middleware{
+		func(w http.ResponseWriter, r *http.Request) bool {
+			if r.URL.Path == "/plugins/com.mattermost.calls/bot/uploads" && r.Method == http.MethodPost {
+				creations++
+				fmt.Fprintln(w, `{"id": "newUploadID"}`)
+				return true
+			}
+
+			return false
+		},
+		func(w http.ResponseWriter, r *http.Request) bool {
+			if r.URL.Path == "/plugins/com.mattermost.calls/bot/uploads/uploadID" && r.Method == http.MethodGet {
+				fmt.Fprintf(w, `{"id": "uploadID", "file_offset": 10, "file_size": %d}`, len(fileContent))
+				return true
+			}
+
+			return false
+		},
+		func(w http.ResponseWriter, r *http.Request) bool {
+			if strings.HasPrefix(r.URL.Path, "/plugins/com.mattermost.calls/bot/uploads/") && r.Method == http.MethodPost {
+				_, _ = uploadedData.ReadFrom(r.Body)
+				fmt.Fprintln(w, `{"id": "fileID"}`)
+				return true
+			}
+
+			return false
+		},
+		func(w http.ResponseWriter, r *http.Request) bool {
+			if r.URL.Path == "/plugins/com.mattermost.calls/bot/calls/8w8jorhr7j83uqr6y1st894hqe/recordings" && r.Method == http.MethodPost {
+				_ = json.NewDecoder(r.Body).Decode(&jobInfo)
+				w.WriteHeader(200)
+				return true
+			}
+
+			return false
+		},
+	}
+
+	writeState := func(t *testing.T, checksum string) {
+		t.Helper()
+		require.NoError(t, os.WriteFile(outPath, []byte(fileContent), 0600))
+		state := &uploadState{
+			OutPath: outPath,
+			Sessions: map[string]uploadSessionState{
+				outPath: {UploadID: "uploadID", Checksum: checksum, Offset: 5},
+			},
+		}
+		require.NoError(t, state.save(rec.uploadStatePath()))
+		creations = 0
+		uploadedData.Reset()
+		jobInfo = public.JobInfo{}
+	}
+
+	t.Run("resume from offset", func(t *testing.T) {
+		writeState(t, sha256Hex([]byte(fileContent)))
+
+		resumed, err := rec.ResumeUpload()
+		require.NoError(t, err)
+		require.True(t, resumed)
+		require.Zero(t, creations)
+		require.Equal(t, fileContent[10:], uploadedData.String())
+		require.Equal(t, []string{"fileID"}, jobInfo.FileIDs)
+
+		require.NoFileExists(t, rec.uploadStatePath())
+		require.NoFileExists(t, outPath)
+		require.Empty(t, rec.uploadSessions)
+	})
+
+	t.Run("unchanged file", func(t *testing.T) {
+		// The stored checksum is trusted as long as the file looks the same,
+		// which saves hashing it again.
+		writeState(t, "stored checksum")
+		info, err := os.Stat(outPath)
+		require.NoError(t, err)
+		state := &uploadState{
+			OutPath: outPath,
+			Sessions: map[string]uploadSessionState{
+				outPath: {
+					UploadID: "uploadID",
+					Checksum: "stored checksum",
+					Offset:   5,
+					FileSize: info.Size(),
+					ModTime:  info.ModTime(),
+				},
+			},
+		}
+		require.NoError(t, state.save(rec.uploadStatePath()))
+
+		resumed, err := rec.ResumeUpload()
+		require.NoError(t, err)
+		require.True(t, resumed)
+		require.Zero(t, creations)
+		require.Equal(t, fileContent[10:], uploadedData.String())
+		require.NoFileExists(t, rec.uploadStatePath())
+	})
+
+	t.Run("changed file", func(t *testing.T) {
+		writeState(t, sha256Hex([]byte("other content")))
+
+		resumed, err := rec.ResumeUpload()
+		require.NoError(t, err)
+		require.True(t, resumed)
+		require.Equal(t, 1, creations)
+		require.Equal(t, fileContent, uploadedData.String())
+		require.Equal(t, []string{"fileID"}, jobInfo.FileIDs)
+		require.NoFileExists(t, rec.uploadStatePath())
+	})
+}
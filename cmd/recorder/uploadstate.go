@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mattermost/calls-recorder/cmd/recorder/config"
+)
+
+const (
+	uploadStateFilename = "upload-state.json"
+)
+
+// uploadSessionState is what's needed to resume uploading a file to a
+// previously created upload session.
+type uploadSessionState struct {
+	UploadID string `json:"upload_id"`
+	// SHA256 of the file, so that a session is never resumed with different
+	// content.
+	Checksum string `json:"checksum"`
+	// Offset is the amount of data known to be uploaded. The server's value
+	// takes precedence when resuming.
+	Offset int64 `json:"offset"`
+	// FileSize and ModTime are those of the file when its checksum was
+	// computed. As long as they match, the checksum is reused rather than
+	// hashing the whole file again on every attempt.
+	FileSize int64     `json:"file_size,omitempty"`
+	ModTime  time.Time `json:"mod_time,omitempty"`
+}
+
+// uploadState is the progress of publishing a recording. It's persisted in
+// the job's data directory so that it survives the process being restarted.
+type uploadState struct {
+	OutPath    string   `json:"out_path"`
+	ExtraFiles []string `json:"extra_files,omitempty"`
+	// target -> path -> location of the files stored so far
+	StoredFiles map[config.StorageTarget]map[string]string `json:"stored_files,omitempty"`
+	// path -> upload session of the files being uploaded to Mattermost
+	Sessions map[string]uploadSessionState `json:"sessions,omitempty"`
+}
+
+// loadUploadState reads the state at the given path. A nil state is returned
+// if there's none.
+func loadUploadState(path string) (*uploadState, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	var state uploadState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal state: %w", err)
+	} else if state.OutPath == "" {
+		return nil, fmt.Errorf("invalid empty out path")
+	}
+
+	return &state, nil
+}
+
+func (s *uploadState) save(path string) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("failed to marshal state: %w", err)
+	}
+
+	// Writing to a temporary file first so that an interruption never leaves
+	// a truncated state behind.
+	tmpPath := filepath.Join(filepath.Dir(path), ".tmp-"+filepath.Base(path))
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to rename file: %w", err)
+	}
+
+	return nil
+}
+
+func (rec *Recorder) uploadStatePath() string {
+	return filepath.Join(rec.dataPath, uploadStateFilename)
+}
+
+// saveUploadState persists the current upload progress, if publishing. Other
+// uploads, such as diagnostics, are not worth resuming. Failing to do so only
+// means a restarted process can't resume, so errors are just logged.
+func (rec *Recorder) saveUploadState() {
+	rec.uploadSessionsMut.Lock()
+	defer rec.uploadSessionsMut.Unlock()
+	rec.saveUploadStateLocked()
+}
+
+func (rec *Recorder) saveUploadStateLocked() {
+	if !rec.publishing {
+		return
+	}
+
+	state := uploadState{
+		OutPath:     rec.outPath,
+		ExtraFiles:  rec.extraFiles,
+		StoredFiles: rec.uploadedFiles,
+		Sessions:    rec.uploadSessions,
+	}
+	if err := state.save(rec.uploadStatePath()); err != nil {
+		slog.Error("failed to save upload state", slog.String("err", err.Error()))
+	}
+}
+
+func (rec *Recorder) removeUploadState() {
+	if err := os.Remove(rec.uploadStatePath()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		slog.Error("failed to remove upload state", slog.String("err", err.Error()))
+	}
+}
+
+func (rec *Recorder) getUploadSession(path string) (uploadSessionState, bool) {
+	rec.uploadSessionsMut.Lock()
+	defer rec.uploadSessionsMut.Unlock()
+	session, ok := rec.uploadSessions[path]
+	return session, ok
+}
+
+func (rec *Recorder) setUploadSession(path string, session uploadSessionState) {
+	rec.uploadSessionsMut.Lock()
+	defer rec.uploadSessionsMut.Unlock()
+	rec.uploadSessions[path] = session
+	rec.saveUploadStateLocked()
+}
+
+func (rec *Recorder) clearUploadSession(path string) {
+	rec.uploadSessionsMut.Lock()
+	defer rec.uploadSessionsMut.Unlock()
+	delete(rec.uploadSessions, path)
+	rec.saveUploadStateLocked()
+}
+
+// ResumeUpload finishes publishing a recording whose upload got interrupted
+// by the process exiting. It returns false if there was none.
+func (rec *Recorder) ResumeUpload() (bool, error) {
+	state, err := loadUploadState(rec.uploadStatePath())
+	if err != nil {
+		slog.Error("failed to load upload state, ignoring", slog.String("err", err.Error()))
+		rec.removeUploadState()
+		return false, nil
+	} else if state == nil {
+		return false, nil
+	}
+
+	slog.Info("resuming interrupted upload",
+		slog.String("outpath", state.OutPath),
+		slog.Int("sessions", len(state.Sessions)))
+
+	rec.outPath = state.OutPath
+	rec.extraFiles = state.ExtraFiles
+	if state.StoredFiles != nil {
+		rec.uploadedFiles = state.StoredFiles
+	}
+	if state.Sessions != nil {
+		rec.uploadSessions = state.Sessions
+	}
+
+	return true, rec.publishAndCleanUp()
+}
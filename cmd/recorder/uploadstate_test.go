@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mattermost/calls-recorder/cmd/recorder/config"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUploadState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), uploadStateFilename)
+
+	t.Run("missing", func(t *testing.T) {
+		state, err := loadUploadState(path)
+		require.NoError(t, err)
+		require.Nil(t, state)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(path, []byte("{"), 0600))
+		_, err := loadUploadState(path)
+		require.EqualError(t, err, "failed to unmarshal state: unexpected end of JSON input")
+
+		require.NoError(t, os.WriteFile(path, []byte("{}"), 0600))
+		_, err = loadUploadState(path)
+		require.EqualError(t, err, "invalid empty out path")
+	})
+
+	t.Run("round trip", func(t *testing.T) {
+		state := &uploadState{
+			OutPath:    "/data/recording.mp4",
+			ExtraFiles: []string{"/data/recording-attendance.json"},
+			StoredFiles: map[config.StorageTarget]map[string]string{
+				config.StorageTargetMattermost: {"/data/recording-attendance.json": "fileID"},
+			},
+			Sessions: map[string]uploadSessionState{
+				"/data/recording.mp4": {UploadID: "uploadID", Checksum: "checksum", Offset: 1024},
+			},
+		}
+		require.NoError(t, state.save(path))
+
+		loaded, err := loadUploadState(path)
+		require.NoError(t, err)
+		require.Equal(t, state, loaded)
+
+		// No temporary files should be left behind.
+		entries, err := os.ReadDir(filepath.Dir(path))
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+	})
+}
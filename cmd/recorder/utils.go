@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"math"
 	"net/http"
 	"net/url"
 	"os"
@@ -14,10 +15,10 @@ import (
 	"regexp"
 	"runtime"
 	"strings"
-	"time"
 
 	"github.com/mattermost/calls-recorder/cmd/recorder/config"
 
+	"github.com/chromedp/cdproto/emulation"
 	"github.com/chromedp/chromedp"
 )
 
@@ -25,6 +26,15 @@ var (
 	unpriviledgeUsersCloneSysctlPath = "/proc/sys/kernel/unprivileged_userns_clone"
 	icePasswordRE                    = regexp.MustCompile(`ice-pwd:[\w|\+|/]+`)
 	filenameSanitizationRE           = regexp.MustCompile(`[\\:*?\"<>|\n\s/]`)
+
+	// Origins of the common development setups, allowed in DEV_MODE unless
+	// INSECURE_ORIGINS is set.
+	devModeInsecureOrigins = []string{
+		"http://172.17.0.1:8065",
+		"http://host.docker.internal:8065",
+		"http://mm-server:8065",
+		"http://host.minikube.internal:8065",
+	}
 )
 
 func sanitizeConsoleLog(str string) string {
@@ -59,38 +69,6 @@ func checkOSRequirements() error {
 	return nil
 }
 
-func pollBrowserEvaluateExpr(ctx context.Context, expr string, interval, timeout time.Duration, stopCh chan struct{}) error {
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
-
-	var timeoutCh <-chan time.Time
-	if timeout > 0 {
-		timer := time.NewTimer(timeout)
-		defer timer.Stop()
-		timeoutCh = timer.C
-	}
-
-	for {
-		select {
-		case <-timeoutCh:
-			return fmt.Errorf("timed out")
-		case <-stopCh:
-			return fmt.Errorf("stop signal received while polling")
-		case <-ticker.C:
-			var res bool
-			if err := chromedp.Run(ctx,
-				chromedp.Evaluate(expr, &res),
-			); err != nil {
-				slog.Error("failed to run chromedp", slog.String("err", err.Error()))
-			} else if res {
-				slog.Info("expression succeeded", slog.String("expr", expr))
-				return nil
-			}
-			slog.Debug("expression failed", slog.String("expr", expr))
-		}
-	}
-}
-
 func getDataDir(jobID string) string {
 	if dir := os.Getenv("DATA_DIR"); dir != "" {
 		return filepath.Join(dir, jobID)
@@ -182,19 +160,66 @@ func getInsecureOrigins(siteURL string) ([]string, error) {
 		}
 	}
 
-	if devMode := os.Getenv("DEV_MODE"); devMode == "true" {
-		insecureOrigins = append(insecureOrigins, []string{
-			"http://172.17.0.1:8065",
-			"http://host.docker.internal:8065",
-			"http://mm-server:8065",
-			"http://host.minikube.internal:8065",
-		}...)
+	// An explicit list of origins replaces the development defaults.
+	if val := os.Getenv("INSECURE_ORIGINS"); val != "" {
+		origins, err := parseInsecureOrigins(val)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse INSECURE_ORIGINS: %w", err)
+		}
+		insecureOrigins = append(insecureOrigins, origins...)
+	} else if devMode := os.Getenv("DEV_MODE"); devMode == "true" {
+		insecureOrigins = append(insecureOrigins, devModeInsecureOrigins...)
 	}
 
 	return insecureOrigins, nil
 }
 
-func genChromiumOptions(cfg config.RecorderConfig) ([]chromedp.ExecAllocatorOption, []chromedp.ContextOption, error) {
+// parseInsecureOrigins parses a comma separated list of plain text origins.
+func parseInsecureOrigins(val string) ([]string, error) {
+	var origins []string
+	for _, origin := range strings.Split(val, ",") {
+		origin = strings.TrimSpace(origin)
+		if origin == "" {
+			continue
+		}
+
+		u, err := url.Parse(origin)
+		if err != nil {
+			return nil, fmt.Errorf("invalid origin %q: %w", origin, err)
+		} else if u.Scheme != "http" || u.Host == "" || strings.TrimSuffix(u.Path, "/") != "" {
+			return nil, fmt.Errorf("invalid origin %q: should be in http://host[:port] format", origin)
+		}
+
+		origins = append(origins, u.Scheme+"://"+u.Host)
+	}
+	return origins, nil
+}
+
+// genEmulationTasks returns the actions overriding the page environment as
+// configured. They need to run before navigating.
+func genEmulationTasks(cfg config.RecorderConfig) chromedp.Tasks {
+	var tasks chromedp.Tasks
+
+	if cfg.Timezone != "" {
+		tasks = append(tasks, emulation.SetTimezoneOverride(cfg.Timezone))
+	}
+
+	// The physical size of the page stays the same so that it fills the
+	// captured area. Higher scale factors shrink the layout viewport
+	// accordingly.
+	if cfg.DeviceScale > 1 {
+		tasks = append(tasks, emulation.SetDeviceMetricsOverride(
+			int64(math.Round(float64(cfg.Width)/cfg.DeviceScale)),
+			int64(math.Round(float64(cfg.Height)/cfg.DeviceScale)),
+			cfg.DeviceScale,
+			false,
+		))
+	}
+
+	return tasks
+}
+
+func genChromiumOptions(cfg config.RecorderConfig, displayID int) ([]chromedp.ExecAllocatorOption, []chromedp.ContextOption, error) {
 	opts := []chromedp.ExecAllocatorOption{
 		chromedp.NoFirstRun,
 		chromedp.NoDefaultBrowserCheck,
@@ -233,7 +258,26 @@ func genChromiumOptions(cfg config.RecorderConfig) ([]chromedp.ExecAllocatorOpti
 		chromedp.Flag("autoplay-policy", "no-user-gesture-required"),
 		chromedp.Flag("window-position", "0,0"),
 		chromedp.Flag("window-size", fmt.Sprintf("%d,%d", cfg.Width, cfg.Height)),
-		chromedp.Flag("display", fmt.Sprintf(":%d", displayID)),
+	}
+
+	if cfg.CaptureMode == config.CaptureModeScreencast {
+		// We can't use chromedp.Headless since it also mutes audio which we
+		// still need to capture.
+		opts = append(opts,
+			chromedp.Flag("headless", "new"),
+			chromedp.Flag("hide-scrollbars", true),
+		)
+	} else {
+		opts = append(opts, chromedp.Flag("display", fmt.Sprintf(":%d", displayID)))
+	}
+
+	if cfg.Locale != "" {
+		// The lang flag is not enough on Linux, where the UI language is
+		// picked from the environment.
+		opts = append(opts,
+			chromedp.Flag("lang", cfg.Locale),
+			chromedp.Env("LANGUAGE="+strings.ReplaceAll(cfg.Locale, "-", "_")),
+		)
 	}
 
 	contextOpts := []chromedp.ContextOption{
@@ -253,22 +297,40 @@ func genChromiumOptions(cfg config.RecorderConfig) ([]chromedp.ExecAllocatorOpti
 		opts = append(opts, chromedp.Flag("unsafely-treat-insecure-origin-as-secure", strings.Join(insecureOrigins, ",")))
 	}
 
+	if mappings, err := getHostMappings(); err != nil {
+		return nil, nil, err
+	} else if len(mappings) > 0 {
+		rules := chromiumHostResolverRules(mappings)
+		slog.Info("adding host resolver rules", slog.String("rules", rules))
+		opts = append(opts, chromedp.Flag("host-resolver-rules", rules))
+	}
+
+	if proxy, err := getProxyConfig(); err != nil {
+		return nil, nil, fmt.Errorf("failed to get proxy config: %w", err)
+	} else if proxyOpts := proxy.chromiumOptions(); len(proxyOpts) > 0 {
+		slog.Info("configuring browser proxy",
+			slog.String("server", proxy.chromiumProxyServer()),
+			slog.String("bypassList", proxy.chromiumBypassList()),
+			slog.String("pacURL", proxy.PACURL),
+		)
+		opts = append(opts, proxyOpts...)
+	}
+
 	// Support additional Chromium arguments via environment variable
 	// This allows flexible configuration without code changes
-	// Example: EXTRA_CHROMIUM_ARGS="--ignore-certificate-errors --disable-web-security"
-	if extraArgs := os.Getenv("EXTRA_CHROMIUM_ARGS"); extraArgs != "" {
-		slog.Info("adding extra Chromium arguments", slog.String("args", extraArgs))
-		args := strings.Fields(extraArgs)
-		for _, arg := range args {
-			// Handle both --flag and --flag=value formats
-			if strings.HasPrefix(arg, "--") {
-				parts := strings.SplitN(arg[2:], "=", 2)
-				if len(parts) == 2 {
-					opts = append(opts, chromedp.Flag(parts[0], parts[1]))
-				} else {
-					opts = append(opts, chromedp.Flag(parts[0], true))
-				}
-			}
+	// Example: EXTRA_CHROMIUM_ARGS="--ignore-certificate-errors --lang='en US'"
+	extraArgs, err := getExtraChromiumArgs()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(extraArgs) > 0 {
+		slog.Info("adding extra Chromium arguments", slog.String("args", os.Getenv("EXTRA_CHROMIUM_ARGS")))
+	}
+	for _, arg := range extraArgs {
+		if arg.HasValue {
+			opts = append(opts, chromedp.Flag(arg.Name, arg.Value))
+		} else {
+			opts = append(opts, chromedp.Flag(arg.Name, true))
 		}
 	}
 
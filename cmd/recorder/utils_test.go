@@ -9,6 +9,7 @@ import (
 
 	"github.com/mattermost/calls-recorder/cmd/recorder/config"
 
+	"github.com/chromedp/cdproto/emulation"
 	"github.com/stretchr/testify/require"
 )
 
@@ -133,7 +134,7 @@ func TestGenChromiumOptions(t *testing.T) {
 		var cfg config.RecorderConfig
 		cfg.SetDefaults()
 		cfg.SiteURL = "https://mm-server"
-		opts, ctxOpts, err := genChromiumOptions(cfg)
+		opts, ctxOpts, err := genChromiumOptions(cfg, displayIDMin)
 		require.NoError(t, err)
 		require.Len(t, opts, 34)
 		require.Len(t, ctxOpts, 1)
@@ -143,68 +144,132 @@ func TestGenChromiumOptions(t *testing.T) {
 		var cfg config.RecorderConfig
 		cfg.SetDefaults()
 		cfg.SiteURL = "http://mm-server"
-		opts, ctxOpts, err := genChromiumOptions(cfg)
+		opts, ctxOpts, err := genChromiumOptions(cfg, displayIDMin)
 		require.NoError(t, err)
 		require.Len(t, opts, 35)
 		require.Len(t, ctxOpts, 1)
 	})
 
+	t.Run("screencast capture mode", func(t *testing.T) {
+		var cfg config.RecorderConfig
+		cfg.SetDefaults()
+		cfg.SiteURL = "https://mm-server"
+		cfg.CaptureMode = config.CaptureModeScreencast
+		opts, ctxOpts, err := genChromiumOptions(cfg, displayIDMin)
+		require.NoError(t, err)
+		require.Len(t, opts, 35) // 34 base - display + headless flags
+		require.Len(t, ctxOpts, 1)
+	})
+
 	t.Run("dev mode", func(t *testing.T) {
 		os.Setenv("DEV_MODE", "true")
 		defer os.Unsetenv("DEV_MODE")
 		var cfg config.RecorderConfig
 		cfg.SetDefaults()
 		cfg.SiteURL = "http://localhost:8065"
-		opts, ctxOpts, err := genChromiumOptions(cfg)
+		opts, ctxOpts, err := genChromiumOptions(cfg, displayIDMin)
 		require.NoError(t, err)
 		require.Len(t, opts, 36)
 		require.Len(t, ctxOpts, 3)
 	})
 
+	t.Run("locale", func(t *testing.T) {
+		var cfg config.RecorderConfig
+		cfg.SetDefaults()
+		cfg.SiteURL = "https://mm-server"
+		cfg.Locale = "de-DE"
+		opts, ctxOpts, err := genChromiumOptions(cfg, displayIDMin)
+		require.NoError(t, err)
+		require.Len(t, opts, 36) // 34 base + lang flag + env
+		require.Len(t, ctxOpts, 1)
+	})
+
 	t.Run("extra chromium args - boolean flag", func(t *testing.T) {
 		os.Setenv("EXTRA_CHROMIUM_ARGS", "--ignore-certificate-errors")
 		defer os.Unsetenv("EXTRA_CHROMIUM_ARGS")
 		var cfg config.RecorderConfig
 		cfg.SetDefaults()
 		cfg.SiteURL = "https://mm-server"
-		opts, ctxOpts, err := genChromiumOptions(cfg)
+		opts, ctxOpts, err := genChromiumOptions(cfg, displayIDMin)
 		require.NoError(t, err)
 		require.Len(t, opts, 35) // 34 base + 1 extra
 		require.Len(t, ctxOpts, 1)
 	})
 
 	t.Run("extra chromium args - key=value flag", func(t *testing.T) {
-		os.Setenv("EXTRA_CHROMIUM_ARGS", "--proxy-server=http://proxy:8080")
+		os.Setenv("EXTRA_CHROMIUM_ARGS", "--disk-cache-size=1048576")
 		defer os.Unsetenv("EXTRA_CHROMIUM_ARGS")
 		var cfg config.RecorderConfig
 		cfg.SetDefaults()
 		cfg.SiteURL = "https://mm-server"
-		opts, ctxOpts, err := genChromiumOptions(cfg)
+		opts, ctxOpts, err := genChromiumOptions(cfg, displayIDMin)
 		require.NoError(t, err)
 		require.Len(t, opts, 35) // 34 base + 1 extra
 		require.Len(t, ctxOpts, 1)
 	})
 
 	t.Run("extra chromium args - multiple flags", func(t *testing.T) {
-		os.Setenv("EXTRA_CHROMIUM_ARGS", "--ignore-certificate-errors --proxy-server=http://proxy:8080")
+		os.Setenv("EXTRA_CHROMIUM_ARGS", "--ignore-certificate-errors --disk-cache-size=1048576")
+		defer os.Unsetenv("EXTRA_CHROMIUM_ARGS")
+		var cfg config.RecorderConfig
+		cfg.SetDefaults()
+		cfg.SiteURL = "https://mm-server"
+		opts, ctxOpts, err := genChromiumOptions(cfg, displayIDMin)
+		require.NoError(t, err)
+		require.Len(t, opts, 36) // 34 base + 2 extra
+		require.Len(t, ctxOpts, 1)
+	})
+
+	t.Run("extra chromium args - quoted value", func(t *testing.T) {
+		os.Setenv("EXTRA_CHROMIUM_ARGS", `--user-agent="Mozilla/5.0 (X11; Linux x86_64)" --disk-cache-size=1048576`)
 		defer os.Unsetenv("EXTRA_CHROMIUM_ARGS")
 		var cfg config.RecorderConfig
 		cfg.SetDefaults()
 		cfg.SiteURL = "https://mm-server"
-		opts, ctxOpts, err := genChromiumOptions(cfg)
+		opts, ctxOpts, err := genChromiumOptions(cfg, displayIDMin)
 		require.NoError(t, err)
 		require.Len(t, opts, 36) // 34 base + 2 extra
 		require.Len(t, ctxOpts, 1)
 	})
+
+	t.Run("extra chromium args - denied flag", func(t *testing.T) {
+		os.Setenv("EXTRA_CHROMIUM_ARGS", "--ignore-certificate-errors --remote-debugging-port=9222")
+		defer os.Unsetenv("EXTRA_CHROMIUM_ARGS")
+		var cfg config.RecorderConfig
+		cfg.SetDefaults()
+		cfg.SiteURL = "https://mm-server"
+		_, _, err := genChromiumOptions(cfg, displayIDMin)
+		require.EqualError(t, err, `failed to parse EXTRA_CHROMIUM_ARGS: argument "--remote-debugging-port" is not allowed`)
+	})
+}
+
+func TestGenEmulationTasks(t *testing.T) {
+	var cfg config.RecorderConfig
+	cfg.SetDefaults()
+
+	t.Run("defaults", func(t *testing.T) {
+		require.Empty(t, genEmulationTasks(cfg))
+	})
+
+	t.Run("overrides", func(t *testing.T) {
+		cfg := cfg
+		cfg.Timezone = "Europe/Berlin"
+		cfg.DeviceScale = 1.5
+		tasks := genEmulationTasks(cfg)
+		require.Len(t, tasks, 2)
+		require.Equal(t, emulation.SetTimezoneOverride("Europe/Berlin"), tasks[0])
+		require.Equal(t, emulation.SetDeviceMetricsOverride(1280, 720, 1.5, false), tasks[1])
+	})
 }
 
 func TestGetInsecureOrigins(t *testing.T) {
 	tcs := []struct {
-		name     string
-		siteURL  string
-		expected []string
-		err      string
-		devMode  bool
+		name            string
+		siteURL         string
+		expected        []string
+		err             string
+		devMode         bool
+		insecureOrigins string
 	}{
 		{
 			name: "empty string",
@@ -249,6 +314,37 @@ func TestGetInsecureOrigins(t *testing.T) {
 				"http://host.minikube.internal:8065",
 			},
 		},
+		{
+			name:            "explicit origins",
+			siteURL:         "https://localhost",
+			insecureOrigins: "http://mm.internal:8065, http://10.0.0.5/,",
+			expected: []string{
+				"http://mm.internal:8065",
+				"http://10.0.0.5",
+			},
+		},
+		{
+			name:            "explicit origins, dev mode",
+			siteURL:         "http://localhost",
+			devMode:         true,
+			insecureOrigins: "http://mm.internal:8065",
+			expected: []string{
+				"http://localhost",
+				"http://mm.internal:8065",
+			},
+		},
+		{
+			name:            "invalid explicit origin",
+			siteURL:         "https://localhost",
+			insecureOrigins: "https://mm.internal:8065",
+			err:             `failed to parse INSECURE_ORIGINS: invalid origin "https://mm.internal:8065": should be in http://host[:port] format`,
+		},
+		{
+			name:            "explicit origin with path",
+			siteURL:         "https://localhost",
+			insecureOrigins: "http://mm.internal/subpath",
+			err:             `failed to parse INSECURE_ORIGINS: invalid origin "http://mm.internal/subpath": should be in http://host[:port] format`,
+		},
 	}
 
 	for _, tc := range tcs {
@@ -257,6 +353,7 @@ func TestGetInsecureOrigins(t *testing.T) {
 				os.Setenv("DEV_MODE", "true")
 				defer os.Unsetenv("DEV_MODE")
 			}
+			t.Setenv("INSECURE_ORIGINS", tc.insecureOrigins)
 
 			origins, err := getInsecureOrigins(tc.siteURL)
 			if tc.err != "" {